@@ -0,0 +1,81 @@
+package screenshot
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestBGRA(t *testing.T) {
+	img := NewBGRA(image.Rect(-1, -1, 3, 3))
+	img.SetRGBA(0, 0, color.RGBA{1, 2, 3, 255})
+	if got, want := img.Pix[img.PixOffset(0, 0):][:4], []byte{3, 2, 1, 255}; string(got) != string(want) {
+		t.Errorf("pixel bytes = %v, want %v", got, want)
+	}
+	img.Set(1, 1, color.Gray{128})
+	if got, want := img.At(1, 1), (color.RGBA{128, 128, 128, 255}); got != want {
+		t.Errorf("At(1, 1) = %v, want %v", got, want)
+	}
+	if got := img.RGBAAt(5, 5); got != (color.RGBA{}) {
+		t.Errorf("RGBAAt(5, 5) = %v, want zero", got)
+	}
+	if img.Opaque() {
+		t.Errorf("Opaque() = true for a partially transparent image")
+	}
+
+	sub := img.SubImage(image.Rect(0, 0, 2, 2)).(*BGRA)
+	if got, want := sub.RGBAAt(0, 0), (color.RGBA{1, 2, 3, 255}); got != want {
+		t.Errorf("SubImage().RGBAAt(0, 0) = %v, want %v", got, want)
+	}
+	if !sub.SubImage(image.Rect(10, 10, 12, 12)).Bounds().Empty() {
+		t.Errorf("SubImage() outside of the image is not empty")
+	}
+
+	// BGRA is a draw.Image, which converts from and to image.RGBA.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(src, src.Rect, image.NewUniform(color.RGBA{10, 20, 30, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, img.Rect, src, image.Pt(-1, -1), draw.Src)
+	if got, want := img.RGBAAt(1, 1), (color.RGBA{10, 20, 30, 255}); got != want {
+		t.Errorf("RGBAAt(1, 1) after draw.Draw = %v, want %v", got, want)
+	}
+	back := image.NewRGBA(img.Rect)
+	draw.Draw(back, back.Rect, img, img.Rect.Min, draw.Src)
+	if got, want := back.RGBAAt(0, 0), (color.RGBA{10, 20, 30, 255}); got != want {
+		t.Errorf("image.RGBA pixel drawn from BGRA = %v, want %v", got, want)
+	}
+}
+
+func TestSwapRedBlue(t *testing.T) {
+	img := NewBGRA(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	view := img.rgbaView()
+	sub := view.SubImage(image.Rect(1, 0, 3, 2)).(*image.RGBA)
+	swapRedBlue(sub)
+	if got := img.RGBAAt(0, 0); got != (color.RGBA{2, 1, 0, 3}) {
+		t.Errorf("pixel outside of the sub-image = %v, want it left alone", got)
+	}
+	if got := img.RGBAAt(1, 1); got != (color.RGBA{16, 17, 18, 19}) {
+		t.Errorf("swapped pixel = %v", got)
+	}
+}
+
+func TestFrameImage(t *testing.T) {
+	frame := &Frame{Pix: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Stride: 4, Width: 1, Height: 2, Format: PixelFormatBGRA}
+	img, ok := frame.Image().(*BGRA)
+	if !ok {
+		t.Fatalf("Image() = %T, want *BGRA", frame.Image())
+	}
+	if got := img.RGBAAt(0, 1); got != (color.RGBA{7, 6, 5, 8}) {
+		t.Errorf("Image().RGBAAt(0, 1) = %v", got)
+	}
+	frame.Format = PixelFormatRGBA
+	if got := frame.Image().(*image.RGBA).RGBAAt(0, 1); got != (color.RGBA{5, 6, 7, 8}) {
+		t.Errorf("Image().RGBAAt(0, 1) = %v", got)
+	}
+	if got := PixelFormat(7).String(); got != "PixelFormat(7)" {
+		t.Errorf("String() = %q", got)
+	}
+}
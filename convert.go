@@ -0,0 +1,95 @@
+package screenshot
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+)
+
+// pixelOp is a conversion between byte orders of 4-byte pixels, shared by the backends to convert
+// the pixels of the display server into those of the returned image.
+type pixelOp uint8
+
+const (
+	// pixelSwap exchanges the first and third bytes of each pixel, which converts between the RGBA
+	// and BGRA orders.
+	pixelSwap pixelOp = 1 << iota
+	// pixelOpaque sets the fourth byte to 255, e.g. over the padding byte of BGRX pixels.
+	pixelOpaque
+	// pixelRotate moves the first byte of each pixel last, which converts XRGB to RGBX. It applies
+	// before pixelSwap.
+	pixelRotate
+)
+
+// parallelPixels is the number of pixels from which a conversion is split among goroutines. Below
+// it, starting them costs more than they save.
+const parallelPixels = 1 << 18
+
+// apply converts the two pixels held by v, the first one in its low bytes.
+func (op pixelOp) apply(v uint64) uint64 {
+	if op&pixelRotate != 0 {
+		v = v>>8&0x00ffffff00ffffff | v&0x000000ff000000ff<<24
+	}
+	if op&pixelSwap != 0 {
+		v = v>>16&0x000000ff000000ff | v&0xff00ff00ff00ff00 | v&0x000000ff000000ff<<16
+	}
+	if op&pixelOpaque != 0 {
+		v |= 0xff000000ff000000
+	}
+	return v
+}
+
+// convertPixels converts width by height pixels from src into dst, whose rows start srcStride and
+// dstStride bytes apart. dst and src may be the same memory, for a conversion in place.
+func convertPixels(dst []byte, dstStride int, src []byte, srcStride int, width, height int, op pixelOp) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+	parallelRows(width, height, 1, func(y0, y1 int) {
+		convertRows(dst[y0*dstStride:], dstStride, src[y0*srcStride:], srcStride, width, y1-y0, op)
+	})
+}
+
+// parallelRows calls f for consecutive ranges of rows [y0, y1) covering height rows, whose starts are
+// multiples of align. Large images are split among as many goroutines as there are CPUs.
+func parallelRows(width, height, align int, f func(y0, y1 int)) {
+	workers := min(runtime.GOMAXPROCS(0), width*height/parallelPixels)
+	if workers <= 1 {
+		f(0, height)
+		return
+	}
+	rows := (height + workers - 1) / workers
+	rows = (rows + align - 1) / align * align
+	var wg sync.WaitGroup
+	for y := 0; y < height; y += rows {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			f(y0, y1)
+		}(y, min(y+rows, height))
+	}
+	wg.Wait()
+}
+
+func convertRows(dst []byte, dstStride int, src []byte, srcStride int, width, height int, op pixelOp) {
+	for y := 0; y < height; y++ {
+		convertRow(dst[y*dstStride:y*dstStride+4*width], src[y*srcStride:y*srcStride+4*width], op)
+	}
+}
+
+// convertRow converts the pixels of src into dst, which has the same length. The pixels are handled
+// two by two as 64-bit words by convertWords, whose implementation depends on the architecture.
+func convertRow(dst, src []byte, op pixelOp) {
+	dst = dst[:len(src)]
+	if op == 0 {
+		copy(dst, src)
+		return
+	}
+	n := len(src) &^ 7
+	convertWords(dst[:n], src[:n], op)
+	if n < len(src) {
+		// The last pixel of an odd width.
+		v := op.apply(uint64(binary.LittleEndian.Uint32(src[n:])))
+		binary.LittleEndian.PutUint32(dst[n:], uint32(v))
+	}
+}
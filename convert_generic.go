@@ -0,0 +1,14 @@
+//go:build !amd64 && !arm64
+
+package screenshot
+
+import "encoding/binary"
+
+// convertWords converts the pixels of src, whose length is a multiple of 8, into dst, reading them as
+// little-endian 64-bit words whatever the alignment and the byte order of the architecture.
+func convertWords(dst, src []byte, op pixelOp) {
+	dst = dst[:len(src)]
+	for i := 0; i+8 <= len(src); i += 8 {
+		binary.LittleEndian.PutUint64(dst[i:], op.apply(binary.LittleEndian.Uint64(src[i:])))
+	}
+}
@@ -0,0 +1,109 @@
+package screenshot
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// convertPixelSlow is the reference implementation of a pixelOp, one byte at a time.
+func convertPixelSlow(p []byte, op pixelOp) []byte {
+	q := []byte{p[0], p[1], p[2], p[3]}
+	if op&pixelRotate != 0 {
+		q[0], q[1], q[2], q[3] = q[1], q[2], q[3], q[0]
+	}
+	if op&pixelSwap != 0 {
+		q[0], q[2] = q[2], q[0]
+	}
+	if op&pixelOpaque != 0 {
+		q[3] = 255
+	}
+	return q
+}
+
+func TestConvertPixels(t *testing.T) {
+	ops := []pixelOp{0, pixelSwap, pixelOpaque, pixelSwap | pixelOpaque, pixelRotate, pixelRotate | pixelOpaque, pixelRotate | pixelSwap | pixelOpaque}
+	sizes := []struct{ width, height int }{{1, 1}, {3, 2}, {4, 3}, {1024, 600}}
+	for _, op := range ops {
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("op=%d/%dx%d", op, size.width, size.height), func(t *testing.T) {
+				// Strides with padding, which must be left alone.
+				srcStride, dstStride := size.width*4+4, size.width*4+8
+				src := make([]byte, srcStride*size.height)
+				for i := range src {
+					src[i] = byte(i * 7)
+				}
+				dst := bytes.Repeat([]byte{0xaa}, dstStride*size.height)
+				convertPixels(dst, dstStride, src, srcStride, size.width, size.height, op)
+
+				inPlace := append([]byte(nil), src...)
+				convertPixels(inPlace, srcStride, inPlace, srcStride, size.width, size.height, op)
+
+				for y := 0; y < size.height; y++ {
+					for x := 0; x < size.width; x++ {
+						want := convertPixelSlow(src[y*srcStride+4*x:], op)
+						if got := dst[y*dstStride+4*x:][:4]; !bytes.Equal(got, want) {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+						}
+						if got := inPlace[y*srcStride+4*x:][:4]; !bytes.Equal(got, want) {
+							t.Fatalf("pixel (%d, %d) converted in place = %v, want %v", x, y, got, want)
+						}
+					}
+					if got := dst[y*dstStride+4*size.width:][:8]; !bytes.Equal(got, bytes.Repeat([]byte{0xaa}, 8)) {
+						t.Fatalf("padding of row %d = %v, want it left alone", y, got)
+					}
+				}
+			})
+		}
+	}
+}
+
+func benchmarkConvertPixels(b *testing.B, op pixelOp, width, height int) {
+	src := make([]byte, width*height*4)
+	dst := make([]byte, width*height*4)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		convertPixels(dst, width*4, src, width*4, width, height, op)
+	}
+}
+
+func BenchmarkConvertPixels(b *testing.B) {
+	for _, size := range []struct {
+		name          string
+		width, height int
+	}{{"1080p", 1920, 1080}, {"4K", 3840, 2160}} {
+		b.Run("BGRXToRGBA/"+size.name, func(b *testing.B) {
+			benchmarkConvertPixels(b, pixelSwap|pixelOpaque, size.width, size.height)
+		})
+		b.Run("BGRXToBGRA/"+size.name, func(b *testing.B) {
+			benchmarkConvertPixels(b, pixelOpaque, size.width, size.height)
+		})
+	}
+}
+
+// BenchmarkConvertRow measures a single goroutine converting a 4K frame row by row.
+func BenchmarkConvertRow(b *testing.B) {
+	const width, height = 3840, 2160
+	src := make([]byte, width*height*4)
+	dst := make([]byte, width*height*4)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		convertRows(dst, width*4, src, width*4, width, height, pixelSwap|pixelOpaque)
+	}
+}
+
+// BenchmarkConvertPixelByPixel is the conversion the backends used to make, for comparison.
+func BenchmarkConvertPixelByPixel(b *testing.B) {
+	const width, height = 3840, 2160
+	src := make([]byte, width*height*4)
+	dst := make([]byte, width*height*4)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < len(src); j += 4 {
+			dst[j], dst[j+1], dst[j+2], dst[j+3] = src[j+2], src[j+1], src[j], 255
+		}
+	}
+}
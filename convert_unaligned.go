@@ -0,0 +1,36 @@
+//go:build amd64 || arm64
+
+package screenshot
+
+import "unsafe"
+
+// convertWords converts the pixels of src, whose length is a multiple of 8, into dst. The bytes are
+// read as 64-bit words in place, which these little-endian architectures allow at any alignment, and
+// there is a loop for each of the common conversions so that the compiler keeps everything in
+// registers. This is several times faster than going through encoding/binary.
+func convertWords(dst, src []byte, op pixelOp) {
+	if len(src) == 0 {
+		return
+	}
+	s := unsafe.Slice((*uint64)(unsafe.Pointer(&src[0])), len(src)/8)
+	d := unsafe.Slice((*uint64)(unsafe.Pointer(&dst[0])), len(dst)/8)
+	d = d[:len(s)]
+	switch op {
+	case pixelSwap | pixelOpaque:
+		for i, v := range s {
+			d[i] = v>>16&0x000000ff000000ff | v&0x0000ff000000ff00 | v&0x000000ff000000ff<<16 | 0xff000000ff000000
+		}
+	case pixelSwap:
+		for i, v := range s {
+			d[i] = v>>16&0x000000ff000000ff | v&0xff00ff00ff00ff00 | v&0x000000ff000000ff<<16
+		}
+	case pixelOpaque:
+		for i, v := range s {
+			d[i] = v | 0xff000000ff000000
+		}
+	default:
+		for i, v := range s {
+			d[i] = op.apply(v)
+		}
+	}
+}
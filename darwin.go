@@ -65,12 +65,30 @@ static CGImageRef capture(CGDirectDisplayID id, CGRect diIntersectDisplayLocal,
     return copy;
 #endif
 }
+
+static void getDisplayModeInfo(CGDirectDisplayID id, double* refreshRate, double* scale) {
+    *refreshRate = 0;
+    *scale = 1;
+    CGDisplayModeRef mode = CGDisplayCopyDisplayMode(id);
+    if (!mode) {
+        return;
+    }
+    *refreshRate = CGDisplayModeGetRefreshRate(mode);
+    size_t width = CGDisplayModeGetWidth(mode);
+    if (width > 0) {
+        *scale = (double)CGDisplayModeGetPixelWidth(mode) / (double)width;
+    }
+    CGDisplayModeRelease(mode);
+}
 */
 import "C"
 
 import (
+	"context"
 	"errors"
 	"image"
+	"math"
+	"strconv"
 	"unsafe"
 )
 
@@ -84,6 +102,29 @@ func Capture(x, y, width, height int) (*image.RGBA, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = captureInto(img, image.Rect(x, y, x+width, y+height))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func captureInto(img *image.RGBA, rect image.Rectangle) error {
+	x, y := rect.Min.X, rect.Min.Y
+	width, height := rect.Dx(), rect.Dy()
+	if width <= 0 || height <= 0 {
+		return errors.New("width or height should be > 0")
+	}
+
+	// The bitmap context draws only where a display intersects the region, so clear the rest
+	// which may contain the previous frame when img is reused.
+	origin := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y)
+	for iy := 0; iy < height; iy++ {
+		row := img.Pix[origin+iy*img.Stride : origin+iy*img.Stride+width*4]
+		for i := range row {
+			row[i] = 0
+		}
+	}
 
 	// cg: CoreGraphics coordinate (origin: lower-left corner of primary display, x-axis: rightward, y-axis: upward)
 	// win: Windows coordinate (origin: upper-left corner of primary display, x-axis: rightward, y-axis: downward)
@@ -97,14 +138,15 @@ func Capture(x, y, width, height int) (*image.RGBA, error) {
 
 	ids := activeDisplayList()
 
-	ctx := createBitmapContext(width, height, (*C.uint32_t)(unsafe.Pointer(&img.Pix[0])), img.Stride)
+	ctx := createBitmapContext(width, height, (*C.uint32_t)(unsafe.Pointer(&img.Pix[origin])), img.Stride)
 	if ctx == 0 {
-		return nil, errors.New("cannot create bitmap context")
+		return errors.New("cannot create bitmap context")
 	}
+	defer C.CGContextRelease(ctx)
 
 	colorSpace := createColorspace()
 	if colorSpace == 0 {
-		return nil, errors.New("cannot create colorspace")
+		return errors.New("cannot create colorspace")
 	}
 	defer C.CGColorSpaceRelease(colorSpace)
 
@@ -132,7 +174,7 @@ func Capture(x, y, width, height int) (*image.RGBA, error) {
 
 		image := C.capture(id, diIntersectDisplayLocal, colorSpace)
 		if unsafe.Pointer(image) == nil {
-			return nil, errors.New("cannot capture display")
+			return errors.New("cannot capture display")
 		}
 		defer C.CGImageRelease(image)
 
@@ -141,18 +183,11 @@ func Capture(x, y, width, height int) (*image.RGBA, error) {
 		C.CGContextDrawImage(ctx, cgDrawRect, image)
 	}
 
-	i := 0
-	for iy := 0; iy < height; iy++ {
-		j := i
-		for ix := 0; ix < width; ix++ {
-			// ARGB => RGBA, and set A to 255
-			img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = img.Pix[j+1], img.Pix[j+2], img.Pix[j+3], 255
-			j += 4
-		}
-		i += img.Stride
-	}
+	// ARGB => RGBA, and set A to 255
+	pix := img.Pix[origin:]
+	convertPixels(pix, img.Stride, pix, img.Stride, width, height, pixelRotate|pixelOpaque)
 
-	return img, nil
+	return nil
 }
 
 func NumActiveDisplays() int {
@@ -185,6 +220,34 @@ func GetDisplayBounds(displayIndex int) image.Rectangle {
 	return rect
 }
 
+// displays describes the active displays, starting with the main display like GetDisplayBounds.
+func displays() ([]Display, error) {
+	n := NumActiveDisplays()
+	main := C.CGMainDisplayID()
+	ret := make([]Display, 0, n)
+	for i := 0; i < n; i++ {
+		id := getDisplayId(i)
+		if id == 0 {
+			continue
+		}
+		size := C.CGDisplayScreenSize(id)
+		var refreshRate, scale C.double
+		C.getDisplayModeInfo(id, &refreshRate, &scale)
+		ret = append(ret, Display{
+			Index:       i,
+			ID:          strconv.FormatUint(uint64(id), 10),
+			Primary:     id == main,
+			Bounds:      GetDisplayBounds(i),
+			WidthMM:     int(math.Round(float64(size.width))),
+			HeightMM:    int(math.Round(float64(size.height))),
+			Rotation:    Rotation(int(C.CGDisplayRotation(id))),
+			RefreshRate: float64(refreshRate),
+			ScaleFactor: float64(scale),
+		})
+	}
+	return ret, nil
+}
+
 func getDisplayId(displayIndex int) C.CGDirectDisplayID {
 	main := C.CGMainDisplayID()
 	if displayIndex == 0 {
@@ -250,3 +313,105 @@ func activeDisplayList() []C.CGDirectDisplayID {
 		return make([]C.CGDirectDisplayID, 0)
 	}
 }
+
+// session holds the resources shared by the calls made through a Capturer.
+// CoreGraphics does not need any, so every call is forwarded to the package-level functions.
+type session struct {
+	opts options
+}
+
+func newSession(opts options) (*session, error) {
+	return &session{opts: opts}, nil
+}
+
+// coreGraphicsBackend captures the displays through CoreGraphics.
+type coreGraphicsBackend struct{}
+
+var gBackends = []Backend{&coreGraphicsBackend{}}
+
+func (b *coreGraphicsBackend) Name() string {
+	return "coregraphics"
+}
+
+func (b *coreGraphicsBackend) probe(ctx context.Context, s *session) error {
+	return nil
+}
+
+// captureInto cannot interrupt the capture, so ctx is only checked before it starts.
+func (b *coreGraphicsBackend) captureInto(ctx context.Context, s *session, dst *image.RGBA, rect image.Rectangle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return captureInto(dst, rect)
+}
+
+// backendChain returns the backends probed in turn when none is selected by name.
+func (s *session) backendChain() []Backend {
+	return gBackends
+}
+
+func (s *session) selectBackend(ctx context.Context) (Backend, error) {
+	return chooseBackend(ctx, s)
+}
+
+func (s *session) captureInto(ctx context.Context, dst *image.RGBA, rect image.Rectangle) error {
+	b, err := s.selectBackend(ctx)
+	if err != nil {
+		return err
+	}
+	return b.captureInto(ctx, s, dst, rect)
+}
+
+// displayCount and the other enumerations cannot be interrupted, so ctx is only checked before them.
+func (s *session) displayCount(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	n := NumActiveDisplays()
+	if n == 0 {
+		return 0, ErrNoDisplay
+	}
+	return n, nil
+}
+
+func (s *session) displayBounds(ctx context.Context, displayIndex int) (image.Rectangle, error) {
+	n, err := s.displayCount(ctx)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if err := checkDisplayIndex(displayIndex, n); err != nil {
+		return image.Rectangle{}, err
+	}
+	return GetDisplayBounds(displayIndex), nil
+}
+
+func (s *session) displays(ctx context.Context) ([]Display, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return displays()
+}
+
+func (s *session) captureCursor() (*Cursor, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) trackChanges() (changeTracker, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) watchDisplays(ctx context.Context) (<-chan DisplayEvent, error) {
+	return nil, ErrUnsupported
+}
+
+func capturePortal(ctx context.Context, opts PortalOptions) (*image.RGBA, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) restoreToken() string {
+	return ""
+}
+
+func (s *session) close() error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package screenshot
+
+import (
+	"fmt"
+	"image"
+)
+
+// PixelFormat is the layout of the pixels of a Frame.
+type PixelFormat int
+
+const (
+	// PixelFormatRGBA stores 4 bytes per pixel, in R, G, B, A order, like image.RGBA.
+	PixelFormatRGBA PixelFormat = iota
+	// PixelFormatBGRA stores 4 bytes per pixel, in B, G, R, A order, like BGRA.
+	PixelFormatBGRA
+	// PixelFormatI420 is planar YUV 4:2:0: a Y plane, followed by Cb and Cr planes at half the
+	// resolution in both directions.
+	PixelFormatI420
+	// PixelFormatNV12 is YUV 4:2:0 with a Y plane and a single chroma plane, at half the resolution in
+	// both directions, whose Cb and Cr samples alternate.
+	PixelFormatNV12
+)
+
+func (f PixelFormat) String() string {
+	switch f {
+	case PixelFormatRGBA:
+		return "RGBA"
+	case PixelFormatBGRA:
+		return "BGRA"
+	case PixelFormatI420:
+		return "I420"
+	case PixelFormatNV12:
+		return "NV12"
+	}
+	return fmt.Sprintf("PixelFormat(%d)", int(f))
+}
+
+// Frame is a capture in the pixel format the backend produces natively, e.g. BGRA on X11, so that it
+// can be handed to an encoder or uploaded to a GPU without conversion, or in the YUV format selected
+// WithYUV.
+type Frame struct {
+	// Pix holds the pixels, or the Y plane of the YUV formats, the row y starting at Pix[y*Stride].
+	Pix []byte
+	// Stride is the number of bytes between vertically adjacent pixels.
+	Stride int
+	// Cb and Cr are the chroma planes of the YUV formats, whose rows start CStride bytes apart.
+	// PixelFormatNV12 interleaves both in Cb, and leaves Cr nil.
+	Cb, Cr  []byte
+	CStride int
+	// Width and Height are the size of the frame in pixels.
+	Width, Height int
+	// Format is the layout of the pixels.
+	Format PixelFormat
+	// YUV is the conversion which produced the samples of the YUV formats.
+	YUV YUVOptions
+}
+
+// Image returns an image sharing the pixels of the frame: an *image.RGBA or a *BGRA, depending on
+// the format. The YUV formats are returned as an *image.YCbCr, which shares the planes of I420 but
+// not of NV12. Its colors are only exact with YUVOptions{FullRange: true}, which is the conversion
+// of image.YCbCr.
+func (f *Frame) Image() image.Image {
+	rect := image.Rect(0, 0, f.Width, f.Height)
+	switch f.Format {
+	case PixelFormatBGRA:
+		return &BGRA{Pix: f.Pix, Stride: f.Stride, Rect: rect}
+	case PixelFormatI420, PixelFormatNV12:
+		return f.yCbCr()
+	default:
+		return &image.RGBA{Pix: f.Pix, Stride: f.Stride, Rect: rect}
+	}
+}
+
+// rgbaView returns an image.RGBA sharing the pixels of the frame, whatever their byte order.
+func (f *Frame) rgbaView() *image.RGBA {
+	return &image.RGBA{Pix: f.Pix, Stride: f.Stride, Rect: image.Rect(0, 0, f.Width, f.Height)}
+}
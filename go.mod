@@ -4,11 +4,9 @@ go 1.21
 
 require (
 	github.com/gen2brain/shm v0.1.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/jezek/xgb v1.1.1
 	github.com/lxn/win v0.0.0-20210218163916-a377121e959e
 )
 
-require (
-	golang.org/x/sys v0.24.0 // indirect
-	github.com/godbus/dbus/v5 v5.1.0 // indirect
-)
+require golang.org/x/sys v0.24.0 // indirect
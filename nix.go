@@ -3,79 +3,303 @@
 package screenshot
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/jezek/xgb"
-	"github.com/jezek/xgb/xinerama"
 	"image"
 )
 
+// Capture returns screen capture of specified desktop region.
+// x and y represent distance from the upper-left corner of primary display.
+// Y-axis is downward direction. This means coordinates system is similar to Windows OS.
+func Capture(x, y, width, height int) (*image.RGBA, error) {
+	img, err := createImage(image.Rect(0, 0, width, height))
+	if err != nil {
+		return nil, err
+	}
+	err = CaptureInto(img, image.Rect(x, y, x+width, y+height))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
 // NumActiveDisplays returns the number of active displays.
-func NumActiveDisplays() (num int) {
-	defer func() {
-		e := recover()
-		if e != nil {
-			num = 0
+// It returns 0 on any failure, see DisplayCount for the reason.
+func NumActiveDisplays() int {
+	n, err := DisplayCount()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// GetDisplayBounds returns the bounds of displayIndex'th display.
+// The main display is displayIndex = 0.
+// It returns an empty rectangle on any failure, see DisplayBounds for the reason.
+func GetDisplayBounds(displayIndex int) image.Rectangle {
+	rect, err := DisplayBounds(displayIndex)
+	if err != nil {
+		return image.Rectangle{}
+	}
+	return rect
+}
+
+// session holds the resources shared by the calls made through a Capturer.
+// The X11 connection is opened lazily, because a Wayland session may never need it.
+type session struct {
+	opts    options
+	backend Backend // selected on first use
+	x       *xSession
+	cast    *screenCast // started by the first capture through the ScreenCast portal
+	wl      *wlDesktop  // connected on first use on a Wayland session
+}
+
+// x11Backend captures the X11 display, with MIT-SHM or plain GetImage requests. MIT-SHM requires the
+// X server to run on the same host, which rules out e.g. a display forwarded through SSH.
+type x11Backend struct {
+	name string
+	shm  bool
+}
+
+var (
+	backendX11Shm      Backend = &x11Backend{name: "x11-shm", shm: true}
+	backendX11GetImage Backend = &x11Backend{name: "x11-getimage"}
+)
+
+func (b *x11Backend) Name() string {
+	return b.name
+}
+
+func (b *x11Backend) probe(ctx context.Context, s *session) error {
+	return s.withXWindow(ctx, func(xs *xSession) error {
+		if !b.shm {
+			return nil
 		}
-	}()
+		if !xs.useShm {
+			return fmt.Errorf("%w: MIT-SHM", ErrExtensionMissing)
+		}
+		// Attaching a segment is what fails when the X server is remote.
+		if _, err := xs.shmBuffer(4); err != nil {
+			return fmt.Errorf("%w: MIT-SHM: %w", ErrExtensionMissing, err)
+		}
+		return nil
+	})
+}
+
+func (b *x11Backend) captureInto(ctx context.Context, s *session, dst *image.RGBA, rect image.Rectangle) error {
+	return b.withXWindow(ctx, s, func(xs *xSession) error {
+		return xs.captureInto(dst, false, rect)
+	})
+}
 
-	c, err := xgb.NewConn()
+func (b *x11Backend) captureBGRAInto(ctx context.Context, s *session, dst *BGRA, rect image.Rectangle) error {
+	return b.withXWindow(ctx, s, func(xs *xSession) error {
+		return xs.captureInto(dst.rgbaView(), true, rect)
+	})
+}
+
+func (b *x11Backend) captureFrame(ctx context.Context, s *session, rect image.Rectangle) (frame *Frame, err error) {
+	err = b.withXWindow(ctx, s, func(xs *xSession) error {
+		frame, err = xs.captureFrame(rect)
+		return err
+	})
+	return frame, err
+}
+
+// withXWindow calls f with the X11 connection of the session, set up for the backend.
+func (b *x11Backend) withXWindow(ctx context.Context, s *session, f func(xs *xSession) error) error {
+	return s.withXWindow(ctx, func(xs *xSession) error {
+		if !b.shm {
+			xs.useShm = false
+		}
+		return f(xs)
+	})
+}
+
+// selectBackend returns the backend of the session, selecting it on first use.
+func (s *session) selectBackend(ctx context.Context) (Backend, error) {
+	if s.backend != nil {
+		return s.backend, nil
+	}
+	b, err := chooseBackend(ctx, s)
 	if err != nil {
-		return 0
+		return nil, err
 	}
-	defer c.Close()
+	s.backend = b
+	return b, nil
+}
 
-	err = xinerama.Init(c)
+// requireX11 returns nil if the session captures the X11 display, for the features available only there.
+func (s *session) requireX11() error {
+	b, err := s.selectBackend(context.Background())
 	if err != nil {
-		return 0
+		return err
 	}
+	if _, ok := b.(*x11Backend); !ok {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// displaySource is implemented by the backends which enumerate the displays by themselves.
+// The displays of the other backends are those of the X11 display, e.g. XWayland.
+type displaySource interface {
+	displays(ctx context.Context, s *session) ([]Display, error)
+}
 
-	reply, err := xinerama.QueryScreens(c).Reply()
+func (s *session) displaySource(ctx context.Context) (displaySource, bool) {
+	b, err := s.selectBackend(ctx)
 	if err != nil {
-		return 0
+		return nil, false
 	}
+	src, ok := b.(displaySource)
+	return src, ok
+}
 
-	num = int(reply.Number)
-	return num
+func (s *session) captureInto(ctx context.Context, dst *image.RGBA, rect image.Rectangle) error {
+	b, err := s.selectBackend(ctx)
+	if err != nil {
+		return err
+	}
+	return b.captureInto(ctx, s, dst, rect)
 }
 
-// GetDisplayBounds returns the bounds of displayIndex'th display.
-// The main display is displayIndex = 0.
-func GetDisplayBounds(displayIndex int) (rect image.Rectangle) {
+func newSession(opts options) (*session, error) {
+	return &session{opts: opts}, nil
+}
+
+// xwindow returns the X11 connection of the session, connecting on first use.
+func (s *session) xwindow(ctx context.Context) (*xSession, error) {
+	if s.x != nil {
+		return s.x, nil
+	}
+	x, err := openXSession(ctx, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing else reads the events of the connection.
+	x.pollEvents = true
+	s.x = x
+	return x, nil
+}
+
+// resetXWindow drops the X11 connection, so that the next call reconnects.
+// It is used after a failure which may have left the connection unusable.
+func (s *session) resetXWindow() {
+	if s.x != nil {
+		s.x.close()
+		s.x = nil
+	}
+}
+
+// withXWindow calls f with the X11 connection of the session. The connection is dropped if f fails
+// in a way which may have left it unusable, and a panic from the X11 bindings is turned into an error.
+// Once ctx is done, the requests of f fail, and the connection is dropped with the shared memory
+// segment attached to it. The backend of the session turns from MIT-SHM to GetImage when f fell back to it.
+func (s *session) withXWindow(ctx context.Context, f func(xs *xSession) error) (e error) {
 	defer func() {
-		e := recover()
-		if e != nil {
-			rect = image.Rectangle{}
+		err := recover()
+		if err != nil {
+			s.resetXWindow()
+			e = fmt.Errorf("screenshot: X11 request failed: %v", err)
 		}
 	}()
 
-	c, err := xgb.NewConn()
+	xs, err := s.xwindow(ctx)
 	if err != nil {
-		return image.Rectangle{}
+		return err
+	}
+	stop := abortXOnDone(ctx, xs.netConn)
+	err = f(xs)
+	// Once the X server fails to attach a segment, the reads fall back to GetImage, see shmBuffer.
+	if !xs.useShm && s.backend == backendX11Shm {
+		s.backend = backendX11GetImage
 	}
-	defer c.Close()
+	if !stop() {
+		s.resetXWindow()
+		if err != nil {
+			return ctx.Err()
+		}
+		return nil
+	}
+	if err != nil && !isRequestError(err) {
+		s.resetXWindow()
+	}
+	return err
+}
 
-	err = xinerama.Init(c)
-	if err != nil {
-		return image.Rectangle{}
+// isRequestError reports whether err is a failure of a single request, e.g. an X protocol error
+// or an invalid argument, after which the connection is still usable.
+func isRequestError(err error) bool {
+	var xerr xgb.Error
+	return errors.As(err, &xerr) || errors.Is(err, ErrWindowNotFound) || errors.Is(err, ErrExtensionMissing)
+}
+
+func (s *session) displayCount(ctx context.Context) (num int, e error) {
+	if src, ok := s.displaySource(ctx); ok {
+		displays, err := src.displays(ctx, s)
+		return len(displays), err
 	}
+	e = s.withXWindow(ctx, func(xs *xSession) error {
+		bounds, err := xs.monitorBounds()
+		if err != nil {
+			return err
+		}
+		num = len(bounds)
+		return nil
+	})
+	return num, e
+}
 
-	reply, err := xinerama.QueryScreens(c).Reply()
+func (s *session) displayBounds(ctx context.Context, displayIndex int) (image.Rectangle, error) {
+	if src, ok := s.displaySource(ctx); ok {
+		displays, err := src.displays(ctx, s)
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		if err := checkDisplayIndex(displayIndex, len(displays)); err != nil {
+			return image.Rectangle{}, err
+		}
+		return displays[displayIndex].Bounds, nil
+	}
+	var bounds []image.Rectangle
+	err := s.withXWindow(ctx, func(xs *xSession) error {
+		var err error
+		bounds, err = xs.monitorBounds()
+		return err
+	})
 	if err != nil {
-		return image.Rectangle{}
+		return image.Rectangle{}, err
 	}
-
-	if displayIndex >= int(reply.Number) {
-		return image.Rectangle{}
+	if err := checkDisplayIndex(displayIndex, len(bounds)); err != nil {
+		return image.Rectangle{}, err
 	}
+	return bounds[displayIndex], nil
+}
 
-	primary := reply.ScreenInfo[0]
-	x0 := int(primary.XOrg)
-	y0 := int(primary.YOrg)
+func (s *session) displays(ctx context.Context) (displays []Display, e error) {
+	if src, ok := s.displaySource(ctx); ok {
+		return src.displays(ctx, s)
+	}
+	e = s.withXWindow(ctx, func(xs *xSession) error {
+		var err error
+		displays, err = xs.displays()
+		return err
+	})
+	return displays, e
+}
 
-	screen := reply.ScreenInfo[displayIndex]
-	x := int(screen.XOrg) - x0
-	y := int(screen.YOrg) - y0
-	w := int(screen.Width)
-	h := int(screen.Height)
-	rect = image.Rect(x, y, x+w, y+h)
-	return rect
+func (s *session) close() error {
+	s.resetXWindow()
+	if s.cast != nil {
+		s.cast.close()
+		s.cast = nil
+	}
+	if s.wl != nil {
+		s.wl.close()
+		s.wl = nil
+	}
+	return nil
 }
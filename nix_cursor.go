@@ -0,0 +1,75 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"fmt"
+	"github.com/jezek/xgb/xfixes"
+	"image"
+)
+
+func (s *session) captureCursor() (cursor *Cursor, e error) {
+	// XWayland only knows about the cursor while it is over an X11 window.
+	if err := s.requireX11(); err != nil {
+		return nil, err
+	}
+	e = s.withXWindow(context.Background(), func(xs *xSession) error {
+		var err error
+		cursor, err = xs.captureCursor()
+		return err
+	})
+	return cursor, e
+}
+
+// initXFixes initializes the XFIXES extension on first use.
+// GetCursorImage requires version 1.0, and the version must be negotiated before any other request.
+func (s *xSession) initXFixes() error {
+	if s.hasXFixes {
+		return nil
+	}
+	err := xfixes.Init(s.conn)
+	if err != nil {
+		return fmt.Errorf("%w: XFIXES: %w", ErrExtensionMissing, err)
+	}
+	_, err = xfixes.QueryVersion(s.conn, 4, 0).Reply()
+	if err != nil {
+		return err
+	}
+	s.hasXFixes = true
+	return nil
+}
+
+func (s *xSession) captureCursor() (*Cursor, error) {
+	err := s.initXFixes()
+	if err != nil {
+		return nil, err
+	}
+	origin, err := s.origin()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := xfixes.GetCursorImage(s.conn).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := int(reply.Width), int(reply.Height)
+	img, err := createImage(image.Rect(0, 0, width, height))
+	if err != nil {
+		return nil, err
+	}
+	// Pixels are premultiplied ARGB, which only needs reordering into image.RGBA.
+	for i, argb := range reply.CursorImage[:width*height] {
+		img.Pix[i*4+0] = byte(argb >> 16)
+		img.Pix[i*4+1] = byte(argb >> 8)
+		img.Pix[i*4+2] = byte(argb)
+		img.Pix[i*4+3] = byte(argb >> 24)
+	}
+
+	return &Cursor{
+		Image:    img,
+		Position: image.Pt(int(reply.X), int(reply.Y)).Sub(origin),
+		Hotspot:  image.Pt(int(reply.Xhot), int(reply.Yhot)),
+	}, nil
+}
@@ -0,0 +1,215 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"fmt"
+	"github.com/jezek/xgb/damage"
+	"github.com/jezek/xgb/xproto"
+	"image"
+	"sync"
+)
+
+// maxDirtyRects is the number of separate dirty rectangles a damageTracker keeps. Beyond it, the
+// rectangles are merged into their bounding box, which is cheaper to read than many small ones.
+const maxDirtyRects = 32
+
+// damageTracker records the damaged regions of the root window through the DAMAGE extension.
+// It uses a connection of its own, so that waiting for events does not hold up the captures.
+type damageTracker struct {
+	xs     *xSession
+	damage damage.Damage
+
+	mu      sync.Mutex
+	dirty   []image.Rectangle // in root window coordinates
+	closed  bool
+	changed chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (s *session) trackChanges() (changeTracker, error) {
+	if err := s.requireX11(); err != nil {
+		return nil, err
+	}
+	return newDamageTracker(s.opts)
+}
+
+func newDamageTracker(opts options) (t *damageTracker, e error) {
+	xs, err := openXSession(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := recover()
+		if err != nil {
+			e = fmt.Errorf("%v", err)
+		}
+		if e != nil {
+			xs.close()
+			t = nil
+		}
+	}()
+
+	err = damage.Init(xs.conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: DAMAGE: %w", ErrExtensionMissing, err)
+	}
+	// The version must be negotiated before any other request of the extension.
+	_, err = damage.QueryVersion(xs.conn, 1, 1).Reply()
+	if err != nil {
+		return nil, err
+	}
+	id, err := damage.NewDamageId(xs.conn)
+	if err != nil {
+		return nil, err
+	}
+	err = damage.CreateChecked(xs.conn, id, xproto.Drawable(xs.screen.Root), damage.ReportLevelRawRectangles).Check()
+	if err != nil {
+		return nil, err
+	}
+
+	t = &damageTracker{
+		xs:      xs,
+		damage:  id,
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go t.readEvents()
+	return t, nil
+}
+
+// readEvents records the damage reported by the X server until the connection is closed.
+func (t *damageTracker) readEvents() {
+	defer close(t.done)
+	for {
+		ev, err := t.xs.conn.WaitForEvent()
+		if ev == nil && err == nil {
+			return
+		}
+		if isScreenChange(ev) {
+			t.xs.invalidateScreens()
+			continue
+		}
+		notify, ok := ev.(damage.NotifyEvent)
+		if !ok || notify.Damage != t.damage {
+			continue
+		}
+		area := notify.Area
+		t.add(image.Rect(int(area.X), int(area.Y), int(area.X)+int(area.Width), int(area.Y)+int(area.Height)))
+	}
+}
+
+// add records r as dirty, merging it with the rectangles it overlaps.
+func (t *damageTracker) add(r image.Rectangle) {
+	if r.Empty() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := 0; i < len(t.dirty); {
+		if t.dirty[i].Overlaps(r) {
+			r = r.Union(t.dirty[i])
+			t.dirty = append(t.dirty[:i], t.dirty[i+1:]...)
+			// The union may overlap rectangles which were checked already.
+			i = 0
+			continue
+		}
+		i++
+	}
+	t.dirty = append(t.dirty, r)
+	if len(t.dirty) > maxDirtyRects {
+		bounds := image.Rectangle{}
+		for _, d := range t.dirty {
+			bounds = bounds.Union(d)
+		}
+		t.dirty = append(t.dirty[:0], bounds)
+	}
+
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+// origin returns the position of the desktop origin, the top left corner of the primary display,
+// in root window coordinates.
+func (t *damageTracker) origin() (image.Point, error) {
+	return t.xs.origin()
+}
+
+func (t *damageTracker) take(rect image.Rectangle) []image.Rectangle {
+	origin, err := t.origin()
+	if err != nil {
+		// Without the origin, the changes cannot be located. Report the whole region instead of losing them.
+		t.mu.Lock()
+		changed := len(t.dirty) > 0
+		t.dirty = t.dirty[:0]
+		t.mu.Unlock()
+		if changed {
+			return []image.Rectangle{rect}
+		}
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dirty := clipDirty(t.dirty, rect, origin)
+	t.dirty = t.dirty[:0]
+	return dirty
+}
+
+// clipDirty returns the parts of the dirty rectangles, in root window coordinates, which lie in rect,
+// in the coordinate system of Capture whose origin is at origin in the root window.
+func clipDirty(dirty []image.Rectangle, rect image.Rectangle, origin image.Point) []image.Rectangle {
+	target := rect.Add(origin)
+	var clipped []image.Rectangle
+	for _, d := range dirty {
+		d = d.Intersect(target)
+		if !d.Empty() {
+			clipped = append(clipped, d.Sub(origin))
+		}
+	}
+	return clipped
+}
+
+func (t *damageTracker) wait(ctx context.Context, rect image.Rectangle) ([]image.Rectangle, error) {
+	for {
+		if t.isClosed() {
+			return nil, ErrClosed
+		}
+		dirty := t.take(rect)
+		if len(dirty) > 0 {
+			return dirty, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.done:
+			if t.isClosed() {
+				return nil, ErrClosed
+			}
+			return nil, fmt.Errorf("%w: connection to the X server lost", ErrNoDisplay)
+		case <-t.changed:
+		}
+	}
+}
+
+func (t *damageTracker) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+func (t *damageTracker) close() {
+	t.once.Do(func() {
+		t.mu.Lock()
+		t.closed = true
+		t.mu.Unlock()
+		damage.Destroy(t.xs.conn, t.damage)
+		t.xs.close()
+	})
+}
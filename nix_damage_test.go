@@ -0,0 +1,97 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestDamageTrackerAdd(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		add  []image.Rectangle
+		want []image.Rectangle
+	}{
+		{
+			name: "separate",
+			add:  []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(4, 4, 6, 6)},
+			want: []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(4, 4, 6, 6)},
+		},
+		{
+			name: "overlapping",
+			add:  []image.Rectangle{image.Rect(0, 0, 4, 4), image.Rect(2, 2, 6, 6)},
+			want: []image.Rectangle{image.Rect(0, 0, 6, 6)},
+		},
+		{
+			name: "touching",
+			add:  []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(2, 0, 4, 2)},
+			want: []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(2, 0, 4, 2)},
+		},
+		{
+			// The union with the third overlaps the first, which was checked already.
+			name: "chained",
+			add:  []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(10, 0, 12, 2), image.Rect(1, 1, 11, 3)},
+			want: []image.Rectangle{image.Rect(0, 0, 12, 3)},
+		},
+		{
+			name: "empty",
+			add:  []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(1, 1, 1, 5)},
+			want: []image.Rectangle{image.Rect(0, 0, 2, 2)},
+		},
+	} {
+		tracker := &damageTracker{changed: make(chan struct{}, 1)}
+		for _, r := range tt.add {
+			tracker.add(r)
+		}
+		if !reflect.DeepEqual(tracker.dirty, tt.want) {
+			t.Errorf("%s: dirty = %v, want %v", tt.name, tracker.dirty, tt.want)
+		}
+	}
+}
+
+func TestDamageTrackerAddMany(t *testing.T) {
+	tracker := &damageTracker{changed: make(chan struct{}, 1)}
+	for i := 0; i < maxDirtyRects; i++ {
+		tracker.add(image.Rect(2*i, 0, 2*i+1, 1))
+	}
+	if len(tracker.dirty) != maxDirtyRects {
+		t.Fatalf("%d separate rectangles were kept as %d", maxDirtyRects, len(tracker.dirty))
+	}
+	select {
+	case <-tracker.changed:
+	default:
+		t.Error("add did not signal the change")
+	}
+
+	// One more collapses them into their bounding box.
+	tracker.add(image.Rect(0, 10, 1, 11))
+	want := []image.Rectangle{image.Rect(0, 0, 2*maxDirtyRects-1, 11)}
+	if !reflect.DeepEqual(tracker.dirty, want) {
+		t.Errorf("dirty = %v, want %v", tracker.dirty, want)
+	}
+}
+
+func TestClipDirty(t *testing.T) {
+	dirty := []image.Rectangle{
+		image.Rect(100, 50, 120, 70),   // inside
+		image.Rect(90, 40, 110, 60),    // across the top left corner
+		image.Rect(300, 300, 310, 310), // outside
+	}
+	// The desktop origin is at (100, 50) in the root window.
+	got := clipDirty(dirty, image.Rect(0, 0, 50, 50), image.Pt(100, 50))
+	want := []image.Rectangle{image.Rect(0, 0, 20, 20), image.Rect(0, 0, 10, 10)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clipDirty() = %v, want %v", got, want)
+	}
+
+	got = clipDirty(dirty, image.Rect(-20, -20, 0, 0), image.Pt(100, 50))
+	want = []image.Rectangle{image.Rect(-10, -10, 0, 0)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clipDirty() left of the origin = %v, want %v", got, want)
+	}
+	if got := clipDirty(dirty, image.Rect(500, 500, 600, 600), image.Pt(100, 50)); got != nil {
+		t.Errorf("clipDirty() of an unchanged region = %v, want nil", got)
+	}
+}
@@ -3,18 +3,195 @@
 package screenshot
 
 import (
+	"context"
 	"image"
 	"os"
 )
 
-// Capture returns screen capture of specified desktop region.
-// x and y represent distance from the upper-left corner of primary display.
-// Y-axis is downward direction. This means coordinates system is similar to Windows OS.
-func Capture(x, y, width, height int) (img *image.RGBA, e error) {
-	sessionType := os.Getenv("XDG_SESSION_TYPE")
-	if sessionType == "wayland" {
-		return captureDbus(x, y, width, height)
-	} else {
-		return captureXinerama(x, y, width, height)
+// portalBackend captures through the XDG desktop portal, with its Screenshot or ScreenCast interface.
+type portalBackend struct {
+	name       string
+	screenCast bool
+}
+
+var (
+	backendWlroots    Backend = &wlrootsBackend{}
+	backendScreenCast Backend = &portalBackend{name: "portal-screencast", screenCast: true}
+	backendPortal     Backend = &portalBackend{name: "portal"}
+)
+
+var gBackends = []Backend{backendX11Shm, backendX11GetImage, backendWlroots, backendScreenCast, backendPortal}
+
+func (b *portalBackend) Name() string {
+	return b.name
+}
+
+func (b *portalBackend) probe(ctx context.Context, s *session) error {
+	return probePortal(ctx)
+}
+
+func (b *portalBackend) captureInto(ctx context.Context, s *session, dst *image.RGBA, rect image.Rectangle) error {
+	if b.screenCast {
+		return s.captureScreenCast(ctx, dst, rect)
+	}
+	// The screenshot covers every output. Without a connection to the compositor, the first output
+	// is assumed to be at its top-left corner.
+	var origin image.Point
+	if wl, err := s.waylandOutputs(ctx); err == nil {
+		origin = wl.desktop().Min
+	}
+	return captureDbus(ctx, dst, rect, origin)
+}
+
+func (b *portalBackend) displays(ctx context.Context, s *session) ([]Display, error) {
+	wl, err := s.waylandOutputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return wl.displays(), nil
+}
+
+// wlrootsBackend captures through the screencopy protocols of wlroots compositors.
+type wlrootsBackend struct{}
+
+func (b *wlrootsBackend) Name() string {
+	return "wlr-screencopy"
+}
+
+func (b *wlrootsBackend) probe(ctx context.Context, s *session) error {
+	wl, err := s.wayland(ctx)
+	if err != nil {
+		return err
+	}
+	return wl.checkScreencopy()
+}
+
+func (b *wlrootsBackend) captureInto(ctx context.Context, s *session, dst *image.RGBA, rect image.Rectangle) error {
+	wl, err := s.wayland(ctx)
+	if err != nil {
+		return err
+	}
+	return s.captureWlroots(ctx, wl, dst, rect)
+}
+
+func (b *wlrootsBackend) displays(ctx context.Context, s *session) ([]Display, error) {
+	wl, err := s.waylandOutputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return wl.displays(), nil
+}
+
+// backendChain returns the backends probed in turn when none is selected by name. The Wayland backends
+// come first on a Wayland session, and last otherwise. Either way the others are tried next, e.g. on a
+// headless X server with a stray XDG_SESSION_TYPE. The ScreenCast portal is only probed when requested through WithScreenCast.
+func (s *session) backendChain() []Backend {
+	x11 := []Backend{backendX11Shm, backendX11GetImage}
+	if s.opts.xDisplay != "" || s.opts.xScreenSet {
+		return x11
+	}
+	wayland := []Backend{backendWlroots, backendPortal}
+	if s.opts.screenCast {
+		wayland = []Backend{backendScreenCast, backendWlroots, backendPortal}
+	}
+	if isWaylandSession() {
+		return append(wayland, x11...)
+	}
+	return append(x11, wayland...)
+}
+
+// isWaylandSession reports whether the desktop is a Wayland session, whose X11 display, if any, is
+// XWayland which only sees the X11 clients.
+func isWaylandSession() bool {
+	return os.Getenv("XDG_SESSION_TYPE") == "wayland"
+}
+
+// captureScreenCast captures from the ScreenCast session, which is started on first use.
+func (s *session) captureScreenCast(ctx context.Context, dst *image.RGBA, rect image.Rectangle) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, portalTimeout)
+		defer cancel()
+	}
+	if s.cast == nil {
+		cast, err := openScreenCast(ctx, s.opts.restoreToken, s.opts.cursor)
+		if err != nil {
+			return err
+		}
+		// The positions of the streams are in the compositor space.
+		if wl, err := s.wayland(ctx); err == nil {
+			for i := range cast.streams {
+				cast.streams[i].position = wl.point(cast.streams[i].position)
+			}
+		}
+		s.cast = cast
+	}
+	err := s.cast.captureInto(ctx, dst, rect)
+	if err != nil && ctx.Err() == nil {
+		// The session ended, e.g. because the user stopped sharing. The next capture starts another one.
+		s.cast.close()
+		s.cast = nil
+	}
+	return err
+}
+
+// wayland returns the connection to the Wayland compositor, connecting on first use.
+func (s *session) wayland(ctx context.Context) (*wlDesktop, error) {
+	if s.wl != nil {
+		return s.wl, nil
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waylandTimeout)
+		defer cancel()
+	}
+	wl, err := openWayland(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.wl = wl
+	return wl, nil
+}
+
+// waylandOutputs returns the connection to the Wayland compositor, once it has taken into account the
+// outputs plugged, unplugged or reconfigured since it was last used.
+func (s *session) waylandOutputs(ctx context.Context) (*wlDesktop, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waylandTimeout)
+		defer cancel()
+	}
+	wl, err := s.wayland(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := wl.refresh(ctx); err != nil {
+		wl.close()
+		s.wl = nil
+		return nil, err
+	}
+	return wl, nil
+}
+
+// captureWlroots captures through the screencopy protocols. The compositor draws the cursor itself.
+func (s *session) captureWlroots(ctx context.Context, wl *wlDesktop, dst *image.RGBA, rect image.Rectangle) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waylandTimeout)
+		defer cancel()
+	}
+	err := wl.captureInto(ctx, dst, rect, s.opts.cursor)
+	if err != nil {
+		// The connection may be in the middle of an exchange. The next capture reconnects.
+		wl.close()
+		s.wl = nil
+	}
+	return err
+}
+
+func (s *session) restoreToken() string {
+	if s.cast == nil {
+		return ""
 	}
+	return s.cast.restoreToken
 }
@@ -3,12 +3,31 @@
 package screenshot
 
 import (
+	"context"
 	"image"
 )
 
-// Capture returns screen capture of specified desktop region.
-// x and y represent distance from the upper-left corner of primary display.
-// Y-axis is downward direction. This means coordinates system is similar to Windows OS.
-func Capture(x, y, width, height int) (img *image.RGBA, e error) {
-	return captureXinerama(x, y, width, height)
+var gBackends = []Backend{backendX11Shm, backendX11GetImage}
+
+// backendChain returns the backends probed in turn when none is selected by name.
+func (s *session) backendChain() []Backend {
+	return gBackends
+}
+
+func capturePortal(ctx context.Context, opts PortalOptions) (*image.RGBA, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) restoreToken() string {
+	return ""
 }
+
+// screenCast is a session of the ScreenCast portal, which is not available here.
+type screenCast struct{}
+
+func (sc *screenCast) close() {}
+
+// wlDesktop is a connection to a Wayland compositor, which is not available here.
+type wlDesktop struct{}
+
+func (wl *wlDesktop) close() {}
@@ -0,0 +1,105 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// watchDisplays reports the changes of the displays of the X server, which RandR notifies to a
+// connection of its own, so that waiting for events does not hold up the captures.
+func (s *session) watchDisplays(ctx context.Context) (<-chan DisplayEvent, error) {
+	if err := s.requireX11(); err != nil {
+		return nil, err
+	}
+	xs, err := openXSession(ctx, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	var displays []Display
+	err = callX(xs, func() error {
+		if !xs.hasRandR {
+			return fmt.Errorf("%w: RANDR", ErrExtensionMissing)
+		}
+		if !xs.watchesScreens {
+			return errors.New("screenshot: cannot select the events of RandR")
+		}
+		var err error
+		displays, err = xs.displays()
+		return err
+	})
+	if err != nil {
+		xs.close()
+		return nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer close(changed)
+		for {
+			ev, err := xs.conn.WaitForEvent()
+			if ev == nil && err == nil {
+				return
+			}
+			if isScreenChange(ev) {
+				xs.invalidateScreens()
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	events := make(chan DisplayEvent)
+	go func() {
+		defer close(events)
+		defer xs.close()
+		// Once ctx is done, the pending requests fail and so does waiting for events.
+		stop := abortXOnDone(ctx, xs.netConn)
+		defer stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-changed:
+				if !ok {
+					return
+				}
+			}
+			// A change comes as a burst of events, which the enumeration covers at once.
+			var current []Display
+			err := callX(xs, func() error {
+				var err error
+				current, err = xs.displays()
+				return err
+			})
+			if err != nil {
+				// The connection is likely unusable, and the changes could not be told anyway.
+				return
+			}
+			for _, ev := range diffDisplays(displays, current) {
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			displays = current
+		}
+	}()
+	return events, nil
+}
+
+// callX calls f, turning a panic from the X11 bindings into an error.
+func callX(xs *xSession, f func() error) (e error) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			e = fmt.Errorf("screenshot: X11 request failed: %v", err)
+		}
+	}()
+	return f()
+}
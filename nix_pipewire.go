@@ -0,0 +1,1095 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// Object ids, opcodes and constants of the PipeWire native protocol (version 3), from
+// pipewire/core.h, pipewire/extensions/client-node.h and the spa headers.
+const (
+	pwCoreId   = 0
+	pwClientId = 1
+
+	pwCoreVersion       = 3
+	pwClientNodeVersion = 4
+
+	pwCoreMethodHello        = 1
+	pwCoreMethodSync         = 2
+	pwCoreMethodPong         = 3
+	pwCoreMethodCreateObject = 6
+
+	pwCoreEventDone      = 1
+	pwCoreEventPing      = 2
+	pwCoreEventError     = 3
+	pwCoreEventRemoveId  = 4
+	pwCoreEventBoundId   = 5
+	pwCoreEventAddMem    = 6
+	pwCoreEventRemoveMem = 7
+
+	pwClientMethodUpdateProperties = 2
+
+	pwClientNodeMethodUpdate     = 2
+	pwClientNodeMethodPortUpdate = 3
+	pwClientNodeMethodSetActive  = 4
+
+	pwClientNodeEventTransport      = 0
+	pwClientNodeEventPortSetParam   = 7
+	pwClientNodeEventPortUseBuffers = 8
+	pwClientNodeEventPortSetIO      = 9
+	pwClientNodeEventSetActivation  = 10
+
+	pwMemblockReadable = 1 << 0
+	pwMemblockWritable = 1 << 1
+
+	pwActivationTriggered = 1
+	pwActivationFinished  = 3
+
+	spaIdInvalid = 0xffffffff
+
+	spaDirectionInput = 0
+
+	spaDataMemPtr = 1
+	spaDataMemFd  = 2
+
+	spaIoBuffers = 1
+
+	spaStatusNeedData = 1 << 0
+	spaStatusHaveData = 1 << 1
+
+	spaChunkFlagCorrupted = 1 << 0
+
+	spaTypeObjectFormat        = 0x40003
+	spaTypeObjectParamBuffers  = 0x40004
+	spaParamEnumFormat         = 3
+	spaParamFormat             = 4
+	spaParamBuffers            = 5
+	spaParamInfoRead           = 1 << 1
+	spaParamInfoWrite          = 1 << 2
+	spaFormatMediaType         = 1
+	spaFormatMediaSubtype      = 2
+	spaFormatVideoFormat       = 0x20001
+	spaFormatVideoSize         = 0x20003
+	spaFormatVideoFramerate    = 0x20004
+	spaMediaTypeVideo          = 2
+	spaMediaSubtypeRaw         = 1
+	spaParamBuffersBuffers     = 1
+	spaParamBuffersBlocks      = 2
+	spaParamBuffersSize        = 3
+	spaParamBuffersStride      = 4
+	spaParamBuffersDataType    = 6
+	spaVideoFormatRGBx         = 7
+	spaVideoFormatBGRx         = 8
+	spaVideoFormatRGBA         = 11
+	spaVideoFormatBGRA         = 12
+	spaNodeChangeMaskFlags     = 1 << 0
+	spaNodeChangeMaskProps     = 1 << 1
+	spaNodeChangeMaskParams    = 1 << 2
+	spaPortChangeMaskFlags     = 1 << 0
+	spaPortChangeMaskParams    = 1 << 3
+	pwClientNodeUpdateParams   = 1 << 0
+	pwClientNodeUpdateInfo     = 1 << 1
+	pwClientNodePortUpdateInfo = 1 << 1
+)
+
+var errPipeWireClosed = errors.New("pipewire: connection closed")
+
+// pwConn is a connection to a PipeWire daemon, e.g. the remote opened by the ScreenCast portal.
+// Events are read by a goroutine of its own, and handled while holding mu.
+type pwConn struct {
+	conn *net.UnixConn
+
+	wmu sync.Mutex // serializes the messages sent
+	seq uint32
+
+	mu      sync.Mutex
+	nextId  uint32
+	proxies map[uint32]*pwStream
+	mems    map[uint32]*pwMem
+	syncs   map[int32]chan struct{}
+	syncSeq int32
+	err     error
+	done    chan struct{}
+}
+
+// newPWConn takes over fd, a connected PipeWire socket, and says hello.
+func newPWConn(fd int) (*pwConn, error) {
+	file := os.NewFile(uintptr(fd), "pipewire")
+	defer file.Close()
+	fc, err := net.FileConn(file)
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := fc.(*net.UnixConn)
+	if !ok {
+		fc.Close()
+		return nil, errors.New("pipewire: the remote is not a unix socket")
+	}
+
+	c := &pwConn{
+		conn:    conn,
+		nextId:  pwClientId + 1,
+		proxies: map[uint32]*pwStream{},
+		mems:    map[uint32]*pwMem{},
+		syncs:   map[int32]chan struct{}{},
+		done:    make(chan struct{}),
+	}
+	var b podBuilder
+	b.structure(func(b *podBuilder) {
+		b.int(pwCoreVersion)
+	})
+	err = c.send(pwCoreId, pwCoreMethodHello, b.bytes())
+	if err == nil {
+		b = podBuilder{}
+		b.structure(func(b *podBuilder) {
+			pwDict(b, map[string]string{
+				"application.name": "screenshot",
+				"application.id":   "github.com/kbinani/screenshot",
+			})
+		})
+		err = c.send(pwClientId, pwClientMethodUpdateProperties, b.bytes())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.readEvents()
+	return c, nil
+}
+
+// pwDict appends a dictionary as a struct of the number of items followed by the keys and values.
+func pwDict(b *podBuilder, dict map[string]string) {
+	b.structure(func(b *podBuilder) {
+		b.int(int32(len(dict)))
+		for k, v := range dict {
+			b.string(k)
+			b.string(v)
+		}
+	})
+}
+
+func (c *pwConn) send(id, opcode uint32, payload []byte, fds ...int) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	msg := make([]byte, 16, 16+len(payload))
+	binary.NativeEndian.PutUint32(msg, id)
+	binary.NativeEndian.PutUint32(msg[4:], opcode<<24|uint32(len(payload))&0xffffff)
+	binary.NativeEndian.PutUint32(msg[8:], c.seq)
+	binary.NativeEndian.PutUint32(msg[12:], uint32(len(fds)))
+	c.seq++
+	msg = append(msg, payload...)
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	_, _, err := c.conn.WriteMsgUnix(msg, oob, nil)
+	return err
+}
+
+// roundtrip waits until the daemon has handled every message sent before.
+func (c *pwConn) roundtrip(ctx context.Context) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	seq := c.syncSeq
+	c.syncSeq++
+	done := make(chan struct{})
+	c.syncs[seq] = done
+	c.mu.Unlock()
+
+	var b podBuilder
+	b.structure(func(b *podBuilder) {
+		b.int(pwCoreId)
+		b.int(seq)
+	})
+	if err := c.send(pwCoreId, pwCoreMethodSync, b.bytes()); err != nil {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-c.done:
+		return c.failure()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *pwConn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// fail ends the connection with err. It is called with mu held.
+func (c *pwConn) fail(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+	for _, p := range c.proxies {
+		p.fail(err)
+	}
+	for id, m := range c.mems {
+		m.close()
+		delete(c.mems, id)
+	}
+}
+
+func (c *pwConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fail(errPipeWireClosed)
+}
+
+func (c *pwConn) readEvents() {
+	var pending []byte
+	var fds []int
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(64*4))
+	for {
+		for len(pending) >= 16 {
+			id := binary.NativeEndian.Uint32(pending)
+			p1 := binary.NativeEndian.Uint32(pending[4:])
+			nfds := int(binary.NativeEndian.Uint32(pending[12:]))
+			size := int(p1 & 0xffffff)
+			if len(pending) < 16+size {
+				break
+			}
+			if nfds > len(fds) {
+				c.mu.Lock()
+				c.fail(errors.New("pipewire: message is missing file descriptors"))
+				c.mu.Unlock()
+				closeFds(fds)
+				return
+			}
+			msgFds := pwFds(fds[:nfds:nfds])
+			fds = fds[nfds:]
+			c.mu.Lock()
+			if c.err == nil {
+				err := c.dispatch(id, p1>>24, pending[16:16+size], msgFds)
+				if err != nil {
+					c.fail(err)
+				}
+			}
+			c.mu.Unlock()
+			msgFds.closeUnused()
+			pending = pending[16+size:]
+		}
+		if c.failure() != nil {
+			closeFds(fds)
+			return
+		}
+
+		n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+		if oobn > 0 {
+			fds = append(fds, parseRights(oob[:oobn])...)
+		}
+		if err != nil {
+			c.mu.Lock()
+			c.fail(fmt.Errorf("pipewire: %w", err))
+			c.mu.Unlock()
+			closeFds(fds)
+			return
+		}
+		// The payloads handed to dispatch alias pending, so it is never appended to in place.
+		pending = append(pending[:len(pending):len(pending)], buf[:n]...)
+	}
+}
+
+func parseRights(oob []byte) []int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	var fds []int
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	return fds
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		_ = syscall.Close(fd)
+	}
+}
+
+// pwFds are the file descriptors sent along with a message. The ones not taken by the handler of
+// the message are closed.
+type pwFds []int
+
+func (f pwFds) take(index int) (int, error) {
+	if index < 0 || index >= len(f) || f[index] < 0 {
+		return -1, errors.New("pipewire: invalid file descriptor index")
+	}
+	fd := f[index]
+	f[index] = -1
+	return fd, nil
+}
+
+func (f pwFds) closeUnused() {
+	for _, fd := range f {
+		if fd >= 0 {
+			_ = syscall.Close(fd)
+		}
+	}
+}
+
+func (c *pwConn) dispatch(id, opcode uint32, payload []byte, fds pwFds) error {
+	// The payload may be followed by a footer, which is not needed here.
+	p, _, err := parsePod(payload)
+	if err != nil {
+		return err
+	}
+	r := newPodReader(p)
+	if id != pwCoreId {
+		if proxy, ok := c.proxies[id]; ok {
+			proxy.event(opcode, r, fds)
+		}
+		return nil
+	}
+
+	switch opcode {
+	case pwCoreEventDone:
+		_ = r.int()
+		seq := r.int()
+		if r.err != nil {
+			return r.err
+		}
+		if done, ok := c.syncs[seq]; ok {
+			close(done)
+			delete(c.syncs, seq)
+		}
+	case pwCoreEventPing:
+		objectId := r.int()
+		seq := r.int()
+		if r.err != nil {
+			return r.err
+		}
+		var b podBuilder
+		b.structure(func(b *podBuilder) {
+			b.int(objectId)
+			b.int(seq)
+		})
+		return c.send(pwCoreId, pwCoreMethodPong, b.bytes())
+	case pwCoreEventError:
+		objectId := r.uint()
+		_ = r.int()
+		res := r.int()
+		message := r.string()
+		if r.err != nil {
+			return r.err
+		}
+		err := fmt.Errorf("pipewire: %s (%d)", message, res)
+		if proxy, ok := c.proxies[objectId]; ok {
+			proxy.fail(err)
+			return nil
+		}
+		return err
+	case pwCoreEventRemoveId:
+		objectId := r.uint()
+		if proxy, ok := c.proxies[objectId]; ok && r.err == nil {
+			proxy.fail(errors.New("pipewire: the node was destroyed by the daemon"))
+			delete(c.proxies, objectId)
+		}
+		return r.err
+	case pwCoreEventBoundId:
+		objectId := r.uint()
+		globalId := r.uint()
+		if proxy, ok := c.proxies[objectId]; ok && r.err == nil {
+			proxy.globalId = globalId
+		}
+		return r.err
+	case pwCoreEventAddMem:
+		memId := r.uint()
+		typ := r.id()
+		fdIndex := r.fd()
+		flags := r.uint()
+		if r.err != nil {
+			return r.err
+		}
+		fd, err := fds.take(fdIndex)
+		if err != nil {
+			return err
+		}
+		if old, ok := c.mems[memId]; ok {
+			old.close()
+		}
+		c.mems[memId] = &pwMem{typ: typ, flags: flags, fd: fd}
+	case pwCoreEventRemoveMem:
+		memId := r.uint()
+		if m, ok := c.mems[memId]; ok && r.err == nil {
+			m.close()
+			delete(c.mems, memId)
+		}
+		return r.err
+	}
+	return nil
+}
+
+// mem returns size bytes at offset of the memory block memId, mapped in our address space.
+func (c *pwConn) mem(memId, offset, size uint32) ([]byte, error) {
+	m, ok := c.mems[memId]
+	if !ok {
+		return nil, fmt.Errorf("pipewire: unknown memory block %d", memId)
+	}
+	return m.slice(offset, size)
+}
+
+// pwMem is a block of memory shared with the daemon.
+type pwMem struct {
+	typ   uint32
+	flags uint32
+	fd    int
+	maps  [][]byte
+}
+
+func (m *pwMem) slice(offset, size uint32) ([]byte, error) {
+	prot := 0
+	if m.flags&pwMemblockReadable != 0 {
+		prot |= syscall.PROT_READ
+	}
+	if m.flags&pwMemblockWritable != 0 {
+		prot |= syscall.PROT_WRITE
+	}
+	page := uint32(os.Getpagesize())
+	start := offset &^ (page - 1)
+	data, err := syscall.Mmap(m.fd, int64(start), int(offset-start+size), prot, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("pipewire: mmap: %w", err)
+	}
+	m.maps = append(m.maps, data)
+	return data[offset-start:][:size:size], nil
+}
+
+func (m *pwMem) close() {
+	for _, data := range m.maps {
+		_ = syscall.Munmap(data)
+	}
+	m.maps = nil
+	_ = syscall.Close(m.fd)
+}
+
+// pwStream consumes the video of a PipeWire node through a client-node, a node implemented by
+// this process whose single input port the session manager links to the target node.
+type pwStream struct {
+	c        *pwConn
+	id       uint32 // id of the client-node proxy
+	globalId uint32
+
+	format      uint32
+	size        image.Point
+	formatParam []byte
+	mixes       map[uint32]*pwMix
+	activation  []byte
+	peers       map[uint32]*pwPeer
+	wakeup      *os.File
+
+	frame  *image.RGBA // the last frame received
+	first  chan struct{}
+	err    error
+	failed chan struct{}
+}
+
+// pwMix holds the buffers and the io area of a link to the input port.
+type pwMix struct {
+	buffers []pwBuffer
+	io      []byte // struct spa_io_buffers
+}
+
+type pwBuffer struct {
+	datas []pwData
+}
+
+type pwData struct {
+	data  []byte
+	chunk []byte // struct spa_chunk
+}
+
+// pwPeer is a node to signal once the stream has consumed a frame.
+type pwPeer struct {
+	activation []byte
+	signal     *os.File
+}
+
+// consume creates a client-node linked to the node targetId, and starts receiving its frames.
+func (c *pwConn) consume(ctx context.Context, targetId uint32) (*pwStream, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	s := &pwStream{
+		c:        c,
+		id:       c.nextId,
+		globalId: spaIdInvalid,
+		mixes:    map[uint32]*pwMix{},
+		peers:    map[uint32]*pwPeer{},
+		first:    make(chan struct{}),
+		failed:   make(chan struct{}),
+	}
+	c.nextId++
+	c.proxies[s.id] = s
+	c.mu.Unlock()
+
+	target := strconv.FormatUint(uint64(targetId), 10)
+	var b podBuilder
+	b.structure(func(b *podBuilder) {
+		b.string("client-node")
+		b.string("PipeWire:Interface:ClientNode")
+		b.int(pwClientNodeVersion)
+		pwDict(b, map[string]string{
+			"node.name":        "screenshot",
+			"media.type":       "Video",
+			"media.category":   "Capture",
+			"media.role":       "Screen",
+			"media.class":      "Stream/Input/Video",
+			"node.autoconnect": "true",
+			"node.want-driver": "true",
+			"target.object":    target,
+			"node.target":      target,
+		})
+		b.int(int32(s.id))
+	})
+	err := c.send(pwCoreId, pwCoreMethodCreateObject, b.bytes())
+	if err == nil {
+		err = s.update()
+	}
+	if err == nil {
+		err = s.portUpdate()
+	}
+	if err == nil {
+		b = podBuilder{}
+		b.structure(func(b *podBuilder) {
+			b.bool(true)
+		})
+		err = c.send(s.id, pwClientNodeMethodSetActive, b.bytes())
+	}
+	if err == nil {
+		err = c.roundtrip(ctx)
+	}
+	if err == nil {
+		c.mu.Lock()
+		err = s.err
+		c.mu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// update describes the node to the daemon: one input port and no output port.
+func (s *pwStream) update() error {
+	var b podBuilder
+	b.structure(func(b *podBuilder) {
+		b.int(pwClientNodeUpdateParams | pwClientNodeUpdateInfo)
+		b.int(0)
+		b.structure(func(b *podBuilder) {
+			b.int(1)
+			b.int(0)
+			b.long(spaNodeChangeMaskFlags | spaNodeChangeMaskProps | spaNodeChangeMaskParams)
+			b.long(0)
+			b.int(0)
+			b.int(0)
+		})
+	})
+	return s.c.send(s.id, pwClientNodeMethodUpdate, b.bytes())
+}
+
+// portUpdate describes the input port to the daemon, with the formats it accepts and, once the
+// format is chosen, the buffers it wants.
+func (s *pwStream) portUpdate() error {
+	params := [][]byte{enumFormatParam()}
+	infos := []uint32{spaParamEnumFormat, spaParamInfoRead, spaParamFormat, spaParamInfoWrite}
+	if s.formatParam != nil {
+		params = append(params, s.formatParam, buffersParam(s.size))
+		infos = append(infos, spaParamBuffers, spaParamInfoRead)
+		infos[3] |= spaParamInfoRead
+	}
+
+	var b podBuilder
+	b.structure(func(b *podBuilder) {
+		b.int(spaDirectionInput)
+		b.int(0)
+		b.int(pwClientNodeUpdateParams | pwClientNodePortUpdateInfo)
+		b.int(int32(len(params)))
+		for _, p := range params {
+			b.raw(p)
+		}
+		b.structure(func(b *podBuilder) {
+			b.long(spaPortChangeMaskFlags | spaPortChangeMaskParams)
+			b.long(0)
+			b.int(0)
+			b.int(1)
+			b.int(0)
+			b.int(int32(len(infos) / 2))
+			for i := 0; i < len(infos); i += 2 {
+				b.id(infos[i])
+				b.int(int32(infos[i+1]))
+			}
+		})
+	})
+	return s.c.send(s.id, pwClientNodeMethodPortUpdate, b.bytes())
+}
+
+// enumFormatParam returns the formats accepted by the port: raw video in the 32 bpp RGB orders.
+func enumFormatParam() []byte {
+	var b podBuilder
+	b.object(spaTypeObjectFormat, spaParamEnumFormat, func(b *podBuilder) {
+		b.prop(spaFormatMediaType, 0)
+		b.id(spaMediaTypeVideo)
+		b.prop(spaFormatMediaSubtype, 0)
+		b.id(spaMediaSubtypeRaw)
+		b.prop(spaFormatVideoFormat, 0)
+		b.choice(choiceEnum, podId, idValue(spaVideoFormatBGRx), idValue(spaVideoFormatBGRx),
+			idValue(spaVideoFormatBGRA), idValue(spaVideoFormatRGBx), idValue(spaVideoFormatRGBA))
+		b.prop(spaFormatVideoSize, 0)
+		b.choice(choiceRange, podRectangle, rectangleValue(1920, 1080), rectangleValue(1, 1), rectangleValue(16384, 16384))
+		b.prop(spaFormatVideoFramerate, 0)
+		b.choice(choiceRange, podFraction, fractionValue(30, 1), fractionValue(0, 1), fractionValue(1000, 1))
+	})
+	return b.bytes()
+}
+
+// buffersParam returns the buffers wanted by the port: memory which can be mapped, i.e. no DMA-BUF.
+func buffersParam(size image.Point) []byte {
+	var b podBuilder
+	b.object(spaTypeObjectParamBuffers, spaParamBuffers, func(b *podBuilder) {
+		b.prop(spaParamBuffersBuffers, 0)
+		b.choice(choiceRange, podInt, intValue(4), intValue(1), intValue(32))
+		b.prop(spaParamBuffersBlocks, 0)
+		b.int(1)
+		b.prop(spaParamBuffersSize, 0)
+		b.int(int32(size.X * size.Y * 4))
+		b.prop(spaParamBuffersStride, 0)
+		b.int(int32(size.X * 4))
+		b.prop(spaParamBuffersDataType, 0)
+		b.choice(choiceFlags, podInt, intValue(1<<spaDataMemPtr|1<<spaDataMemFd))
+	})
+	return b.bytes()
+}
+
+// fail ends the stream with err. It is called with the lock of the connection held.
+func (s *pwStream) fail(err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.failed)
+	s.release()
+}
+
+// release drops the resources shared with the daemon. It is called with the lock of the connection held.
+func (s *pwStream) release() {
+	if s.wakeup != nil {
+		s.wakeup.Close()
+		s.wakeup = nil
+	}
+	for id, p := range s.peers {
+		p.signal.Close()
+		delete(s.peers, id)
+	}
+	s.mixes = map[uint32]*pwMix{}
+	s.activation = nil
+}
+
+// event handles an event of the client-node. It is called with the lock of the connection held.
+func (s *pwStream) event(opcode uint32, r *podReader, fds pwFds) {
+	if s.err != nil {
+		return
+	}
+	var err error
+	switch opcode {
+	case pwClientNodeEventTransport:
+		err = s.transport(r, fds)
+	case pwClientNodeEventPortSetParam:
+		err = s.portSetParam(r)
+	case pwClientNodeEventPortUseBuffers:
+		err = s.portUseBuffers(r)
+	case pwClientNodeEventPortSetIO:
+		err = s.portSetIO(r)
+	case pwClientNodeEventSetActivation:
+		err = s.setActivation(r, fds)
+	}
+	if err != nil {
+		s.fail(err)
+	}
+}
+
+// transport receives the activation record of the node, and the eventfd signaled when the node
+// has to process a frame.
+func (s *pwStream) transport(r *podReader, fds pwFds) error {
+	readIndex := r.fd()
+	_ = r.fd()
+	memId := r.uint()
+	offset := r.uint()
+	size := r.uint()
+	if r.err != nil {
+		return r.err
+	}
+	activation, err := s.c.mem(memId, offset, size)
+	if err != nil {
+		return err
+	}
+	if len(activation) < 20 {
+		return errors.New("pipewire: activation record too small")
+	}
+	fd, err := fds.take(readIndex)
+	if err != nil {
+		return err
+	}
+	// Non-blocking, so that closing the file interrupts a pending read.
+	_ = syscall.SetNonblock(fd, true)
+	if s.wakeup != nil {
+		s.wakeup.Close()
+	}
+	s.activation = activation
+	s.wakeup = os.NewFile(uintptr(fd), "pipewire-wakeup")
+	go s.process(s.wakeup)
+	return nil
+}
+
+func (s *pwStream) portSetParam(r *podReader) error {
+	direction := r.uint()
+	_ = r.uint()
+	id := r.id()
+	_ = r.uint()
+	param := r.pod()
+	if r.err != nil {
+		return r.err
+	}
+	if direction != spaDirectionInput || id != spaParamFormat {
+		return nil
+	}
+	if param.typ == podNone {
+		s.formatParam = nil
+		return s.portUpdate()
+	}
+
+	format, size, err := parseVideoFormat(param)
+	if err != nil {
+		return err
+	}
+	s.format = format
+	s.size = size
+	// Keep a copy of the param, which aliases the buffer of the connection.
+	var b podBuilder
+	b.header(uint32(len(param.body)), param.typ)
+	b.buf = append(b.buf, param.body...)
+	b.pad()
+	s.formatParam = b.bytes()
+	return s.portUpdate()
+}
+
+// parseVideoFormat returns the pixel format and the size of a raw video Format param.
+func parseVideoFormat(param pod) (format uint32, size image.Point, err error) {
+	_, _, props, err := param.object()
+	if err != nil {
+		return 0, image.Point{}, err
+	}
+	for _, prop := range props {
+		v := prop.value.value()
+		switch prop.key {
+		case spaFormatMediaSubtype:
+			if v.check(podId, 4) != nil || v.uint32() != spaMediaSubtypeRaw {
+				return 0, image.Point{}, errors.New("pipewire: the video is not raw")
+			}
+		case spaFormatVideoFormat:
+			if err := v.check(podId, 4); err != nil {
+				return 0, image.Point{}, err
+			}
+			format = v.uint32()
+		case spaFormatVideoSize:
+			if err := v.check(podRectangle, 8); err != nil {
+				return 0, image.Point{}, err
+			}
+			size = image.Pt(int(v.uint32()), int(binary.NativeEndian.Uint32(v.body[4:])))
+		}
+	}
+	switch format {
+	case spaVideoFormatBGRx, spaVideoFormatBGRA, spaVideoFormatRGBx, spaVideoFormatRGBA:
+	default:
+		return 0, image.Point{}, fmt.Errorf("pipewire: unsupported video format %d", format)
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		return 0, image.Point{}, errors.New("pipewire: the video has no size")
+	}
+	return format, size, nil
+}
+
+func (s *pwStream) portUseBuffers(r *podReader) error {
+	direction := r.uint()
+	_ = r.uint()
+	mixId := r.uint()
+	_ = r.uint()
+	n := r.int()
+	if r.err != nil {
+		return r.err
+	}
+	if direction != spaDirectionInput {
+		return nil
+	}
+	mix := s.mix(mixId)
+	mix.buffers = nil
+	for i := int32(0); i < n; i++ {
+		memId := r.uint()
+		offset := r.uint()
+		size := r.uint()
+		nMetas := r.int()
+		// The metadata comes first in the memory of the buffer, followed by the chunks.
+		skip := uint32(0)
+		for j := int32(0); j < nMetas; j++ {
+			_ = r.id()
+			skip += (r.uint() + 7) &^ 7
+		}
+		nDatas := r.int()
+		if r.err != nil {
+			return r.err
+		}
+		mem, err := s.c.mem(memId, offset, size)
+		if err != nil {
+			return err
+		}
+		var buffer pwBuffer
+		for j := int32(0); j < nDatas; j++ {
+			typ := r.id()
+			data := r.uint()
+			_ = r.uint()
+			mapOffset := r.uint()
+			maxSize := r.uint()
+			if r.err != nil {
+				return r.err
+			}
+			chunkOffset := skip + uint32(j)*16
+			if uint64(chunkOffset)+16 > uint64(len(mem)) {
+				return errors.New("pipewire: buffer too small for its chunks")
+			}
+			d := pwData{chunk: mem[chunkOffset : chunkOffset+16]}
+			switch typ {
+			case spaDataMemFd:
+				d.data, err = s.c.mem(data, mapOffset, maxSize)
+				if err != nil {
+					return err
+				}
+			case spaDataMemPtr:
+				if uint64(data)+uint64(maxSize) > uint64(len(mem)) {
+					return errors.New("pipewire: buffer data out of bounds")
+				}
+				d.data = mem[data : data+maxSize]
+			}
+			buffer.datas = append(buffer.datas, d)
+		}
+		mix.buffers = append(mix.buffers, buffer)
+	}
+	return nil
+}
+
+func (s *pwStream) portSetIO(r *podReader) error {
+	direction := r.uint()
+	_ = r.uint()
+	mixId := r.uint()
+	id := r.id()
+	memId := r.uint()
+	offset := r.uint()
+	size := r.uint()
+	if r.err != nil {
+		return r.err
+	}
+	if direction != spaDirectionInput || id != spaIoBuffers {
+		return nil
+	}
+	mix := s.mix(mixId)
+	if memId == spaIdInvalid {
+		mix.io = nil
+		return nil
+	}
+	if size < 8 {
+		return errors.New("pipewire: io area too small")
+	}
+	io, err := s.c.mem(memId, offset, size)
+	if err != nil {
+		return err
+	}
+	mix.io = io
+	return nil
+}
+
+func (s *pwStream) mix(id uint32) *pwMix {
+	mix, ok := s.mixes[id]
+	if !ok {
+		mix = &pwMix{}
+		s.mixes[id] = mix
+	}
+	return mix
+}
+
+// setActivation receives a node to signal when the stream has processed a frame.
+func (s *pwStream) setActivation(r *podReader, fds pwFds) error {
+	nodeId := r.uint()
+	signalIndex := r.fd()
+	memId := r.uint()
+	offset := r.uint()
+	size := r.uint()
+	if r.err != nil {
+		return r.err
+	}
+	if old, ok := s.peers[nodeId]; ok {
+		old.signal.Close()
+		delete(s.peers, nodeId)
+	}
+	if memId == spaIdInvalid || nodeId == s.globalId {
+		return nil
+	}
+	activation, err := s.c.mem(memId, offset, size)
+	if err != nil {
+		return err
+	}
+	if len(activation) < 20 {
+		return errors.New("pipewire: activation record too small")
+	}
+	fd, err := fds.take(signalIndex)
+	if err != nil {
+		return err
+	}
+	s.peers[nodeId] = &pwPeer{activation: activation, signal: os.NewFile(uintptr(fd), "pipewire-signal")}
+	return nil
+}
+
+// process handles the wakeups of the node until wakeup is closed.
+func (s *pwStream) process(wakeup *os.File) {
+	var counter [8]byte
+	for {
+		if _, err := wakeup.Read(counter[:]); err != nil {
+			return
+		}
+		s.c.mu.Lock()
+		if s.wakeup == wakeup {
+			s.cycle()
+		}
+		s.c.mu.Unlock()
+	}
+}
+
+// cycle consumes the buffer the daemon put in the io area, and signals the nodes waiting for the
+// stream, as libpipewire does when a node finishes processing.
+func (s *pwStream) cycle() {
+	for _, mix := range s.mixes {
+		if mix.io == nil {
+			continue
+		}
+		status := (*int32)(unsafe.Pointer(&mix.io[0]))
+		bufferId := binary.NativeEndian.Uint32(mix.io[4:])
+		if atomic.LoadInt32(status) != spaStatusHaveData || bufferId >= uint32(len(mix.buffers)) {
+			continue
+		}
+		s.receive(mix.buffers[bufferId])
+		atomic.StoreInt32(status, spaStatusNeedData)
+	}
+
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&s.activation[0])), pwActivationFinished)
+	for _, peer := range s.peers {
+		// state[0].pending of struct pw_node_activation
+		pending := (*int32)(unsafe.Pointer(&peer.activation[16]))
+		if atomic.AddInt32(pending, -1) == 0 {
+			atomic.StoreUint32((*uint32)(unsafe.Pointer(&peer.activation[0])), pwActivationTriggered)
+			var one [8]byte
+			binary.NativeEndian.PutUint64(one[:], 1)
+			_, _ = peer.signal.Write(one[:])
+		}
+	}
+}
+
+// receive copies the frame held by buffer.
+func (s *pwStream) receive(buffer pwBuffer) {
+	if len(buffer.datas) == 0 || s.size.X <= 0 || s.size.Y <= 0 {
+		return
+	}
+	d := buffer.datas[0]
+	offset := binary.NativeEndian.Uint32(d.chunk)
+	size := binary.NativeEndian.Uint32(d.chunk[4:])
+	stride := int(int32(binary.NativeEndian.Uint32(d.chunk[8:])))
+	flags := binary.NativeEndian.Uint32(d.chunk[12:])
+	if flags&spaChunkFlagCorrupted != 0 || len(d.data) == 0 {
+		return
+	}
+	offset %= uint32(len(d.data))
+	src := d.data[offset:]
+	if uint64(size) < uint64(len(src)) {
+		src = src[:size]
+	}
+	if stride <= 0 {
+		stride = s.size.X * 4
+	}
+	height := s.size.Y
+	if stride*(height-1)+s.size.X*4 > len(src) {
+		// Incomplete frame.
+		return
+	}
+
+	if s.frame == nil || s.frame.Rect.Size() != s.size {
+		s.frame = image.NewRGBA(image.Rectangle{Max: s.size})
+	}
+	var op pixelOp
+	if s.format == spaVideoFormatBGRx || s.format == spaVideoFormatBGRA {
+		op |= pixelSwap
+	}
+	if s.format == spaVideoFormatBGRx || s.format == spaVideoFormatRGBx {
+		op |= pixelOpaque
+	}
+	convertPixels(s.frame.Pix, s.frame.Stride, src, stride, s.size.X, height, op)
+	select {
+	case <-s.first:
+	default:
+		close(s.first)
+	}
+}
+
+// copyFrame waits for the first frame of the stream, and copies the part of the last frame which
+// intersects rect into dst. origin is the position of the frame in the coordinates of rect.
+func (s *pwStream) copyFrame(ctx context.Context, dst *image.RGBA, rect image.Rectangle, origin image.Point) error {
+	select {
+	case <-s.first:
+	case <-s.failed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	bounds := s.frame.Rect.Add(origin)
+	intersect := bounds.Intersect(rect)
+	if intersect.Empty() {
+		return nil
+	}
+	width := intersect.Dx() * 4
+	for y := intersect.Min.Y; y < intersect.Max.Y; y++ {
+		from := s.frame.PixOffset(intersect.Min.X-origin.X, y-origin.Y)
+		to := dst.PixOffset(dst.Rect.Min.X+intersect.Min.X-rect.Min.X, dst.Rect.Min.Y+y-rect.Min.Y)
+		copy(dst.Pix[to:to+width], s.frame.Pix[from:from+width])
+	}
+	return nil
+}
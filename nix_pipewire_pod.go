@@ -0,0 +1,382 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// SPA POD types, from spa/utils/type.h.
+const (
+	podNone      = 1
+	podBool      = 2
+	podId        = 3
+	podInt       = 4
+	podLong      = 5
+	podString    = 8
+	podBytes     = 9
+	podRectangle = 10
+	podFraction  = 11
+	podArray     = 13
+	podStruct    = 14
+	podObject    = 15
+	podFd        = 18
+	podChoice    = 19
+)
+
+// SPA choice types.
+const (
+	choiceNone  = 0
+	choiceRange = 1
+	choiceEnum  = 3
+	choiceFlags = 4
+)
+
+var errMalformedPod = errors.New("pipewire: malformed POD")
+
+// podBuilder serializes SPA PODs, the data format of the PipeWire protocol.
+// PODs are encoded in the native byte order, padded to 8 bytes.
+type podBuilder struct {
+	buf []byte
+}
+
+func (b *podBuilder) bytes() []byte {
+	return b.buf
+}
+
+func (b *podBuilder) header(size, typ uint32) {
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, size)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, typ)
+}
+
+func (b *podBuilder) pad() {
+	for len(b.buf)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// push starts a container POD, whose size is filled in by pop.
+func (b *podBuilder) push(typ uint32) int {
+	start := len(b.buf)
+	b.header(0, typ)
+	return start
+}
+
+func (b *podBuilder) pop(start int) {
+	binary.NativeEndian.PutUint32(b.buf[start:], uint32(len(b.buf)-start-8))
+	b.pad()
+}
+
+func (b *podBuilder) none() {
+	b.header(0, podNone)
+}
+
+func (b *podBuilder) bool(v bool) {
+	var i uint32
+	if v {
+		i = 1
+	}
+	b.header(4, podBool)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, i)
+	b.pad()
+}
+
+func (b *podBuilder) id(v uint32) {
+	b.header(4, podId)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, v)
+	b.pad()
+}
+
+func (b *podBuilder) int(v int32) {
+	b.header(4, podInt)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, uint32(v))
+	b.pad()
+}
+
+func (b *podBuilder) long(v int64) {
+	b.header(8, podLong)
+	b.buf = binary.NativeEndian.AppendUint64(b.buf, uint64(v))
+}
+
+func (b *podBuilder) string(s string) {
+	b.header(uint32(len(s)+1), podString)
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	b.pad()
+}
+
+// fd appends a file descriptor, as an index into the descriptors sent along with the message.
+func (b *podBuilder) fd(index int64) {
+	b.header(8, podFd)
+	b.buf = binary.NativeEndian.AppendUint64(b.buf, uint64(index))
+}
+
+func (b *podBuilder) rectangle(width, height uint32) {
+	b.header(8, podRectangle)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, width)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, height)
+}
+
+func (b *podBuilder) fraction(num, denom uint32) {
+	b.header(8, podFraction)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, num)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, denom)
+}
+
+// raw appends an already serialized POD.
+func (b *podBuilder) raw(pod []byte) {
+	b.buf = append(b.buf, pod...)
+	b.pad()
+}
+
+func (b *podBuilder) structure(f func(b *podBuilder)) {
+	start := b.push(podStruct)
+	f(b)
+	b.pop(start)
+}
+
+// object appends an object of type typ for the parameter id. f adds the properties with prop.
+func (b *podBuilder) object(typ, id uint32, f func(b *podBuilder)) {
+	start := b.push(podObject)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, typ)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, id)
+	f(b)
+	b.pop(start)
+}
+
+// prop starts a property of an object. Its value is the next POD appended.
+func (b *podBuilder) prop(key, flags uint32) {
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, key)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, flags)
+}
+
+// choice appends a choice of the given kind between values, which are the bodies of PODs of childType.
+func (b *podBuilder) choice(kind, childType uint32, values ...[]byte) {
+	if len(values) == 0 {
+		panic("pipewire: empty choice")
+	}
+	start := b.push(podChoice)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, kind)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, 0)
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, uint32(len(values[0])))
+	b.buf = binary.NativeEndian.AppendUint32(b.buf, childType)
+	for _, v := range values {
+		b.buf = append(b.buf, v...)
+	}
+	b.pop(start)
+}
+
+func idValue(v uint32) []byte {
+	return binary.NativeEndian.AppendUint32(nil, v)
+}
+
+func intValue(v int32) []byte {
+	return binary.NativeEndian.AppendUint32(nil, uint32(v))
+}
+
+func rectangleValue(width, height uint32) []byte {
+	return binary.NativeEndian.AppendUint32(binary.NativeEndian.AppendUint32(nil, width), height)
+}
+
+func fractionValue(num, denom uint32) []byte {
+	return rectangleValue(num, denom)
+}
+
+// pod is a parsed SPA POD. body aliases the buffer it was parsed from.
+type pod struct {
+	typ  uint32
+	body []byte
+}
+
+// parsePod parses the POD at the start of b, and returns it along with the bytes following it.
+func parsePod(b []byte) (pod, []byte, error) {
+	if len(b) < 8 {
+		return pod{}, nil, errMalformedPod
+	}
+	size := binary.NativeEndian.Uint32(b)
+	typ := binary.NativeEndian.Uint32(b[4:])
+	if uint64(size) > uint64(len(b)-8) {
+		return pod{}, nil, errMalformedPod
+	}
+	p := pod{typ: typ, body: b[8 : 8+size]}
+	next := 8 + int(size)
+	next = (next + 7) &^ 7
+	if next > len(b) {
+		next = len(b)
+	}
+	return p, b[next:], nil
+}
+
+func (p pod) check(typ uint32, size int) error {
+	if p.typ != typ || len(p.body) < size {
+		return fmt.Errorf("%w: got type %d, want %d", errMalformedPod, p.typ, typ)
+	}
+	return nil
+}
+
+func (p pod) uint32() uint32 {
+	return binary.NativeEndian.Uint32(p.body)
+}
+
+// fields returns the members of a struct.
+func (p pod) fields() ([]pod, error) {
+	if err := p.check(podStruct, 0); err != nil {
+		return nil, err
+	}
+	var fields []pod
+	rest := p.body
+	for len(rest) > 0 {
+		f, next, err := parsePod(rest)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+		rest = next
+	}
+	return fields, nil
+}
+
+// podProp is a property of an object.
+type podProp struct {
+	key   uint32
+	flags uint32
+	value pod
+}
+
+// object returns the type, id and properties of an object.
+func (p pod) object() (typ, id uint32, props []podProp, err error) {
+	if err := p.check(podObject, 8); err != nil {
+		return 0, 0, nil, err
+	}
+	typ = binary.NativeEndian.Uint32(p.body)
+	id = binary.NativeEndian.Uint32(p.body[4:])
+	rest := p.body[8:]
+	for len(rest) > 0 {
+		if len(rest) < 8 {
+			return 0, 0, nil, errMalformedPod
+		}
+		prop := podProp{key: binary.NativeEndian.Uint32(rest), flags: binary.NativeEndian.Uint32(rest[4:])}
+		prop.value, rest, err = parsePod(rest[8:])
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		props = append(props, prop)
+	}
+	return typ, id, props, nil
+}
+
+// value returns the POD itself, or the default value of a choice.
+func (p pod) value() pod {
+	if p.typ != podChoice || len(p.body) < 16 {
+		return p
+	}
+	childSize := binary.NativeEndian.Uint32(p.body[8:])
+	childType := binary.NativeEndian.Uint32(p.body[12:])
+	values := p.body[16:]
+	if uint64(childSize) > uint64(len(values)) {
+		return pod{}
+	}
+	return pod{typ: childType, body: values[:childSize]}
+}
+
+// podReader reads the fields of a struct in order. The first error sticks, so that it can be
+// checked once after reading every field.
+type podReader struct {
+	fields []pod
+	err    error
+}
+
+func newPodReader(p pod) *podReader {
+	fields, err := p.fields()
+	return &podReader{fields: fields, err: err}
+}
+
+func (r *podReader) next(typ uint32, size int) pod {
+	if r.err != nil {
+		return pod{}
+	}
+	if len(r.fields) == 0 {
+		r.err = fmt.Errorf("%w: missing field", errMalformedPod)
+		return pod{}
+	}
+	p := r.fields[0]
+	r.fields = r.fields[1:]
+	if typ != 0 {
+		if err := p.check(typ, size); err != nil {
+			r.err = err
+			return pod{}
+		}
+	}
+	return p
+}
+
+func (r *podReader) int() int32 {
+	p := r.next(podInt, 4)
+	if r.err != nil {
+		return 0
+	}
+	return int32(p.uint32())
+}
+
+func (r *podReader) uint() uint32 {
+	return uint32(r.int())
+}
+
+func (r *podReader) id() uint32 {
+	p := r.next(podId, 4)
+	if r.err != nil {
+		return 0
+	}
+	return p.uint32()
+}
+
+func (r *podReader) long() int64 {
+	p := r.next(podLong, 8)
+	if r.err != nil {
+		return 0
+	}
+	return int64(binary.NativeEndian.Uint64(p.body))
+}
+
+func (r *podReader) bool() bool {
+	p := r.next(podBool, 4)
+	if r.err != nil {
+		return false
+	}
+	return p.uint32() != 0
+}
+
+func (r *podReader) string() string {
+	p := r.next(podString, 1)
+	if r.err != nil {
+		return ""
+	}
+	s := p.body
+	for i, c := range s {
+		if c == 0 {
+			return string(s[:i])
+		}
+	}
+	return string(s)
+}
+
+// fd returns the index of a file descriptor sent along with the message, or -1.
+func (r *podReader) fd() int {
+	p := r.next(podFd, 8)
+	if r.err != nil {
+		return -1
+	}
+	v := int64(binary.NativeEndian.Uint64(p.body))
+	if v < 0 || v > math.MaxInt32 {
+		return -1
+	}
+	return int(v)
+}
+
+// pod returns the next field whatever its type.
+func (r *podReader) pod() pod {
+	return r.next(0, 0)
+}
@@ -0,0 +1,342 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakePipeWire implements the daemon side of the PipeWire protocol, just enough for one client-node
+// consuming a 4x2 BGRx video in a single MemFd buffer.
+type fakePipeWire struct {
+	t    *testing.T
+	conn *net.UnixConn
+
+	activation, driver, skeleton, pixels, io *os.File
+	wakeup, signal                           *os.File // our ends of the pipes given to the client
+
+	ready chan struct{} // closed once the client-node is set up
+}
+
+const fakeDriverId = 99
+
+// newFakePipeWire returns the fake daemon and the fd of a socket connected to it.
+func newFakePipeWire(t *testing.T) (*fakePipeWire, int) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := os.NewFile(uintptr(fds[1]), "fake-pipewire")
+	defer file.Close()
+	conn, err := net.FileConn(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakePipeWire{t: t, conn: conn.(*net.UnixConn), ready: make(chan struct{})}
+	t.Cleanup(func() { f.conn.Close() })
+	f.activation = f.memory(64)
+	f.driver = f.memory(64)
+	f.skeleton = f.memory(16)
+	f.pixels = f.memory(32)
+	f.io = f.memory(8)
+	go f.serve()
+	return f, fds[0]
+}
+
+func (f *fakePipeWire) memory(size int64) *os.File {
+	file, err := os.CreateTemp(f.t.TempDir(), "mem")
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.t.Cleanup(func() { file.Close() })
+	if err := file.Truncate(size); err != nil {
+		f.t.Fatal(err)
+	}
+	return file
+}
+
+func (f *fakePipeWire) send(id, opcode uint32, build func(b *podBuilder), fds ...int) {
+	var b podBuilder
+	b.structure(build)
+	hdr := make([]byte, 16)
+	binary.NativeEndian.PutUint32(hdr, id)
+	binary.NativeEndian.PutUint32(hdr[4:], opcode<<24|uint32(len(b.bytes())))
+	binary.NativeEndian.PutUint32(hdr[12:], uint32(len(fds)))
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	if _, _, err := f.conn.WriteMsgUnix(append(hdr, b.bytes()...), oob, nil); err != nil {
+		f.t.Error(err)
+	}
+}
+
+func (f *fakePipeWire) addMem(id uint32, file *os.File) {
+	f.send(pwCoreId, pwCoreEventAddMem, func(b *podBuilder) {
+		b.int(int32(id))
+		b.id(spaDataMemFd)
+		b.fd(0)
+		b.int(pwMemblockReadable | pwMemblockWritable)
+	}, int(file.Fd()))
+}
+
+func (f *fakePipeWire) serve() {
+	for {
+		hdr := make([]byte, 16)
+		if _, err := io.ReadFull(f.conn, hdr); err != nil {
+			return
+		}
+		id := binary.NativeEndian.Uint32(hdr)
+		p1 := binary.NativeEndian.Uint32(hdr[4:])
+		payload := make([]byte, p1&0xffffff)
+		if _, err := io.ReadFull(f.conn, payload); err != nil {
+			return
+		}
+		p, _, err := parsePod(payload)
+		if err != nil {
+			f.t.Error(err)
+			return
+		}
+		r := newPodReader(p)
+		switch {
+		case id == pwCoreId && p1>>24 == pwCoreMethodSync:
+			objectId, seq := r.int(), r.int()
+			f.send(pwCoreId, pwCoreEventDone, func(b *podBuilder) {
+				b.int(objectId)
+				b.int(seq)
+			})
+		case id == pwCoreId && p1>>24 == pwCoreMethodCreateObject:
+			if factory := r.string(); factory != "client-node" {
+				f.t.Errorf("CreateObject(%q)", factory)
+			}
+		case id == pwClientId+1 && p1>>24 == pwClientNodeMethodSetActive:
+			f.setup()
+		}
+	}
+}
+
+// setup configures the client-node as the daemon does once it is linked to the driver.
+func (f *fakePipeWire) setup() {
+	node := uint32(pwClientId + 1)
+	f.send(pwCoreId, pwCoreEventBoundId, func(b *podBuilder) {
+		b.int(int32(node))
+		b.int(42)
+	})
+
+	wakeupRead, wakeupWrite, err := os.Pipe()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer wakeupRead.Close()
+	f.wakeup = wakeupWrite
+	f.addMem(0, f.activation)
+	f.send(node, pwClientNodeEventTransport, func(b *podBuilder) {
+		b.fd(0)
+		b.fd(1)
+		b.int(0)
+		b.int(0)
+		b.int(64)
+	}, int(wakeupRead.Fd()), int(wakeupWrite.Fd()))
+
+	f.send(node, pwClientNodeEventPortSetParam, func(b *podBuilder) {
+		b.int(spaDirectionInput)
+		b.int(0)
+		b.id(spaParamFormat)
+		b.int(0)
+		b.object(spaTypeObjectFormat, spaParamFormat, func(b *podBuilder) {
+			b.prop(spaFormatMediaType, 0)
+			b.id(spaMediaTypeVideo)
+			b.prop(spaFormatMediaSubtype, 0)
+			b.id(spaMediaSubtypeRaw)
+			b.prop(spaFormatVideoFormat, 0)
+			b.id(spaVideoFormatBGRx)
+			b.prop(spaFormatVideoSize, 0)
+			b.rectangle(4, 2)
+		})
+	})
+
+	f.addMem(1, f.skeleton)
+	f.addMem(2, f.pixels)
+	f.send(node, pwClientNodeEventPortUseBuffers, func(b *podBuilder) {
+		b.int(spaDirectionInput)
+		b.int(0)
+		b.int(0)
+		b.int(0)
+		b.int(1)
+		// The buffer: its skeleton in memory 1, no metadata, and its data in memory 2.
+		b.int(1)
+		b.int(0)
+		b.int(16)
+		b.int(0)
+		b.int(1)
+		b.id(spaDataMemFd)
+		b.int(2)
+		b.int(0)
+		b.int(0)
+		b.int(32)
+	})
+
+	f.addMem(3, f.io)
+	f.send(node, pwClientNodeEventPortSetIO, func(b *podBuilder) {
+		b.int(spaDirectionInput)
+		b.int(0)
+		b.int(0)
+		b.id(spaIoBuffers)
+		b.int(3)
+		b.int(0)
+		b.int(8)
+	})
+
+	signalRead, signalWrite, err := os.Pipe()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer signalWrite.Close()
+	f.signal = signalRead
+	f.addMem(4, f.driver)
+	f.send(node, pwClientNodeEventSetActivation, func(b *podBuilder) {
+		b.int(fakeDriverId)
+		b.fd(0)
+		b.int(4)
+		b.int(0)
+		b.int(64)
+	}, int(signalWrite.Fd()))
+	close(f.ready)
+}
+
+// frame hands a frame to the client and waits until the client has consumed it.
+func (f *fakePipeWire) frame(pixels []byte) {
+	<-f.ready
+	writeUint32s := func(file *os.File, values ...uint32) {
+		b := make([]byte, 4*len(values))
+		for i, v := range values {
+			binary.NativeEndian.PutUint32(b[4*i:], v)
+		}
+		if _, err := file.WriteAt(b, 0); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	if _, err := f.pixels.WriteAt(pixels, 0); err != nil {
+		f.t.Fatal(err)
+	}
+	writeUint32s(f.skeleton, 0, uint32(len(pixels)), 16, 0)
+	writeUint32s(f.io, spaStatusHaveData, 0)
+	// The driver waits for one node, the client.
+	writeUint32s(f.driver, 0, 0, 0, 1, 1)
+
+	one := make([]byte, 8)
+	binary.NativeEndian.PutUint64(one, 1)
+	if _, err := f.wakeup.Write(one); err != nil {
+		f.t.Fatal(err)
+	}
+	_ = f.signal.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(f.signal, one); err != nil {
+		f.t.Fatalf("the driver was not signaled: %v", err)
+	}
+}
+
+func (f *fakePipeWire) readUint32(file *os.File, offset int64) uint32 {
+	b := make([]byte, 4)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		f.t.Fatal(err)
+	}
+	return binary.NativeEndian.Uint32(b)
+}
+
+func TestPipeWireStream(t *testing.T) {
+	fake, fd := newFakePipeWire(t)
+	c, err := newPWConn(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := c.consume(ctx, fakeDriverId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// BGRx, with a red pixel at (1, 0) and a blue one at (2, 1).
+	pixels := make([]byte, 32)
+	copy(pixels[4:], []byte{0, 0, 255, 0})
+	copy(pixels[16+8:], []byte{255, 0, 0, 0})
+	fake.frame(pixels)
+
+	if got := fake.readUint32(fake.io, 0); got != spaStatusNeedData {
+		t.Errorf("io status = %d, want NEED_DATA", got)
+	}
+	if got := fake.readUint32(fake.driver, 16); got != 0 {
+		t.Errorf("driver pending = %d, want 0", got)
+	}
+	if got := fake.readUint32(fake.driver, 0); got != pwActivationTriggered {
+		t.Errorf("driver status = %d, want TRIGGERED", got)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	err = stream.copyFrame(ctx, dst, image.Rect(10, 20, 13, 22), image.Pt(10, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dst.RGBAAt(1, 0), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("RGBAAt(1, 0) = %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(2, 1), (color.RGBA{0, 0, 255, 255}); got != want {
+		t.Errorf("RGBAAt(2, 1) = %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{0, 0, 0, 255}); got != want {
+		t.Errorf("RGBAAt(0, 0) = %v, want %v", got, want)
+	}
+}
+
+func TestPodRoundtrip(t *testing.T) {
+	var b podBuilder
+	b.structure(func(b *podBuilder) {
+		b.int(-3)
+		b.string("screen")
+		b.long(1 << 40)
+		b.bool(true)
+		b.fd(2)
+		b.raw(enumFormatParam())
+	})
+	p, rest, err := parsePod(b.bytes())
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("parsePod() = %v, %d bytes left", err, len(rest))
+	}
+	r := newPodReader(p)
+	if v := r.int(); v != -3 {
+		t.Errorf("int = %d", v)
+	}
+	if v := r.string(); v != "screen" {
+		t.Errorf("string = %q", v)
+	}
+	if v := r.long(); v != 1<<40 {
+		t.Errorf("long = %d", v)
+	}
+	if v := r.bool(); !v {
+		t.Errorf("bool = %v", v)
+	}
+	if v := r.fd(); v != 2 {
+		t.Errorf("fd = %d", v)
+	}
+	typ, id, props, err := r.pod().object()
+	if err != nil || typ != spaTypeObjectFormat || id != spaParamEnumFormat || len(props) != 5 {
+		t.Fatalf("object() = %#x, %d, %d props, %v", typ, id, len(props), err)
+	}
+	// The default of the choice of formats.
+	if v := props[2].value.value(); v.typ != podId || v.uint32() != spaVideoFormatBGRx {
+		t.Errorf("format = %+v", v)
+	}
+	if r.int(); r.err == nil {
+		t.Error("reading past the last field did not fail")
+	}
+}
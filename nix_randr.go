@@ -0,0 +1,434 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"fmt"
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+	"image"
+	"slices"
+	"strconv"
+)
+
+// initRandR reports whether the X server supports RandR 1.3 or later, which is required for
+// GetScreenResourcesCurrent, and whether it supports the monitors of RandR 1.5.
+func initRandR(c *xgb.Conn) (ok, monitors bool) {
+	if randr.Init(c) != nil {
+		return false, false
+	}
+	reply, err := randr.QueryVersion(c, 1, 5).Reply()
+	if err != nil {
+		return false, false
+	}
+	if reply.MajorVersion != 1 {
+		return reply.MajorVersion > 1, reply.MajorVersion > 1
+	}
+	return reply.MinorVersion >= 3, reply.MinorVersion >= 5
+}
+
+// selectScreenChanges makes RandR notify the changes of the screens, CRTCs and outputs of every X screen
+// to the connection.
+func selectScreenChanges(c *xgb.Conn) error {
+	mask := uint16(randr.NotifyMaskScreenChange | randr.NotifyMaskCrtcChange | randr.NotifyMaskOutputChange)
+	var cookies []randr.SelectInputCookie
+	for _, root := range xproto.Setup(c).Roots {
+		cookies = append(cookies, randr.SelectInputChecked(c, root.Root, mask))
+	}
+	for _, cookie := range cookies {
+		if err := cookie.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isScreenChange reports whether ev is a notification of selectScreenChanges.
+func isScreenChange(ev xgb.Event) bool {
+	switch ev.(type) {
+	case randr.ScreenChangeNotifyEvent, randr.NotifyEvent:
+		return true
+	}
+	return false
+}
+
+// randrOutput is an enabled RandR output along with the CRTC driving it.
+type randrOutput struct {
+	id          randr.Output
+	name        string
+	primary     bool
+	bounds      image.Rectangle
+	widthMM     int
+	heightMM    int
+	rotation    uint16 // along with the reflection
+	refreshRate float64
+	panning     image.Rectangle // empty unless the CRTC pans
+}
+
+// randrOutputs returns the connected outputs of the X screen of root which have a CRTC assigned.
+// Requests are pipelined so that the round trips do not add up with the number of outputs.
+func (s *xSession) randrOutputs(root xproto.Window) ([]randrOutput, error) {
+	resCookie := randr.GetScreenResourcesCurrent(s.conn, root)
+	primaryCookie := randr.GetOutputPrimary(s.conn, root)
+	res, err := resCookie.Reply()
+	if err != nil {
+		return nil, err
+	}
+	primary, err := primaryCookie.Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	infoCookies := make([]randr.GetOutputInfoCookie, len(res.Outputs))
+	for i, output := range res.Outputs {
+		infoCookies[i] = randr.GetOutputInfo(s.conn, output, res.ConfigTimestamp)
+	}
+	infos := make([]*randr.GetOutputInfoReply, len(res.Outputs))
+	crtcCookies := map[randr.Crtc]randr.GetCrtcInfoCookie{}
+	panningCookies := map[randr.Crtc]randr.GetPanningCookie{}
+	for i, cookie := range infoCookies {
+		info, err := cookie.Reply()
+		if err != nil {
+			return nil, err
+		}
+		if info.Connection != randr.ConnectionConnected || info.Crtc == 0 {
+			continue
+		}
+		infos[i] = info
+		if _, ok := crtcCookies[info.Crtc]; !ok {
+			crtcCookies[info.Crtc] = randr.GetCrtcInfo(s.conn, info.Crtc, res.ConfigTimestamp)
+			panningCookies[info.Crtc] = randr.GetPanning(s.conn, info.Crtc)
+		}
+	}
+	crtcs := make(map[randr.Crtc]*randr.GetCrtcInfoReply, len(crtcCookies))
+	for crtc, cookie := range crtcCookies {
+		reply, err := cookie.Reply()
+		if err != nil {
+			return nil, err
+		}
+		crtcs[crtc] = reply
+	}
+	pannings := make(map[randr.Crtc]image.Rectangle, len(panningCookies))
+	for crtc, cookie := range panningCookies {
+		// Drivers which cannot pan fail the request, which means no panning.
+		reply, err := cookie.Reply()
+		if err == nil && reply.Width > 0 && reply.Height > 0 {
+			pannings[crtc] = image.Rect(int(reply.Left), int(reply.Top),
+				int(reply.Left)+int(reply.Width), int(reply.Top)+int(reply.Height))
+		}
+	}
+
+	var outputs []randrOutput
+	for i, info := range infos {
+		if info == nil {
+			continue
+		}
+		crtc := crtcs[info.Crtc]
+		if crtc.Mode == 0 {
+			continue
+		}
+		outputs = append(outputs, randrOutput{
+			id:          res.Outputs[i],
+			name:        string(info.Name),
+			primary:     res.Outputs[i] == primary.Output,
+			bounds:      image.Rect(int(crtc.X), int(crtc.Y), int(crtc.X)+int(crtc.Width), int(crtc.Y)+int(crtc.Height)),
+			widthMM:     int(info.MmWidth),
+			heightMM:    int(info.MmHeight),
+			rotation:    crtc.Rotation,
+			refreshRate: modeRefreshRate(res.Modes, crtc.Mode),
+			panning:     pannings[info.Crtc],
+		})
+	}
+	return outputs, nil
+}
+
+// modeRefreshRate returns the vertical refresh rate of the mode in Hz, or 0 if it is unknown.
+func modeRefreshRate(modes []randr.ModeInfo, mode randr.Mode) float64 {
+	for _, m := range modes {
+		if randr.Mode(m.Id) != mode {
+			continue
+		}
+		vtotal := float64(m.Vtotal)
+		if m.ModeFlags&randr.ModeFlagDoubleScan != 0 {
+			vtotal *= 2
+		}
+		if m.ModeFlags&randr.ModeFlagInterlace != 0 {
+			vtotal /= 2
+		}
+		if m.Htotal == 0 || vtotal == 0 {
+			return 0
+		}
+		return float64(m.DotClock) / (float64(m.Htotal) * vtotal)
+	}
+	return 0
+}
+
+// randrRotation converts the counter-clockwise RandR rotation into a clockwise Rotation.
+func randrRotation(rotation uint16) Rotation {
+	switch {
+	case rotation&randr.RotationRotate90 != 0:
+		return Rotate270
+	case rotation&randr.RotationRotate180 != 0:
+		return Rotate180
+	case rotation&randr.RotationRotate270 != 0:
+		return Rotate90
+	default:
+		return Rotate0
+	}
+}
+
+// xMonitor is a region of the root window shown as a display.
+type xMonitor struct {
+	bounds            image.Rectangle
+	primary           bool
+	widthMM, heightMM int
+	outputs           []randr.Output // showing the monitor, if known
+}
+
+// monitors returns the monitors of the X screen num, the primary first. The monitors of RandR 1.5 follow
+// hotplugged outputs, unlike the Xinerama of some servers, which is the fallback for the first screen.
+// Xinerama only describes the first screen, so the others, and the first one on a server without
+// Xinerama, fall back to the RandR outputs, or else to the whole root window.
+func (s *xSession) monitors(num int) ([]xMonitor, error) {
+	root := s.root(num)
+	if s.hasMonitors {
+		monitors, err := s.randrMonitorList(root.Root)
+		if err == nil && len(monitors) > 0 {
+			return monitors, nil
+		}
+	}
+	if num == 0 && s.hasXinerama {
+		return s.queryXinerama()
+	}
+	var outputs []randrOutput
+	if s.hasRandR {
+		outputs, _ = s.randrOutputs(root.Root)
+	}
+	return randrMonitors(outputs, root), nil
+}
+
+// xScreenArea is an X screen placed in the coordinate system of Capture. The screen of the session holds
+// the origin, and the other screens follow on its right, aligned with its top, in the order of their numbers.
+type xScreenArea struct {
+	num      int
+	screen   *xproto.ScreenInfo
+	offset   image.Point // position of the root window
+	monitors []xMonitor  // in root window coordinates
+}
+
+// rootBounds returns the extent of the root window.
+func (a *xScreenArea) rootBounds() image.Rectangle {
+	return image.Rect(0, 0, int(a.screen.WidthInPixels), int(a.screen.HeightInPixels))
+}
+
+// bounds returns the region of the screen in the coordinate system of Capture.
+func (a *xScreenArea) bounds() image.Rectangle {
+	return a.rootBounds().Add(a.offset)
+}
+
+// screenAreas returns the X screens of the server, that of the session first. The layout is kept until
+// RandR notifies a change of it, or a capture fails, rather than queried on each capture.
+func (s *xSession) screenAreas() ([]xScreenArea, error) {
+	s.screensMu.Lock()
+	defer s.screensMu.Unlock()
+	for s.pollEvents {
+		ev, err := s.conn.PollForEvent()
+		if ev == nil && err == nil {
+			break
+		}
+		if isScreenChange(ev) {
+			s.areas = nil
+		}
+	}
+	// Without RandR, the layout cannot change.
+	if s.areas != nil && (s.watchesScreens || !s.hasRandR) {
+		return s.areas, nil
+	}
+	areas, err := s.queryScreenAreas()
+	if err != nil {
+		return nil, err
+	}
+	s.areas = areas
+	return areas, nil
+}
+
+// invalidateScreens makes the next call of screenAreas query the layout of the screens again.
+func (s *xSession) invalidateScreens() {
+	s.screensMu.Lock()
+	s.areas = nil
+	s.screensMu.Unlock()
+}
+
+// queryScreenAreas queries the layout of the screens, see screenAreas.
+func (s *xSession) queryScreenAreas() ([]xScreenArea, error) {
+	monitors, err := s.monitors(s.screenNum)
+	if err != nil {
+		return nil, err
+	}
+	areas := []xScreenArea{{num: s.screenNum, screen: s.screen, offset: monitors[0].bounds.Min.Mul(-1), monitors: monitors}}
+	top, right := areas[0].offset.Y, areas[0].bounds().Max.X
+	roots := xproto.Setup(s.conn).Roots
+	for i := range roots {
+		if i == s.screenNum {
+			continue
+		}
+		monitors, err := s.monitors(i)
+		if err != nil {
+			return nil, err
+		}
+		a := xScreenArea{num: i, screen: &roots[i], offset: image.Pt(right, top), monitors: monitors}
+		areas = append(areas, a)
+		right = a.bounds().Max.X
+	}
+	return areas, nil
+}
+
+// monitorBounds returns the regions of the monitors of every X screen in the coordinate system of
+// Capture, in the order of the display indices.
+func (s *xSession) monitorBounds() ([]image.Rectangle, error) {
+	areas, err := s.screenAreas()
+	if err != nil {
+		return nil, err
+	}
+	var bounds []image.Rectangle
+	for _, a := range areas {
+		for _, m := range a.monitors {
+			bounds = append(bounds, m.bounds.Add(a.offset))
+		}
+	}
+	return bounds, nil
+}
+
+// randrMonitorList returns the active RandR 1.5 monitors of the X screen of root, the primary first.
+func (s *xSession) randrMonitorList(root xproto.Window) ([]xMonitor, error) {
+	reply, err := randr.GetMonitors(s.conn, root, true).Reply()
+	if err != nil {
+		return nil, err
+	}
+	monitors := make([]xMonitor, 0, len(reply.Monitors))
+	for _, primary := range []bool{true, false} {
+		for _, m := range reply.Monitors {
+			if m.Primary != primary || m.Width == 0 || m.Height == 0 {
+				continue
+			}
+			monitors = append(monitors, xMonitor{
+				bounds:   image.Rect(int(m.X), int(m.Y), int(m.X)+int(m.Width), int(m.Y)+int(m.Height)),
+				primary:  m.Primary,
+				widthMM:  int(m.WidthInMillimeters),
+				heightMM: int(m.HeightInMillimeters),
+				outputs:  m.Outputs,
+			})
+		}
+	}
+	return monitors, nil
+}
+
+// randrMonitors returns the distinct regions shown by the outputs, those of the primary output first.
+// Without outputs, the whole root window of screen is a monitor.
+func randrMonitors(outputs []randrOutput, screen *xproto.ScreenInfo) []xMonitor {
+	var monitors []xMonitor
+	seen := map[image.Rectangle]bool{}
+	for _, primary := range []bool{true, false} {
+		for _, o := range outputs {
+			if o.primary != primary || seen[o.bounds] {
+				continue
+			}
+			seen[o.bounds] = true
+			monitors = append(monitors, xMonitor{bounds: o.bounds, primary: o.primary})
+		}
+	}
+	if len(monitors) == 0 {
+		monitors = append(monitors, xMonitor{bounds: image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels))})
+	}
+	return monitors
+}
+
+// matchOutput returns the output showing the monitor: one of its outputs if they are known, or else an
+// output of the same region. When several outputs mirror the monitor, the primary output is preferred.
+func matchOutput(outputs []randrOutput, m xMonitor) *randrOutput {
+	var match *randrOutput
+	for i := range outputs {
+		o := &outputs[i]
+		if len(m.outputs) > 0 && !slices.Contains(m.outputs, o.id) || len(m.outputs) == 0 && o.bounds != m.bounds {
+			continue
+		}
+		if match == nil || (o.primary && !match.primary) {
+			match = o
+		}
+	}
+	return match
+}
+
+// displays returns the displays of every X screen, in the same order as getDisplayBounds: those of the
+// screen of the session first, then those of the other screens in turn. Only the screen of the session
+// has a primary display.
+func (s *xSession) displays() ([]Display, error) {
+	areas, err := s.screenAreas()
+	if err != nil {
+		return nil, err
+	}
+	var displays []Display
+	for _, a := range areas {
+		var outputs []randrOutput
+		if s.hasRandR {
+			// Missing details are not fatal, the geometry is still valid.
+			outputs, _ = s.randrOutputs(a.screen.Root)
+		}
+		more := monitorDisplays(a.num, len(displays), a.monitors, outputs, a.offset)
+		for i := range more {
+			more[i].Primary = more[i].Primary && a.num == s.screenNum
+		}
+		displays = append(displays, more...)
+	}
+	return displays, nil
+}
+
+// monitorDisplays describes the monitors of the X screen num, whose root window is at offset in the
+// coordinate system of Capture, with the details of the outputs. The displays are indexed from first.
+// When no monitor nor output is marked primary, the first monitor is.
+func monitorDisplays(num, first int, monitors []xMonitor, outputs []randrOutput, offset image.Point) []Display {
+	hasPrimary := false
+	for _, m := range monitors {
+		hasPrimary = hasPrimary || m.primary
+	}
+	for _, o := range outputs {
+		hasPrimary = hasPrimary || o.primary
+	}
+
+	displays := make([]Display, 0, len(monitors))
+	for i, m := range monitors {
+		// Without RandR, the index is the best available identifier.
+		d := Display{
+			Index:       first + i,
+			ID:          strconv.Itoa(i),
+			Primary:     m.primary || i == 0 && !hasPrimary,
+			Bounds:      m.bounds.Add(offset),
+			WidthMM:     m.widthMM,
+			HeightMM:    m.heightMM,
+			ScaleFactor: 1,
+			Screen:      num,
+		}
+		if num != 0 {
+			d.ID = fmt.Sprintf("%d.%d", num, i)
+		}
+		if match := matchOutput(outputs, m); match != nil {
+			d.ID = strconv.FormatUint(uint64(match.id), 10)
+			d.Name = match.name
+			d.Primary = d.Primary || match.primary
+			if d.WidthMM == 0 && d.HeightMM == 0 {
+				d.WidthMM = match.widthMM
+				d.HeightMM = match.heightMM
+			}
+			d.Rotation = randrRotation(match.rotation)
+			d.ReflectX = match.rotation&randr.RotationReflectX != 0
+			d.ReflectY = match.rotation&randr.RotationReflectY != 0
+			d.RefreshRate = match.refreshRate
+			if !match.panning.Empty() {
+				d.Panning = match.panning.Add(offset)
+			}
+		}
+		displays = append(displays, d)
+	}
+	return displays
+}
@@ -0,0 +1,102 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestRandRMonitors(t *testing.T) {
+	root := &xproto.ScreenInfo{WidthInPixels: 3840, HeightInPixels: 1080}
+	outputs := []randrOutput{
+		{id: 1, bounds: image.Rect(0, 0, 1920, 1080)},
+		{id: 2, bounds: image.Rect(1920, 0, 3840, 1080), primary: true},
+		{id: 3, bounds: image.Rect(0, 0, 1920, 1080)}, // mirrors the first output
+	}
+	want := []xMonitor{
+		{bounds: image.Rect(1920, 0, 3840, 1080), primary: true},
+		{bounds: image.Rect(0, 0, 1920, 1080)},
+	}
+	if got := randrMonitors(outputs, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("randrMonitors() = %+v, want %+v", got, want)
+	}
+
+	// Without RandR, the root window is the only monitor.
+	want = []xMonitor{{bounds: image.Rect(0, 0, 3840, 1080)}}
+	if got := randrMonitors(nil, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("randrMonitors(nil) = %+v, want %+v", got, want)
+	}
+}
+
+func TestMatchOutput(t *testing.T) {
+	outputs := []randrOutput{
+		{id: 1, bounds: image.Rect(0, 0, 1920, 1080)},
+		{id: 2, bounds: image.Rect(0, 0, 1920, 1080), primary: true},
+		{id: 3, bounds: image.Rect(1920, 0, 2880, 1080)},
+		{id: 4, bounds: image.Rect(2880, 0, 3840, 1080)},
+	}
+	tests := []struct {
+		monitor xMonitor
+		want    randr.Output
+	}{
+		// The primary one of mirrored outputs.
+		{xMonitor{bounds: image.Rect(0, 0, 1920, 1080)}, 2},
+		// A monitor tiled across two outputs, which RandR 1.5 lists.
+		{xMonitor{bounds: image.Rect(1920, 0, 3840, 1080), outputs: []randr.Output{3, 4}}, 3},
+		// The outputs of a monitor are preferred to its region.
+		{xMonitor{bounds: image.Rect(0, 0, 1920, 1080), outputs: []randr.Output{1}}, 1},
+		{xMonitor{bounds: image.Rect(0, 0, 800, 600)}, 0},
+	}
+	for _, tt := range tests {
+		var got randr.Output
+		if o := matchOutput(outputs, tt.monitor); o != nil {
+			got = o.id
+		}
+		if got != tt.want {
+			t.Errorf("matchOutput(%+v) = output %d, want %d", tt.monitor, got, tt.want)
+		}
+	}
+}
+
+func TestMonitorDisplays(t *testing.T) {
+	left, right := image.Rect(0, 0, 1920, 1080), image.Rect(1920, 0, 3840, 1080)
+	outputs := []randrOutput{
+		{id: 63, name: "DP-1", bounds: left},
+		{id: 64, name: "HDMI-1", bounds: right, rotation: randr.RotationRotate90 | randr.RotationReflectX,
+			panning: image.Rect(1920, 0, 3840, 2160)},
+	}
+
+	// Without a primary output, the first monitor is the primary display.
+	displays := monitorDisplays(0, 0, []xMonitor{{bounds: left}, {bounds: right}}, outputs, image.Point{})
+	if d := displays[0]; d.ID != "63" || d.Name != "DP-1" || !d.Primary || d.Bounds != left {
+		t.Errorf("displays[0] = %+v", d)
+	}
+	d := displays[1]
+	if d.ID != "64" || d.Primary || d.Rotation != Rotate270 || !d.ReflectX || d.ReflectY ||
+		d.Panning != image.Rect(1920, 0, 3840, 2160) {
+		t.Errorf("displays[1] = %+v", d)
+	}
+
+	// Otherwise only the primary one is, whether RandR marks the output or the monitor.
+	outputs[1].primary = true
+	displays = monitorDisplays(0, 0, []xMonitor{{bounds: right}, {bounds: left}}, outputs, image.Point{})
+	if !displays[0].Primary || displays[1].Primary {
+		t.Errorf("Primary = %v, %v, want the output marked primary", displays[0].Primary, displays[1].Primary)
+	}
+	outputs[1].primary = false
+	displays = monitorDisplays(0, 0, []xMonitor{{bounds: right, primary: true}, {bounds: left}}, outputs, image.Point{})
+	if !displays[0].Primary || displays[1].Primary {
+		t.Errorf("Primary = %v, %v, want the monitor marked primary", displays[0].Primary, displays[1].Primary)
+	}
+
+	// Without RandR, the displays of another screen are identified by their screen and index within it.
+	// The screen is placed at offset, and its displays follow those of the previous screens.
+	displays = monitorDisplays(1, 2, []xMonitor{{bounds: left}}, nil, image.Pt(3840, 0))
+	if d := displays[0]; d.ID != "1.0" || d.Screen != 1 || d.Index != 2 || d.Bounds != left.Add(image.Pt(3840, 0)) {
+		t.Errorf("displays of screen 1 = %+v", d)
+	}
+}
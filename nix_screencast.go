@@ -0,0 +1,172 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"image"
+	"image/draw"
+	"sync/atomic"
+)
+
+const (
+	screenCastInterface = "org.freedesktop.portal.ScreenCast"
+
+	screenCastSourceMonitor = 1
+
+	screenCastCursorHidden   = 1
+	screenCastCursorEmbedded = 2
+
+	screenCastPersistUntilRevoked = 2
+)
+
+// screenCast is a session of the ScreenCast portal, whose streams are received through PipeWire.
+// Unlike the Screenshot portal, it delivers frames continuously without writing files.
+type screenCast struct {
+	bus          *dbus.Conn
+	session      dbus.ObjectPath
+	pw           *pwConn
+	streams      []castStream
+	restoreToken string
+}
+
+// castStream is a stream of the session, usually one per monitor.
+type castStream struct {
+	nodeId   uint32
+	position image.Point // position of the monitor in the desktop, if known
+	node     *pwStream
+}
+
+// openScreenCast starts a ScreenCast session of every monitor. The portal asks the user for approval,
+// unless restoreToken comes from a session it allows to restore.
+func openScreenCast(ctx context.Context, restoreToken string, cursor bool) (sc *screenCast, e error) {
+	bus, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("%w: dbus.SessionBus() failed: %w", ErrNoDisplay, err)
+	}
+	sc = &screenCast{bus: bus}
+	defer func() {
+		if e != nil {
+			sc.close()
+			sc = nil
+		}
+	}()
+
+	obj := bus.Object(portalBusName, portalObjectPath)
+	cursorMode := uint32(screenCastCursorHidden)
+	if cursor {
+		modes, err := obj.GetProperty(screenCastInterface + ".AvailableCursorModes")
+		if err == nil {
+			if m, ok := modes.Value().(uint32); ok && m&screenCastCursorEmbedded != 0 {
+				cursorMode = screenCastCursorEmbedded
+			}
+		}
+	}
+
+	results, err := callPortal(ctx, bus, screenCastInterface+".CreateSession", map[string]dbus.Variant{
+		"session_handle_token": dbus.MakeVariant(fmt.Sprintf("screenshot%d", atomic.AddUint64(&gTokenCounter, 1))),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", screenCastInterface, err)
+	}
+	handle, ok := results["session_handle"].Value().(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: CreateSession returned no session_handle", screenCastInterface)
+	}
+	sc.session = dbus.ObjectPath(handle)
+
+	options := map[string]dbus.Variant{
+		"types":        dbus.MakeVariant(uint32(screenCastSourceMonitor)),
+		"multiple":     dbus.MakeVariant(true),
+		"cursor_mode":  dbus.MakeVariant(cursorMode),
+		"persist_mode": dbus.MakeVariant(uint32(screenCastPersistUntilRevoked)),
+	}
+	if restoreToken != "" {
+		options["restore_token"] = dbus.MakeVariant(restoreToken)
+	}
+	_, err = callPortal(ctx, bus, screenCastInterface+".SelectSources", options, sc.session)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", screenCastInterface, err)
+	}
+
+	results, err = callPortal(ctx, bus, screenCastInterface+".Start", map[string]dbus.Variant{}, sc.session, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", screenCastInterface, err)
+	}
+	sc.streams, err = parseCastStreams(results["streams"])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", screenCastInterface, err)
+	}
+	sc.restoreToken, _ = results["restore_token"].Value().(string)
+
+	var fd dbus.UnixFD
+	err = obj.CallWithContext(ctx, screenCastInterface+".OpenPipeWireRemote", 0, sc.session, map[string]dbus.Variant{}).Store(&fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", screenCastInterface, err)
+	}
+	sc.pw, err = newPWConn(int(fd))
+	if err != nil {
+		return nil, err
+	}
+	for i := range sc.streams {
+		sc.streams[i].node, err = sc.pw.consume(ctx, sc.streams[i].nodeId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sc, nil
+}
+
+// parseCastStreams parses the streams returned by Start, of D-Bus type a(ua{sv}).
+func parseCastStreams(v dbus.Variant) ([]castStream, error) {
+	var raw []struct {
+		NodeId     uint32
+		Properties map[string]dbus.Variant
+	}
+	if v.Value() == nil {
+		return nil, errors.New("Start returned no stream")
+	}
+	err := dbus.Store([]interface{}{v.Value()}, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("Start returned no stream")
+	}
+	streams := make([]castStream, len(raw))
+	for i, r := range raw {
+		streams[i].nodeId = r.NodeId
+		var position struct{ X, Y int32 }
+		if p, ok := r.Properties["position"]; ok && dbus.Store([]interface{}{p.Value()}, &position) == nil {
+			streams[i].position = image.Pt(int(position.X), int(position.Y))
+		}
+	}
+	return streams, nil
+}
+
+// captureInto copies rect of the desktop into dst from the last frames of the streams. The desktop
+// is made of the frames placed at the positions of their monitors, at their native size.
+// Areas outside of every frame are left transparent.
+func (sc *screenCast) captureInto(ctx context.Context, dst *image.RGBA, rect image.Rectangle) error {
+	draw.Draw(dst, dst.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	for _, stream := range sc.streams {
+		err := stream.node.copyFrame(ctx, dst, rect, stream.position)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *screenCast) close() {
+	if sc.pw != nil {
+		sc.pw.close()
+	}
+	if sc.session != "" {
+		sc.bus.Object(portalBusName, sc.session).Go("org.freedesktop.portal.Session.Close", dbus.FlagNoReplyExpected, nil)
+	}
+	_ = sc.bus.Close()
+}
@@ -0,0 +1,140 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"errors"
+	"github.com/godbus/dbus/v5"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// fakeScreenCastPortal stands in for the ScreenCast portal of xdg-desktop-portal, streaming one
+// monitor at (100, 0) from a fakePipeWire.
+type fakeScreenCastPortal struct {
+	conn         *dbus.Conn
+	pipewire     int // fd of the PipeWire remote
+	restoreToken chan string
+	closed       chan dbus.ObjectPath
+}
+
+// fakeSession is the Session object created by CreateSession.
+type fakeSession struct {
+	path   dbus.ObjectPath
+	closed chan dbus.ObjectPath
+}
+
+func (s *fakeSession) Close() *dbus.Error {
+	s.closed <- s.path
+	return nil
+}
+
+func (p *fakeScreenCastPortal) respond(sender dbus.Sender, options map[string]dbus.Variant, results map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	path, dbusErr := exportFakeRequest(p.conn, sender, options, make(chan dbus.ObjectPath, 1))
+	if dbusErr != nil {
+		return "", dbusErr
+	}
+	err := p.conn.Emit(path, portalRequestInterface+".Response", uint32(0), results)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return path, nil
+}
+
+func (p *fakeScreenCastPortal) CreateSession(sender dbus.Sender, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	token, _ := options["session_handle_token"].Value().(string)
+	session := dbus.ObjectPath("/org/freedesktop/portal/desktop/session/" + fakeSenderPath(sender) + "/" + token)
+	err := p.conn.Export(&fakeSession{path: session, closed: p.closed}, session, "org.freedesktop.portal.Session")
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return p.respond(sender, options, map[string]dbus.Variant{"session_handle": dbus.MakeVariant(string(session))})
+}
+
+func (p *fakeScreenCastPortal) SelectSources(sender dbus.Sender, session dbus.ObjectPath, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	token, _ := options["restore_token"].Value().(string)
+	p.restoreToken <- token
+	return p.respond(sender, options, map[string]dbus.Variant{})
+}
+
+func (p *fakeScreenCastPortal) Start(sender dbus.Sender, session dbus.ObjectPath, parent string, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	type stream struct {
+		NodeId     uint32
+		Properties map[string]dbus.Variant
+	}
+	streams := []stream{{fakeDriverId, map[string]dbus.Variant{
+		"position": dbus.MakeVariant(struct{ X, Y int32 }{100, 0}),
+		"size":     dbus.MakeVariant(struct{ W, H int32 }{4, 2}),
+	}}}
+	return p.respond(sender, options, map[string]dbus.Variant{
+		"streams":       dbus.MakeVariant(streams),
+		"restore_token": dbus.MakeVariant("new-token"),
+	})
+}
+
+func (p *fakeScreenCastPortal) OpenPipeWireRemote(session dbus.ObjectPath, options map[string]dbus.Variant) (dbus.UnixFD, *dbus.Error) {
+	return dbus.UnixFD(p.pipewire), nil
+}
+
+func TestScreenCast(t *testing.T) {
+	startSessionBus(t)
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fake, fd := newFakePipeWire(t)
+	portal := &fakeScreenCastPortal{
+		conn:         conn,
+		pipewire:     fd,
+		restoreToken: make(chan string, 1),
+		closed:       make(chan dbus.ObjectPath, 1),
+	}
+	if err := conn.Export(portal, portalObjectPath, screenCastInterface); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.RequestName(portalBusName, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sc, err := openScreenCast(ctx, "old-token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := <-portal.restoreToken; token != "old-token" {
+		t.Errorf("restore_token = %q, want old-token", token)
+	}
+	if sc.restoreToken != "new-token" {
+		t.Errorf("restoreToken = %q, want new-token", sc.restoreToken)
+	}
+
+	pixels := make([]byte, 32)
+	copy(pixels[4:], []byte{0, 0, 255, 0})
+	fake.frame(pixels)
+	dst := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	err = sc.captureInto(ctx, dst, image.Rect(99, 0, 103, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dst.RGBAAt(2, 0), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("RGBAAt(2, 0) = %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{}); got != want {
+		t.Errorf("RGBAAt(0, 0) = %v, want %v outside of the monitor", got, want)
+	}
+
+	sc.close()
+	select {
+	case <-portal.closed:
+	case <-time.After(time.Second):
+		t.Error("the session was not closed")
+	}
+	if err := sc.captureInto(ctx, dst, dst.Bounds()); !errors.Is(err, errPipeWireClosed) {
+		t.Errorf("captureInto() after close = %v", err)
+	}
+}
@@ -3,6 +3,8 @@
 package screenshot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/godbus/dbus/v5"
 	"image"
@@ -10,88 +12,273 @@ import (
 	"image/png"
 	"net/url"
 	"os"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
+const (
+	portalBusName          = "org.freedesktop.portal.Desktop"
+	portalObjectPath       = dbus.ObjectPath("/org/freedesktop/portal/desktop")
+	portalRequestInterface = "org.freedesktop.portal.Request"
+)
+
+// portalTimeout bounds a non-interactive screenshot whose context has no deadline.
+const portalTimeout = 30 * time.Second
+
+// portalProbeTimeout bounds the check for the portal service when the context has no deadline.
+const portalProbeTimeout = 5 * time.Second
+
 var gTokenCounter uint64 = 0
 
-func captureDbus(x, y, width, height int) (img *image.RGBA, e error) {
+// captureDbus captures rect through the Screenshot portal. origin is the position of the top-left
+// corner of the screenshot in the coordinates of rect.
+func captureDbus(ctx context.Context, dst *image.RGBA, rect image.Rectangle, origin image.Point) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, portalTimeout)
+		defer cancel()
+	}
+	img, err := portalScreenshot(ctx, PortalOptions{})
+	if err != nil {
+		return err
+	}
+	// Areas outside of the screenshot are left transparent.
+	draw.Draw(dst, dst.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min.Add(rect.Min.Sub(origin)), draw.Src)
+	return nil
+}
+
+func capturePortal(ctx context.Context, opts PortalOptions) (*image.RGBA, error) {
+	img, err := portalScreenshot(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	dst, err := createImage(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	if err != nil {
+		return nil, err
+	}
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	return dst, nil
+}
+
+// portalScreenshot takes a screenshot through the Screenshot portal, and returns it at the size
+// the portal made it.
+func portalScreenshot(ctx context.Context, opts PortalOptions) (img image.Image, e error) {
 	c, err := dbus.ConnectSessionBus()
 	if err != nil {
-		return nil, fmt.Errorf("dbus.SessionBus() failed: %v", err)
+		return nil, fmt.Errorf("%w: dbus.SessionBus() failed: %w", ErrNoDisplay, err)
 	}
 	defer func(c *dbus.Conn) {
 		err := c.Close()
-		if err != nil {
+		if err != nil && e == nil {
 			e = err
 		}
 	}(c)
-	token := atomic.AddUint64(&gTokenCounter, 1)
+
 	options := map[string]dbus.Variant{
-		"modal":        dbus.MakeVariant(false),
-		"interactive":  dbus.MakeVariant(false),
-		"handle_token": dbus.MakeVariant(token),
-	}
-	obj := c.Object("org.freedesktop.portal.Desktop", dbus.ObjectPath("/org/freedesktop/portal/desktop"))
-	call := obj.Call("org.freedesktop.portal.Screenshot.Screenshot", 0, "", options)
-	var path dbus.ObjectPath
-	err = call.Store(&path)
+		"modal":       dbus.MakeVariant(opts.Modal),
+		"interactive": dbus.MakeVariant(opts.Interactive),
+	}
+	results, err := callPortal(ctx, c, "org.freedesktop.portal.Screenshot.Screenshot", options, opts.ParentWindow)
 	if err != nil {
-		return nil, fmt.Errorf("dbus.Store() failed: %v", err)
-	}
-	ch := make(chan *dbus.Message)
-	c.Eavesdrop(ch)
-	for msg := range ch {
-		o, ok := msg.Headers[dbus.FieldPath]
-		if !ok {
-			continue
+		return nil, fmt.Errorf("org.freedesktop.portal.Screenshot: %w", err)
+	}
+
+	uri, ok := results["uri"]
+	if !ok {
+		return nil, fmt.Errorf("dbus.Message doesn't contain uri")
+	}
+	path, ok := uri.Value().(string)
+	if !ok {
+		return nil, fmt.Errorf("uri is not a string")
+	}
+	fpath, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse(%v) failed: %v", path, err)
+	}
+	if fpath.Scheme != "file" {
+		return nil, fmt.Errorf("uri is not a file path")
+	}
+	file, err := os.Open(fpath.Path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open(%s) failed: %v", path, err)
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+		_ = os.Remove(fpath.Path)
+	}(file)
+	img, err = png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("png.Decode(%s) failed: %v", path, err)
+	}
+	return img, nil
+}
+
+// callPortal calls a method of the desktop portal which answers through a Request object, and
+// waits for the Response signal of the request. options is passed as the last argument of the
+// method, after args, with a handle_token added to it.
+// The request is closed if ctx is done first.
+func callPortal(ctx context.Context, c *dbus.Conn, method string, options map[string]dbus.Variant, args ...interface{}) (map[string]dbus.Variant, error) {
+	token := fmt.Sprintf("screenshot%d", atomic.AddUint64(&gTokenCounter, 1))
+	handle, err := requestPath(c, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Subscribe before calling the method, since the portal may respond before the call returns.
+	signals := make(chan *dbus.Signal, 8)
+	c.Signal(signals)
+	defer c.RemoveSignal(signals)
+	unsubscribe, err := subscribeResponse(ctx, c, handle)
+	if err != nil {
+		return nil, err
+	}
+	defer unsubscribe()
+
+	withToken := make(map[string]dbus.Variant, len(options)+1)
+	for k, v := range options {
+		withToken[k] = v
+	}
+	withToken["handle_token"] = dbus.MakeVariant(token)
+
+	obj := c.Object(portalBusName, portalObjectPath)
+	var returned dbus.ObjectPath
+	err = obj.CallWithContext(ctx, method, 0, append(args, withToken)...).Store(&returned)
+	if err != nil {
+		if ctx.Err() != nil {
+			// The portal may have created the request all the same.
+			closeRequest(c, handle)
+			return nil, ctx.Err()
 		}
-		s, ok := o.Value().(dbus.ObjectPath)
-		if !ok {
-			return nil, fmt.Errorf("dbus.FieldPath value does't have ObjectPath type")
+		if isDbusServiceMissing(err) {
+			return nil, fmt.Errorf("%w: %w", ErrExtensionMissing, err)
 		}
-		if s != path {
-			continue
+		return nil, err
+	}
+	if returned != handle {
+		// Portals older than version 0.9 do not derive the request path from the token.
+		handle = returned
+		unsubscribeReturned, err := subscribeResponse(ctx, c, handle)
+		if err != nil {
+			return nil, err
 		}
-		for _, body := range msg.Body {
-			v, ok := body.(map[string]dbus.Variant)
-			if !ok {
-				continue
-			}
-			uri, ok := v["uri"]
-			if !ok {
+		defer unsubscribeReturned()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			closeRequest(c, handle)
+			return nil, ctx.Err()
+		case signal := <-signals:
+			if signal.Path != handle || signal.Name != portalRequestInterface+".Response" {
 				continue
 			}
-			path, ok := uri.Value().(string)
-			if !ok {
-				return nil, fmt.Errorf("uri is not a string")
-			}
-			fpath, err := url.Parse(path)
-			if err != nil {
-				return nil, fmt.Errorf("url.Parse(%v) failed: %v", path, err)
-			}
-			if fpath.Scheme != "file" {
-				return nil, fmt.Errorf("uri is not a file path")
-			}
-			file, err := os.Open(fpath.Path)
-			if err != nil {
-				return nil, fmt.Errorf("os.Open(%s) failed: %v", path, err)
-			}
-			defer func(file *os.File) {
-				_ = file.Close()
-				_ = os.Remove(fpath.Path)
-			}(file)
-			img, err := png.Decode(file)
-			if err != nil {
-				return nil, fmt.Errorf("png.Decode(%s) failed: %v", path, err)
-			}
-			canvas, err := createImage(image.Rect(0, 0, width, height))
-			if err != nil {
-				return nil, fmt.Errorf("createImage(%v) failed: %v", path, err)
-			}
-			draw.Draw(canvas, image.Rect(0, 0, width, height), img, image.Point{x, y}, draw.Src)
-			return canvas, e
+			return parseResponse(signal)
 		}
 	}
-	return nil, fmt.Errorf("dbus.Message doesn't contain uri")
+}
+
+// closeRequest lets the portal dismiss the dialog of the request at handle, if any. Nobody waits for the reply.
+func closeRequest(c *dbus.Conn, handle dbus.ObjectPath) {
+	c.Object(portalBusName, handle).Go(portalRequestInterface+".Close", dbus.FlagNoReplyExpected, nil)
+}
+
+// requestPath returns the object path of the Request the portal creates for token, as described in
+// the documentation of org.freedesktop.portal.Request.
+func requestPath(c *dbus.Conn, token string) (dbus.ObjectPath, error) {
+	names := c.Names()
+	if len(names) == 0 {
+		return "", errors.New("dbus connection has no unique name")
+	}
+	sender := strings.ReplaceAll(strings.TrimPrefix(names[0], ":"), ".", "_")
+	return dbus.ObjectPath("/org/freedesktop/portal/desktop/request/" + sender + "/" + token), nil
+}
+
+// subscribeResponse asks the bus to route the Response signal of the request at handle to c.
+// It returns a function which removes the subscription, even once ctx is done.
+func subscribeResponse(ctx context.Context, c *dbus.Conn, handle dbus.ObjectPath) (func(), error) {
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(handle),
+		dbus.WithMatchInterface(portalRequestInterface),
+		dbus.WithMatchMember("Response"),
+	}
+	err := c.AddMatchSignalContext(ctx, match...)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), portalProbeTimeout)
+		defer cancel()
+		_ = c.RemoveMatchSignalContext(ctx, match...)
+	}, nil
+}
+
+// parseResponse returns the results carried by a Response signal, or the reason why there are none.
+func parseResponse(signal *dbus.Signal) (map[string]dbus.Variant, error) {
+	var response uint32
+	var results map[string]dbus.Variant
+	err := dbus.Store(signal.Body, &response, &results)
+	if err != nil {
+		return nil, fmt.Errorf("malformed Response signal: %w", err)
+	}
+	switch response {
+	case 0:
+		return results, nil
+	case 1:
+		return nil, ErrCanceled
+	default:
+		return nil, fmt.Errorf("%w: response %d", ErrPortalFailed, response)
+	}
+}
+
+// probePortal checks that the session bus is reachable and that the portal service is running, or
+// can be started by the bus.
+func probePortal(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, portalProbeTimeout)
+		defer cancel()
+	}
+	c, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("%w: dbus.SessionBus() failed: %w", ErrNoDisplay, err)
+	}
+	defer c.Close()
+
+	bus := c.BusObject()
+	var running bool
+	err = bus.CallWithContext(ctx, "org.freedesktop.DBus.NameHasOwner", 0, portalBusName).Store(&running)
+	if err != nil || running {
+		return err
+	}
+	var activatable []string
+	err = bus.CallWithContext(ctx, "org.freedesktop.DBus.ListActivatableNames", 0).Store(&activatable)
+	if err != nil {
+		return err
+	}
+	for _, name := range activatable {
+		if name == portalBusName {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not available on the session bus", ErrExtensionMissing, portalBusName)
+}
+
+// isDbusServiceMissing reports whether err tells that the called service, object or method does not exist.
+func isDbusServiceMissing(err error) bool {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return false
+	}
+	switch dbusErr.Name {
+	case "org.freedesktop.DBus.Error.ServiceUnknown",
+		"org.freedesktop.DBus.Error.UnknownObject",
+		"org.freedesktop.DBus.Error.UnknownInterface",
+		"org.freedesktop.DBus.Error.UnknownMethod":
+		return true
+	}
+	return false
 }
@@ -0,0 +1,291 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/godbus/dbus/v5"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=DIR</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*"/>
+    <allow receive_sender="*"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startSessionBus starts a private dbus-daemon and makes it the session bus for the rest of the test.
+func startSessionBus(t *testing.T) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(config, []byte(strings.Replace(busConfig, "DIR", dir, 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+}
+
+// fakeScreenshotPortal stands in for the Screenshot portal of xdg-desktop-portal.
+type fakeScreenshotPortal struct {
+	conn *dbus.Conn
+	// response is the code sent in the Response signal, which is not sent at all if respond is false.
+	response uint32
+	respond  bool
+	uri      string
+	closed   chan dbus.ObjectPath
+	calls    chan fakeScreenshotCall
+}
+
+// fakeScreenshotCall records the arguments of a call to the Screenshot method.
+type fakeScreenshotCall struct {
+	parent  string
+	options map[string]dbus.Variant
+}
+
+// fakeRequest is the Request object created for each call.
+type fakeRequest struct {
+	path   dbus.ObjectPath
+	closed chan dbus.ObjectPath
+}
+
+func (r *fakeRequest) Close() *dbus.Error {
+	r.closed <- r.path
+	return nil
+}
+
+func (p *fakeScreenshotPortal) Screenshot(sender dbus.Sender, parent string, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	select {
+	case p.calls <- fakeScreenshotCall{parent: parent, options: options}:
+	default:
+	}
+	path, dbusErr := exportFakeRequest(p.conn, sender, options, p.closed)
+	if dbusErr != nil {
+		return "", dbusErr
+	}
+	if p.respond {
+		// Respond before the call returns, which the caller must be prepared for.
+		results := map[string]dbus.Variant{"uri": dbus.MakeVariant(p.uri)}
+		err := p.conn.Emit(path, portalRequestInterface+".Response", p.response, results)
+		if err != nil {
+			return "", dbus.MakeFailedError(err)
+		}
+	}
+	return path, nil
+}
+
+// exportFakeRequest exports the Request object for a call made by sender with options.
+func exportFakeRequest(conn *dbus.Conn, sender dbus.Sender, options map[string]dbus.Variant, closed chan dbus.ObjectPath) (dbus.ObjectPath, *dbus.Error) {
+	token, ok := options["handle_token"].Value().(string)
+	if !ok {
+		return "", dbus.MakeFailedError(errors.New("handle_token must be a string"))
+	}
+	path := dbus.ObjectPath("/org/freedesktop/portal/desktop/request/" + fakeSenderPath(sender) + "/" + token)
+	err := conn.Export(&fakeRequest{path: path, closed: closed}, path, portalRequestInterface)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return path, nil
+}
+
+// fakeSenderPath returns the element of the object paths of the portal identifying sender.
+func fakeSenderPath(sender dbus.Sender) string {
+	return strings.ReplaceAll(strings.TrimPrefix(string(sender), ":"), ".", "_")
+}
+
+func startFakeScreenshotPortal(t *testing.T, portal *fakeScreenshotPortal) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	portal.conn = conn
+	portal.closed = make(chan dbus.ObjectPath, 1)
+	portal.calls = make(chan fakeScreenshotCall, 1)
+	err = conn.Export(portal, portalObjectPath, "org.freedesktop.portal.Screenshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName(portalBusName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName() = %v, %v", reply, err)
+	}
+}
+
+// writeScreenshot writes a 4x4 PNG file with a red pixel at (1, 1) and a green one at (2, 2).
+func writeScreenshot(t *testing.T) string {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	src.SetRGBA(1, 1, color.RGBA{255, 0, 0, 255})
+	src.SetRGBA(2, 2, color.RGBA{0, 255, 0, 255})
+	path := filepath.Join(t.TempDir(), "screenshot.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, src); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCaptureDbus(t *testing.T) {
+	startSessionBus(t)
+	path := writeScreenshot(t)
+	startFakeScreenshotPortal(t, &fakeScreenshotPortal{respond: true, uri: "file://" + path})
+
+	dst := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	err := captureDbus(context.Background(), dst, image.Rect(1, 1, 3, 3), image.Point{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("dst.RGBAAt(0, 0) = %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(1, 1), (color.RGBA{0, 255, 0, 255}); got != want {
+		t.Errorf("dst.RGBAAt(1, 1) = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the screenshot file was not removed: %v", err)
+	}
+}
+
+func TestCapturePortal(t *testing.T) {
+	startSessionBus(t)
+	path := writeScreenshot(t)
+	portal := &fakeScreenshotPortal{respond: true, uri: "file://" + path}
+	startFakeScreenshotPortal(t, portal)
+
+	img, err := CapturePortal(context.Background(), PortalOptions{Interactive: true, ParentWindow: "x11:1a2b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 4, 4); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+	if got, want := img.RGBAAt(1, 1), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("RGBAAt(1, 1) = %v, want %v", got, want)
+	}
+	call := <-portal.calls
+	if call.parent != "x11:1a2b" {
+		t.Errorf("parent_window = %q", call.parent)
+	}
+	if v, _ := call.options["interactive"].Value().(bool); !v {
+		t.Errorf("interactive = %v", call.options["interactive"])
+	}
+	if v, _ := call.options["modal"].Value().(bool); v {
+		t.Errorf("modal = %v", call.options["modal"])
+	}
+}
+
+func TestCaptureDbusResponse(t *testing.T) {
+	cases := []struct {
+		response uint32
+		want     error
+	}{
+		{1, ErrCanceled},
+		{2, ErrPortalFailed},
+	}
+	for _, c := range cases {
+		startSessionBus(t)
+		startFakeScreenshotPortal(t, &fakeScreenshotPortal{respond: true, response: c.response})
+		dst := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		err := captureDbus(context.Background(), dst, dst.Bounds(), image.Point{})
+		if !errors.Is(err, c.want) {
+			t.Errorf("response %d: captureDbus() = %v, want %v", c.response, err, c.want)
+		}
+	}
+}
+
+func TestCaptureDbusDeadline(t *testing.T) {
+	startSessionBus(t)
+	portal := &fakeScreenshotPortal{}
+	startFakeScreenshotPortal(t, portal)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	dst := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	err := captureDbus(ctx, dst, dst.Bounds(), image.Point{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("captureDbus() = %v, want context.DeadlineExceeded", err)
+	}
+	select {
+	case <-portal.closed:
+	case <-time.After(time.Second):
+		t.Error("the request was not closed")
+	}
+}
+
+func TestCaptureContextPortal(t *testing.T) {
+	startSessionBus(t)
+	portal := &fakeScreenshotPortal{}
+	startFakeScreenshotPortal(t, portal)
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("WAYLAND_DISPLAY", "")
+
+	c, err := NewCapturer(WithBackend("portal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-portal.calls
+		cancel()
+	}()
+	_, err = c.CaptureContext(ctx, image.Rect(0, 0, 2, 2))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CaptureContext() = %v, want context.Canceled", err)
+	}
+	select {
+	case <-portal.closed:
+	case <-time.After(time.Second):
+		t.Error("the request was not closed")
+	}
+}
+
+func TestCaptureDbusServiceMissing(t *testing.T) {
+	startSessionBus(t)
+	dst := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	err := captureDbus(context.Background(), dst, dst.Bounds(), image.Point{})
+	if !errors.Is(err, ErrExtensionMissing) {
+		t.Errorf("captureDbus() = %v, want ErrExtensionMissing", err)
+	}
+}
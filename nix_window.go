@@ -0,0 +1,580 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/composite"
+	"github.com/jezek/xgb/xproto"
+	"image"
+	"strings"
+)
+
+// ErrWindowNotFound is returned when the window does not exist or is not viewable.
+var ErrWindowNotFound = errors.New("screenshot: window not found")
+
+// WindowID identifies an X11 window, as reported by e.g. xwininfo or ListWindows.
+type WindowID uint32
+
+// WindowOption configures how a window is captured.
+type WindowOption func(*windowOptions)
+
+type windowOptions struct {
+	composite bool
+	frame     bool
+}
+
+// WithComposite makes the capture read the window contents from its off-screen pixmap through the
+// Composite extension, instead of from the screen. Under a compositing manager, occluded and partially
+// off-screen windows are then captured correctly, rather than showing whatever is on top of them.
+// Without one, the window is redirected for the capture only: its hidden parts are undefined until the
+// application repaints them, which it may do after the capture or not at all.
+func WithComposite() WindowOption {
+	return func(o *windowOptions) {
+		o.composite = true
+	}
+}
+
+// WindowInfo describes a top-level window managed by the window manager.
+type WindowInfo struct {
+	ID WindowID
+	// Title is _NET_WM_NAME, or WM_NAME if the former is not set.
+	Title string
+	// Instance and Class are the two parts of WM_CLASS.
+	Instance string
+	Class    string
+	// PID is _NET_WM_PID, or 0 if the client did not set it.
+	PID int
+	// Bounds is the region of the window, excluding its border and the decorations of the window manager,
+	// in the coordinate system used by Capture.
+	Bounds image.Rectangle
+	// Stacking is the position of the window in the stacking order, 0 being the bottom-most window.
+	Stacking int
+	// Visible reports whether the window is viewable and not minimized. A visible window may still be
+	// occluded by other windows.
+	Visible bool
+}
+
+// ListWindows returns the top-level windows in stacking order, from bottom to top.
+// It relies on the window manager maintaining _NET_CLIENT_LIST_STACKING or _NET_CLIENT_LIST,
+// and falls back to the children of the root window otherwise.
+func ListWindows() ([]WindowInfo, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	return s.listWindows()
+}
+
+// ListWindows returns the top-level windows in stacking order, from bottom to top.
+func (c *Capturer) ListWindows() (windows []WindowInfo, err error) {
+	err = c.withSession(func(s *session) error {
+		windows, err = s.listWindows()
+		return err
+	})
+	return windows, err
+}
+
+func (s *session) listWindows() (windows []WindowInfo, e error) {
+	e = s.withXWindow(context.Background(), func(xs *xSession) error {
+		var err error
+		windows, err = xs.listWindows()
+		return err
+	})
+	return windows, e
+}
+
+// WithFrame includes the decorations drawn by the window manager around the window, as described by
+// _NET_FRAME_EXTENTS. Combined with WithComposite, the frame window created by a reparenting window
+// manager is captured instead.
+func WithFrame() WindowOption {
+	return func(o *windowOptions) {
+		o.frame = true
+	}
+}
+
+// CaptureActiveWindow captures the window which has the focus, as reported by the window manager
+// through _NET_ACTIVE_WINDOW. ErrWindowNotFound is returned if no window is active.
+func CaptureActiveWindow(opts ...WindowOption) (*image.RGBA, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	return s.captureActiveWindow(opts)
+}
+
+// CaptureActiveWindow captures the window which has the focus.
+func (c *Capturer) CaptureActiveWindow(opts ...WindowOption) (img *image.RGBA, err error) {
+	err = c.withSession(func(s *session) error {
+		img, err = s.captureActiveWindow(opts)
+		return err
+	})
+	return img, err
+}
+
+func (s *session) captureActiveWindow(opts []WindowOption) (img *image.RGBA, e error) {
+	var o windowOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	e = s.withXWindow(context.Background(), func(xs *xSession) error {
+		window, err := xs.activeWindow()
+		if err != nil {
+			return err
+		}
+		img, err = xs.captureWindow(window, o)
+		return err
+	})
+	return img, e
+}
+
+// CaptureWindow captures the contents of the X11 window, excluding its border.
+// The size of the returned image is the size of the window.
+func CaptureWindow(id WindowID, opts ...WindowOption) (*image.RGBA, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	return s.captureWindow(id, opts)
+}
+
+// CaptureWindow captures the contents of the X11 window, excluding its border.
+func (c *Capturer) CaptureWindow(id WindowID, opts ...WindowOption) (img *image.RGBA, err error) {
+	err = c.withSession(func(s *session) error {
+		img, err = s.captureWindow(id, opts)
+		return err
+	})
+	return img, err
+}
+
+func (s *session) captureWindow(id WindowID, opts []WindowOption) (img *image.RGBA, e error) {
+	var o windowOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	e = s.withXWindow(context.Background(), func(xs *xSession) error {
+		var err error
+		img, err = xs.captureWindow(xproto.Window(id), o)
+		return err
+	})
+	return img, e
+}
+
+// windowGeometry returns the size of the window excluding its border, along with the border width.
+func (s *xSession) windowGeometry(window xproto.Window) (width, height, border int, err error) {
+	attrCookie := xproto.GetWindowAttributes(s.conn, window)
+	geomCookie := xproto.GetGeometry(s.conn, xproto.Drawable(window))
+	attr, err := attrCookie.Reply()
+	if err != nil {
+		return 0, 0, 0, windowError(window, err)
+	}
+	geom, err := geomCookie.Reply()
+	if err != nil {
+		return 0, 0, 0, windowError(window, err)
+	}
+	if attr.MapState != xproto.MapStateViewable {
+		return 0, 0, 0, fmt.Errorf("%w: 0x%x is not viewable", ErrWindowNotFound, uint32(window))
+	}
+	return int(geom.Width), int(geom.Height), int(geom.BorderWidth), nil
+}
+
+// windowRootRect returns the region of the window, excluding its border, in root window coordinates.
+func (s *xSession) windowRootRect(window xproto.Window) (image.Rectangle, error) {
+	width, height, _, err := s.windowGeometry(window)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	trans, err := xproto.TranslateCoordinates(s.conn, window, s.screen.Root, 0, 0).Reply()
+	if err != nil {
+		return image.Rectangle{}, windowError(window, err)
+	}
+	x, y := int(trans.DstX), int(trans.DstY)
+	return image.Rect(x, y, x+width, y+height), nil
+}
+
+func (s *xSession) captureWindow(window xproto.Window, o windowOptions) (*image.RGBA, error) {
+	if o.composite {
+		if o.frame {
+			frame, err := s.frameWindow(window)
+			if err != nil {
+				return nil, err
+			}
+			window = frame
+		}
+		return s.captureWindowComposite(window)
+	}
+
+	rect, err := s.windowRootRect(window)
+	if err != nil {
+		return nil, err
+	}
+	if o.frame {
+		extents, err := s.frameExtents(window)
+		if err != nil {
+			return nil, err
+		}
+		rect = addFrameExtents(rect, extents)
+	}
+	img, err := createImage(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	if err != nil {
+		return nil, err
+	}
+	format, err := s.rootFormat()
+	if err != nil {
+		return nil, err
+	}
+	err = s.readDrawable(img, false, xproto.Drawable(s.screen.Root), format, s.rootBounds(), rect)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// captureWindowComposite reads the window from the pixmap backing it, which the Composite extension
+// keeps up to date even where the window is hidden. The window is redirected for the duration of the
+// call; a window already redirected by a compositing manager is left as it is. A pixmap allocated by
+// this redirection only holds the parts of the window visible at that moment, see WithComposite.
+func (s *xSession) captureWindowComposite(window xproto.Window) (*image.RGBA, error) {
+	err := s.initComposite()
+	if err != nil {
+		return nil, err
+	}
+
+	width, height, border, err := s.windowGeometry(window)
+	if err != nil {
+		return nil, err
+	}
+
+	// The pixmap has the visual of the window, which may differ from the one of the root window, e.g.
+	// the 32-bit ARGB visual of translucent windows.
+	attr, err := xproto.GetWindowAttributes(s.conn, window).Reply()
+	if err != nil {
+		return nil, windowError(window, err)
+	}
+	colormap := attr.Colormap
+	if colormap == xproto.ColormapNone {
+		colormap = s.screen.DefaultColormap
+	}
+	format, err := s.pixelFormat(attr.Visual, colormap)
+	if err != nil {
+		return nil, err
+	}
+
+	err = composite.RedirectWindowChecked(s.conn, window, composite.RedirectAutomatic).Check()
+	if err != nil {
+		return nil, err
+	}
+	defer composite.UnredirectWindow(s.conn, window, composite.RedirectAutomatic)
+
+	pixmap, err := xproto.NewPixmapId(s.conn)
+	if err != nil {
+		return nil, err
+	}
+	err = composite.NameWindowPixmapChecked(s.conn, window, pixmap).Check()
+	if err != nil {
+		return nil, err
+	}
+	defer xproto.FreePixmap(s.conn, pixmap)
+
+	img, err := createImage(image.Rect(0, 0, width, height))
+	if err != nil {
+		return nil, err
+	}
+	// The pixmap covers the border as well.
+	pixmapBounds := image.Rect(0, 0, width+2*border, height+2*border)
+	err = s.readDrawable(img, false, xproto.Drawable(pixmap), format, pixmapBounds, image.Rect(border, border, border+width, border+height))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// initComposite initializes the Composite extension on first use.
+// NameWindowPixmap requires version 0.2.
+func (s *xSession) initComposite() error {
+	if s.hasComposite {
+		return nil
+	}
+	err := composite.Init(s.conn)
+	if err != nil {
+		return fmt.Errorf("%w: Composite: %w", ErrExtensionMissing, err)
+	}
+	reply, err := composite.QueryVersion(s.conn, 0, 4).Reply()
+	if err != nil {
+		return err
+	}
+	if reply.MajorVersion == 0 && reply.MinorVersion < 2 {
+		return fmt.Errorf("%w: Composite 0.2 or later is required, got %d.%d", ErrExtensionMissing, reply.MajorVersion, reply.MinorVersion)
+	}
+	s.hasComposite = true
+	return nil
+}
+
+// internAtoms returns the atoms for names, interning those not seen before in a single round trip.
+func (s *xSession) internAtoms(names ...string) ([]xproto.Atom, error) {
+	cookies := make([]xproto.InternAtomCookie, len(names))
+	for i, name := range names {
+		if _, ok := s.atoms[name]; !ok {
+			cookies[i] = xproto.InternAtom(s.conn, false, uint16(len(name)), name)
+		}
+	}
+	ret := make([]xproto.Atom, len(names))
+	for i, name := range names {
+		if cookies[i].Cookie == nil {
+			ret[i] = s.atoms[name]
+			continue
+		}
+		reply, err := cookies[i].Reply()
+		if err != nil {
+			return nil, err
+		}
+		if s.atoms == nil {
+			s.atoms = map[string]xproto.Atom{}
+		}
+		s.atoms[name] = reply.Atom
+		ret[i] = reply.Atom
+	}
+	return ret, nil
+}
+
+// windowProperty returns the value of the property of the window, or nil if it is not set.
+func (s *xSession) windowProperty(window xproto.Window, property xproto.Atom) ([]byte, error) {
+	reply, err := xproto.GetProperty(s.conn, false, window, property, xproto.GetPropertyTypeAny, 0, 1<<16).Reply()
+	if err != nil {
+		return nil, err
+	}
+	return reply.Value, nil
+}
+
+// windowList returns the client windows listed by the window manager from bottom to top,
+// or the children of the root window if the window manager does not follow EWMH.
+func (s *xSession) windowList() ([]xproto.Window, error) {
+	atoms, err := s.internAtoms("_NET_CLIENT_LIST_STACKING", "_NET_CLIENT_LIST")
+	if err != nil {
+		return nil, err
+	}
+	for _, atom := range atoms {
+		value, err := s.windowProperty(s.screen.Root, atom)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) == 0 {
+			continue
+		}
+		windows := make([]xproto.Window, len(value)/4)
+		for i := range windows {
+			windows[i] = xproto.Window(xgb.Get32(value[i*4:]))
+		}
+		return windows, nil
+	}
+
+	tree, err := xproto.QueryTree(s.conn, s.screen.Root).Reply()
+	if err != nil {
+		return nil, err
+	}
+	return tree.Children, nil
+}
+
+// activeWindow returns the window designated by _NET_ACTIVE_WINDOW.
+func (s *xSession) activeWindow() (xproto.Window, error) {
+	atoms, err := s.internAtoms("_NET_ACTIVE_WINDOW")
+	if err != nil {
+		return 0, err
+	}
+	value, err := s.windowProperty(s.screen.Root, atoms[0])
+	if err != nil {
+		return 0, err
+	}
+	if len(value) < 4 || xgb.Get32(value) == 0 {
+		return 0, fmt.Errorf("%w: no active window", ErrWindowNotFound)
+	}
+	return xproto.Window(xgb.Get32(value)), nil
+}
+
+// frameExtents returns the width of the decorations from _NET_FRAME_EXTENTS, with Min holding the
+// left and top extents and Max the right and bottom ones. It returns zero extents if the window
+// manager does not set the property.
+func (s *xSession) frameExtents(window xproto.Window) (image.Rectangle, error) {
+	atoms, err := s.internAtoms("_NET_FRAME_EXTENTS")
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	value, err := s.windowProperty(window, atoms[0])
+	if err != nil {
+		return image.Rectangle{}, windowError(window, err)
+	}
+	return decodeFrameExtents(value), nil
+}
+
+// decodeFrameExtents decodes the left, right, top and bottom extents of _NET_FRAME_EXTENTS, like
+// frameExtents. A missing or truncated value means no decorations.
+func decodeFrameExtents(value []byte) image.Rectangle {
+	if len(value) < 16 {
+		return image.Rectangle{}
+	}
+	left, right := int(xgb.Get32(value)), int(xgb.Get32(value[4:]))
+	top, bottom := int(xgb.Get32(value[8:])), int(xgb.Get32(value[12:]))
+	return image.Rectangle{Min: image.Pt(left, top), Max: image.Pt(right, bottom)}
+}
+
+// addFrameExtents returns the region of a window along with its decorations, whose extents are encoded
+// like decodeFrameExtents returns them.
+func addFrameExtents(rect, extents image.Rectangle) image.Rectangle {
+	rect.Min = rect.Min.Sub(extents.Min)
+	rect.Max = rect.Max.Add(extents.Max)
+	return rect
+}
+
+// frameWindow returns the ancestor of the window which is a child of the root window. A reparenting
+// window manager draws the decorations in it; otherwise it is the window itself.
+func (s *xSession) frameWindow(window xproto.Window) (xproto.Window, error) {
+	for {
+		tree, err := xproto.QueryTree(s.conn, window).Reply()
+		if err != nil {
+			return 0, windowError(window, err)
+		}
+		if tree.Parent == tree.Root || tree.Parent == 0 {
+			return window, nil
+		}
+		window = tree.Parent
+	}
+}
+
+// windowInfoCookies holds the pending requests describing a single window.
+type windowInfoCookies struct {
+	attr     xproto.GetWindowAttributesCookie
+	geom     xproto.GetGeometryCookie
+	trans    xproto.TranslateCoordinatesCookie
+	netName  xproto.GetPropertyCookie
+	name     xproto.GetPropertyCookie
+	class    xproto.GetPropertyCookie
+	pid      xproto.GetPropertyCookie
+	netState xproto.GetPropertyCookie
+}
+
+func (s *xSession) listWindows() ([]WindowInfo, error) {
+	windows, err := s.windowList()
+	if err != nil {
+		return nil, err
+	}
+	atoms, err := s.internAtoms("_NET_WM_NAME", "_NET_WM_PID", "_NET_WM_STATE", "_NET_WM_STATE_HIDDEN")
+	if err != nil {
+		return nil, err
+	}
+	netWmName, netWmPid, netWmState, netWmStateHidden := atoms[0], atoms[1], atoms[2], atoms[3]
+	origin, err := s.origin()
+	if err != nil {
+		return nil, err
+	}
+
+	// Send every request up front, so that listing many windows costs a single round trip.
+	property := func(window xproto.Window, atom xproto.Atom) xproto.GetPropertyCookie {
+		return xproto.GetProperty(s.conn, false, window, atom, xproto.GetPropertyTypeAny, 0, 1<<16)
+	}
+	cookies := make([]windowInfoCookies, len(windows))
+	for i, w := range windows {
+		cookies[i] = windowInfoCookies{
+			attr:     xproto.GetWindowAttributes(s.conn, w),
+			geom:     xproto.GetGeometry(s.conn, xproto.Drawable(w)),
+			trans:    xproto.TranslateCoordinates(s.conn, w, s.screen.Root, 0, 0),
+			netName:  property(w, netWmName),
+			name:     property(w, xproto.AtomWmName),
+			class:    property(w, xproto.AtomWmClass),
+			pid:      property(w, netWmPid),
+			netState: property(w, netWmState),
+		}
+	}
+
+	ret := make([]WindowInfo, 0, len(windows))
+	for i, w := range windows {
+		c := cookies[i]
+		attr, errAttr := c.attr.Reply()
+		geom, errGeom := c.geom.Reply()
+		trans, errTrans := c.trans.Reply()
+		netName, errNetName := c.netName.Reply()
+		name, errName := c.name.Reply()
+		class, errClass := c.class.Reply()
+		pid, errPid := c.pid.Reply()
+		netState, errNetState := c.netState.Reply()
+		if err := firstError(errAttr, errGeom, errTrans, errNetName, errName, errClass, errPid, errNetState); err != nil {
+			if windowError(w, err) != err {
+				// The window was destroyed while we were listing.
+				continue
+			}
+			return nil, err
+		}
+
+		info := WindowInfo{
+			ID:       WindowID(w),
+			Stacking: len(ret),
+			Visible:  attr.MapState == xproto.MapStateViewable,
+		}
+		info.Bounds = windowBounds(geom, trans, origin)
+		decodeWindowProperties(&info, netName, name, class, pid, netState, netWmStateHidden)
+		ret = append(ret, info)
+	}
+	return ret, nil
+}
+
+// windowBounds returns the region of the window described by geom, excluding its border, whose inside
+// top left corner is at trans in the root window, in the coordinate system whose origin is at origin.
+// The position in geom is that of the outside of the border, relative to the parent.
+func windowBounds(geom *xproto.GetGeometryReply, trans *xproto.TranslateCoordinatesReply, origin image.Point) image.Rectangle {
+	x, y := int(trans.DstX), int(trans.DstY)
+	return image.Rect(x, y, x+int(geom.Width), y+int(geom.Height)).Sub(origin)
+}
+
+// decodeWindowProperties fills info from the properties of the window: the title from _NET_WM_NAME,
+// which is in UTF-8, or else from WM_NAME, which is in Latin-1 when its type is STRING; the instance and
+// class from WM_CLASS; the PID; and whether _NET_WM_STATE holds hidden.
+func decodeWindowProperties(info *WindowInfo, netName, name, class, pid, netState *xproto.GetPropertyReply, hidden xproto.Atom) {
+	switch {
+	case len(netName.Value) > 0:
+		info.Title = strings.ToValidUTF8(string(netName.Value), "\uFFFD")
+	case name.Type == xproto.AtomString:
+		runes := make([]rune, len(name.Value))
+		for i, b := range name.Value {
+			runes[i] = rune(b)
+		}
+		info.Title = string(runes)
+	default:
+		info.Title = strings.ToValidUTF8(string(name.Value), "\uFFFD")
+	}
+	if parts := bytes.Split(class.Value, []byte{0}); len(parts) >= 2 {
+		info.Instance = string(parts[0])
+		info.Class = string(parts[1])
+	}
+	if pid.Format == 32 && len(pid.Value) >= 4 {
+		info.PID = int(xgb.Get32(pid.Value))
+	}
+	for j := 0; netState.Format == 32 && j+4 <= len(netState.Value); j += 4 {
+		if xproto.Atom(xgb.Get32(netState.Value[j:])) == hidden {
+			info.Visible = false
+		}
+	}
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// windowError wraps a BadWindow or BadDrawable error into ErrWindowNotFound.
+func windowError(window xproto.Window, err error) error {
+	switch err.(type) {
+	case xproto.WindowError, xproto.DrawableError:
+		return fmt.Errorf("%w: 0x%x: %w", ErrWindowNotFound, uint32(window), err)
+	}
+	return err
+}
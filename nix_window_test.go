@@ -0,0 +1,128 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/jezek/xgb/xproto"
+	"image"
+	"testing"
+)
+
+// cardinals encodes a property of format 32, in the byte order of the X connection.
+func cardinals(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func TestWindowError(t *testing.T) {
+	for _, err := range []error{xproto.WindowError{BadValue: 0x42}, xproto.DrawableError{BadValue: 0x42}} {
+		got := windowError(0x42, err)
+		if !errors.Is(got, ErrWindowNotFound) {
+			t.Errorf("windowError(%T) = %v, want ErrWindowNotFound", err, got)
+		}
+		if !errors.Is(got, err) {
+			t.Errorf("windowError(%T) = %v, which does not wrap the X error", err, got)
+		}
+	}
+	other := xproto.MatchError{}
+	if got := windowError(0x42, other); got != other {
+		t.Errorf("windowError(MatchError) = %v, want it unchanged", got)
+	}
+}
+
+func TestWindowBounds(t *testing.T) {
+	// A window with a border of 3 pixels, inside a parent window at (100, 100).
+	geom := &xproto.GetGeometryReply{X: 20, Y: 10, Width: 300, Height: 200, BorderWidth: 3}
+	trans := &xproto.TranslateCoordinatesReply{DstX: 123, DstY: 113}
+	for _, tt := range []struct {
+		origin image.Point
+		want   image.Rectangle
+	}{
+		{image.Point{}, image.Rect(123, 113, 423, 313)},
+		{image.Pt(1920, 0), image.Rect(-1797, 113, -1497, 313)},
+		{image.Pt(-1280, -200), image.Rect(1403, 313, 1703, 513)},
+	} {
+		if got := windowBounds(geom, trans, tt.origin); got != tt.want {
+			t.Errorf("windowBounds(origin %v) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeFrameExtents(t *testing.T) {
+	// _NET_FRAME_EXTENTS is left, right, top, bottom.
+	want := image.Rectangle{Min: image.Pt(1, 24), Max: image.Pt(2, 3)}
+	if got := decodeFrameExtents(cardinals(1, 2, 24, 3)); got != want {
+		t.Errorf("decodeFrameExtents() = %v, want %v", got, want)
+	}
+	for _, value := range [][]byte{nil, cardinals(1, 2, 24)} {
+		if got := decodeFrameExtents(value); got != (image.Rectangle{}) {
+			t.Errorf("decodeFrameExtents(%v) = %v, want no decorations", value, got)
+		}
+	}
+}
+
+func TestAddFrameExtents(t *testing.T) {
+	rect := image.Rect(100, 50, 400, 250)
+	for _, tt := range []struct {
+		extents image.Rectangle
+		want    image.Rectangle
+	}{
+		{image.Rectangle{}, rect},
+		{decodeFrameExtents(cardinals(1, 2, 24, 3)), image.Rect(99, 26, 402, 253)},
+		// A title bar at the top only.
+		{decodeFrameExtents(cardinals(0, 0, 30, 0)), image.Rect(100, 20, 400, 250)},
+	} {
+		if got := addFrameExtents(rect, tt.extents); got != tt.want {
+			t.Errorf("addFrameExtents(%v) = %v, want %v", tt.extents, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeWindowProperties(t *testing.T) {
+	const hidden = xproto.Atom(300)
+	utf8String := xproto.Atom(301)
+	tests := []struct {
+		name                          string
+		netName, wmName, wmClass, pid xproto.GetPropertyReply
+		netState                      xproto.GetPropertyReply
+		title, instance, class        string
+		wantPID                       int
+		visible                       bool
+	}{
+		{
+			name:     "EWMH",
+			netName:  xproto.GetPropertyReply{Format: 8, Type: utf8String, Value: []byte("Caf\xc3\xa9 \xe2\x80\x94 editor")},
+			wmName:   xproto.GetPropertyReply{Format: 8, Type: xproto.AtomString, Value: []byte("Cafe")},
+			wmClass:  xproto.GetPropertyReply{Format: 8, Value: []byte("gedit\x00Gedit\x00")},
+			pid:      xproto.GetPropertyReply{Format: 32, Value: cardinals(4242)},
+			netState: xproto.GetPropertyReply{Format: 32, Value: cardinals(302)},
+			title:    "Café — editor", instance: "gedit", class: "Gedit", wantPID: 4242, visible: true,
+		},
+		{
+			name:     "Latin-1 WM_NAME of a minimized window",
+			wmName:   xproto.GetPropertyReply{Format: 8, Type: xproto.AtomString, Value: []byte("Caf\xe9")},
+			netState: xproto.GetPropertyReply{Format: 32, Value: cardinals(302, uint32(hidden))},
+			title:    "Café",
+		},
+		{
+			name:    "invalid UTF-8 and truncated properties",
+			netName: xproto.GetPropertyReply{Format: 8, Type: utf8String, Value: []byte("ab\xff")},
+			wmClass: xproto.GetPropertyReply{Format: 8, Value: []byte("xterm")},
+			pid:     xproto.GetPropertyReply{Format: 32, Value: []byte{1, 2}},
+			title:   "ab�", visible: true,
+		},
+	}
+	for _, tt := range tests {
+		info := WindowInfo{Visible: true}
+		decodeWindowProperties(&info, &tt.netName, &tt.wmName, &tt.wmClass, &tt.pid, &tt.netState, hidden)
+		want := WindowInfo{Title: tt.title, Instance: tt.instance, Class: tt.class, PID: tt.wantPID, Visible: tt.visible}
+		if info != want {
+			t.Errorf("%s: decodeWindowProperties() = %+v, want %+v", tt.name, info, want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Object ids and opcodes of the core Wayland protocol, from wayland.xml.
+const (
+	wlDisplayId = 1
+
+	wlDisplayMethodSync        = 0
+	wlDisplayMethodGetRegistry = 1
+	wlDisplayEventError        = 0
+	wlDisplayEventDeleteId     = 1
+
+	wlRegistryMethodBind        = 0
+	wlRegistryEventGlobal       = 0
+	wlRegistryEventGlobalRemove = 1
+
+	wlCallbackEventDone = 0
+
+	// The largest number of file descriptors libwayland sends along with a message.
+	wlMaxFds = 28
+)
+
+var errMalformedWayland = errors.New("wayland: malformed message")
+
+// wlHandler handles an event sent to an object. r holds the arguments of the event.
+type wlHandler func(opcode uint16, r *wlReader) error
+
+// wlConn is a connection to a Wayland compositor. Unlike libwayland, it has no event queue: the events
+// are read and handed to the handlers of their objects by whoever waits for them, which is enough for
+// the request and reply exchanges of the capture protocols.
+type wlConn struct {
+	conn     *net.UnixConn
+	handlers map[uint32]wlHandler
+	nextId   uint32
+	free     []uint32 // ids released by the compositor, which are reused first
+	pending  []byte
+	err      error
+}
+
+// dialWayland connects to the compositor named by $WAYLAND_DISPLAY, which is either an absolute path
+// or a socket in $XDG_RUNTIME_DIR.
+func dialWayland() (*wlConn, error) {
+	name := os.Getenv("WAYLAND_DISPLAY")
+	if name == "" {
+		name = "wayland-0"
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("%w: XDG_RUNTIME_DIR is not set", ErrNoDisplay)
+		}
+		path = filepath.Join(dir, name)
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoDisplay, err)
+	}
+	return newWlConn(conn), nil
+}
+
+func newWlConn(conn *net.UnixConn) *wlConn {
+	c := &wlConn{
+		conn:     conn,
+		handlers: map[uint32]wlHandler{},
+		nextId:   wlDisplayId + 1,
+	}
+	c.handlers[wlDisplayId] = c.displayEvent
+	return c
+}
+
+func (c *wlConn) close() {
+	_ = c.conn.Close()
+}
+
+// newId allocates an object id for a new_id argument, whose events go to handler. handler may be nil
+// for objects without events.
+func (c *wlConn) newId(handler wlHandler) uint32 {
+	var id uint32
+	if n := len(c.free); n > 0 {
+		id = c.free[n-1]
+		c.free = c.free[:n-1]
+	} else {
+		id = c.nextId
+		c.nextId++
+	}
+	c.handlers[id] = handler
+	return id
+}
+
+func (c *wlConn) send(id uint32, opcode uint16, build func(w *wlWriter)) error {
+	if c.err != nil {
+		return c.err
+	}
+	w := wlWriter{buf: make([]byte, 8, 64)}
+	if build != nil {
+		build(&w)
+	}
+	binary.NativeEndian.PutUint32(w.buf, id)
+	binary.NativeEndian.PutUint32(w.buf[4:], uint32(len(w.buf))<<16|uint32(opcode))
+	var oob []byte
+	if len(w.fds) > 0 {
+		oob = syscall.UnixRights(w.fds...)
+	}
+	_, _, err := c.conn.WriteMsgUnix(w.buf, oob, nil)
+	if err != nil {
+		c.err = fmt.Errorf("wayland: %w", err)
+	}
+	return c.err
+}
+
+// roundtrip waits until the compositor has handled every request sent before, and sent their events.
+func (c *wlConn) roundtrip(ctx context.Context) error {
+	done := false
+	callback := c.newId(func(opcode uint16, r *wlReader) error {
+		done = opcode == wlCallbackEventDone
+		return nil
+	})
+	err := c.send(wlDisplayId, wlDisplayMethodSync, func(w *wlWriter) {
+		w.uint(callback)
+	})
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx, func() bool { return done })
+}
+
+// wait dispatches events until cond holds. Once ctx is done, the connection is unusable.
+func (c *wlConn) wait(ctx context.Context, cond func() bool) error {
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.conn.SetReadDeadline(time.Unix(1, 0))
+	})
+	defer func() {
+		if stop() {
+			_ = c.conn.SetReadDeadline(time.Time{})
+		}
+	}()
+
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(wlMaxFds*4))
+	for !cond() {
+		if c.err != nil {
+			return c.err
+		}
+		n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+		if oobn > 0 {
+			// None of the events handled here carries a file descriptor.
+			closeFds(parseRights(oob[:oobn]))
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				c.err = ctx.Err()
+			} else {
+				c.err = fmt.Errorf("wayland: %w", err)
+			}
+			return c.err
+		}
+		c.pending = append(c.pending, buf[:n]...)
+		if err := c.dispatch(); err != nil {
+			c.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatch hands the complete messages read so far to their handlers.
+func (c *wlConn) dispatch() error {
+	for len(c.pending) >= 8 {
+		id := binary.NativeEndian.Uint32(c.pending)
+		p1 := binary.NativeEndian.Uint32(c.pending[4:])
+		size := int(p1 >> 16)
+		if size < 8 || size%4 != 0 {
+			return errMalformedWayland
+		}
+		if len(c.pending) < size {
+			break
+		}
+		// Events sent to objects destroyed in the meantime are ignored, as libwayland does.
+		if handler := c.handlers[id]; handler != nil {
+			r := &wlReader{buf: c.pending[8:size]}
+			if err := handler(uint16(p1), r); err != nil {
+				return err
+			}
+		}
+		c.pending = c.pending[size:]
+	}
+	if len(c.pending) == 0 {
+		c.pending = nil
+	}
+	return nil
+}
+
+func (c *wlConn) displayEvent(opcode uint16, r *wlReader) error {
+	switch opcode {
+	case wlDisplayEventError:
+		objectId, code, message := r.uint(), r.uint(), r.string()
+		if r.err != nil {
+			return r.err
+		}
+		return fmt.Errorf("wayland: error %d on object %d: %s", code, objectId, message)
+	case wlDisplayEventDeleteId:
+		id := r.uint()
+		if r.err != nil {
+			return r.err
+		}
+		if _, ok := c.handlers[id]; ok {
+			delete(c.handlers, id)
+			c.free = append(c.free, id)
+		}
+	}
+	return nil
+}
+
+// wlWriter serializes the arguments of a request: 32-bit words in the native byte order, with strings
+// and arrays padded to 4 bytes. File descriptors are sent out of band.
+type wlWriter struct {
+	buf []byte
+	fds []int
+}
+
+func (w *wlWriter) uint(v uint32) {
+	w.buf = binary.NativeEndian.AppendUint32(w.buf, v)
+}
+
+func (w *wlWriter) int(v int32) {
+	w.uint(uint32(v))
+}
+
+func (w *wlWriter) string(s string) {
+	w.uint(uint32(len(s) + 1))
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+	w.pad()
+}
+
+func (w *wlWriter) array(b []byte) {
+	w.uint(uint32(len(b)))
+	w.buf = append(w.buf, b...)
+	w.pad()
+}
+
+func (w *wlWriter) fd(fd int) {
+	w.fds = append(w.fds, fd)
+}
+
+func (w *wlWriter) pad() {
+	for len(w.buf)%4 != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// wlReader reads the arguments of an event in order. The first error sticks, so that it can be
+// checked once after reading every argument.
+type wlReader struct {
+	buf []byte
+	err error
+}
+
+func (r *wlReader) uint() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 4 {
+		r.err = errMalformedWayland
+		return 0
+	}
+	v := binary.NativeEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *wlReader) int() int32 {
+	return int32(r.uint())
+}
+
+func (r *wlReader) array() []byte {
+	size := r.uint()
+	if r.err != nil {
+		return nil
+	}
+	padded := (uint64(size) + 3) &^ 3
+	if padded > uint64(len(r.buf)) {
+		r.err = errMalformedWayland
+		return nil
+	}
+	b := r.buf[:size]
+	r.buf = r.buf[padded:]
+	return b
+}
+
+func (r *wlReader) string() string {
+	b := r.array()
+	if len(b) == 0 {
+		// A null string.
+		return ""
+	}
+	if b[len(b)-1] != 0 {
+		r.err = errMalformedWayland
+		return ""
+	}
+	return string(b[:len(b)-1])
+}
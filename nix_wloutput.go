@@ -0,0 +1,364 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Opcodes and constants of wl_output from wayland.xml, and of xdg-output-unstable-v1.
+const (
+	wlOutputMethodRelease = 0
+	wlOutputEventGeometry = 0
+	wlOutputEventMode     = 1
+	wlOutputEventDone     = 2
+	wlOutputEventScale    = 3
+	wlOutputEventName     = 4
+	wlOutputModeCurrent   = 1
+
+	zxdgOutputManagerMethodGetXdgOutput = 1
+	zxdgOutputMethodDestroy             = 0
+	zxdgOutputEventLogicalPosition      = 0
+	zxdgOutputEventLogicalSize          = 1
+	zxdgOutputEventName                 = 3
+)
+
+// waylandTimeout bounds the exchanges with the compositor when the context has no deadline.
+const waylandTimeout = 5 * time.Second
+
+// wlDesktop is a connection to a Wayland compositor, with the outputs it reports and the capture
+// protocols it implements, e.g. wlr-screencopy-unstable-v1 on Sway or Hyprland. The outputs follow the
+// events of the compositor, which are handled whenever the connection waits for one, see refresh.
+type wlDesktop struct {
+	c                 *wlConn
+	registry          uint32
+	outputs           []*wlOutput
+	xdgOutputManager  uint32         // zxdg_output_manager_v1, or 0
+	bound             bool           // an output was bound since the last refresh
+	stale             bool           // the outputs changed since the last layout
+	retired           []*wlShmBuffer // buffers of removed outputs, destroyed by the next capture
+	shm               uint32
+	screencopy        uint32 // zwlr_screencopy_manager_v1, or 0
+	screencopyVersion uint32
+	copyManager       uint32 // ext_image_copy_capture_manager_v1, or 0
+	sourceManager     uint32 // ext_output_image_capture_source_manager_v1, or 0
+
+	// The compositor space, in logical pixels, is mapped to the coordinate system of Capture by
+	// subtracting origin, the position of the first output, and multiplying by scale.
+	origin image.Point
+	scale  float64
+}
+
+// wlOutput is the state of a wl_output, as reported by its events and those of its zxdg_output_v1.
+type wlOutput struct {
+	id        uint32
+	version   uint32
+	global    uint32
+	xdg       uint32      // zxdg_output_v1, or 0
+	position  image.Point // in the compositor space
+	mode      image.Point // current mode, in pixels
+	sizeMM    image.Point
+	transform int32
+	refresh   int32 // in mHz
+	scale     int32
+	name      string // from wl_output version 4 or xdg-output
+	product   string // manufacturer and model
+
+	logical image.Rectangle // region of the compositor space, from xdg-output
+	bounds  image.Rectangle // in the coordinate system of Capture
+
+	buffer *wlShmBuffer // the captures are written to, see outputBuffer
+}
+
+// pixels returns the size of the output in pixels, once transformed.
+func (o *wlOutput) pixels() image.Point {
+	size := o.mode
+	if o.transform%2 == 1 {
+		size.X, size.Y = size.Y, size.X
+	}
+	return size
+}
+
+// rotation converts the transform of the output, which rotates counter-clockwise, to a Rotation.
+func (o *wlOutput) rotation() Rotation {
+	return Rotation((4 - o.transform%4) % 4 * 90)
+}
+
+func openWayland(ctx context.Context) (wl *wlDesktop, e error) {
+	c, err := dialWayland()
+	if err != nil {
+		return nil, err
+	}
+	wl = &wlDesktop{c: c, stale: true}
+	defer func() {
+		if e != nil {
+			c.close()
+		}
+	}()
+
+	wl.registry = c.newId(wl.registryEvent)
+	err = c.send(wlDisplayId, wlDisplayMethodGetRegistry, func(w *wlWriter) {
+		w.uint(wl.registry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := wl.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return wl, nil
+}
+
+// refresh handles the events sent by the compositor since the connection last waited for one, and
+// places the outputs again if they changed.
+func (wl *wlDesktop) refresh(ctx context.Context) error {
+	wl.bound = false
+	if err := wl.c.roundtrip(ctx); err != nil {
+		return err
+	}
+	// The outputs announced meanwhile send their state in reply to being bound.
+	if wl.bound {
+		if err := wl.c.roundtrip(ctx); err != nil {
+			return err
+		}
+	}
+	if len(wl.outputs) == 0 {
+		return fmt.Errorf("%w: the compositor has no output", ErrNoDisplay)
+	}
+	if wl.stale {
+		wl.layout()
+	}
+	return nil
+}
+
+func (wl *wlDesktop) registryEvent(opcode uint16, r *wlReader) error {
+	switch opcode {
+	case wlRegistryEventGlobal:
+		name, iface, version := r.uint(), r.string(), r.uint()
+		if r.err == nil {
+			wl.bindGlobal(name, iface, version)
+		}
+	case wlRegistryEventGlobalRemove:
+		name := r.uint()
+		if r.err == nil {
+			wl.removeGlobal(name)
+		}
+	}
+	return r.err
+}
+
+// bind binds the global name, which implements iface.
+func (wl *wlDesktop) bind(name uint32, iface string, version uint32, handler wlHandler) uint32 {
+	id := wl.c.newId(handler)
+	_ = wl.c.send(wl.registry, wlRegistryMethodBind, func(w *wlWriter) {
+		w.uint(name)
+		w.string(iface)
+		w.uint(version)
+		w.uint(id)
+	})
+	return id
+}
+
+// bindGlobal binds the globals used for capturing, at the latest version supported up to the one offered.
+func (wl *wlDesktop) bindGlobal(name uint32, iface string, version uint32) {
+	switch iface {
+	case "wl_shm":
+		wl.shm = wl.bind(name, iface, 1, nil)
+	case "wl_output":
+		o := &wlOutput{global: name, version: min(version, 4), scale: 1}
+		o.id = wl.bind(name, iface, o.version, func(opcode uint16, r *wlReader) error {
+			if opcode == wlOutputEventDone {
+				wl.stale = true
+			}
+			return o.event(opcode, r)
+		})
+		if wl.xdgOutputManager != 0 {
+			wl.getXdgOutput(o)
+		}
+		wl.outputs = append(wl.outputs, o)
+		wl.bound = true
+		wl.stale = true
+	case "zxdg_output_manager_v1":
+		wl.xdgOutputManager = wl.bind(name, iface, min(version, 3), nil)
+		for _, o := range wl.outputs {
+			wl.getXdgOutput(o)
+		}
+	case "zwlr_screencopy_manager_v1":
+		wl.screencopyVersion = min(version, 3)
+		wl.screencopy = wl.bind(name, iface, wl.screencopyVersion, nil)
+	case "ext_image_copy_capture_manager_v1":
+		wl.copyManager = wl.bind(name, iface, 1, nil)
+	case "ext_output_image_capture_source_manager_v1":
+		wl.sourceManager = wl.bind(name, iface, 1, nil)
+	}
+}
+
+func (wl *wlDesktop) getXdgOutput(o *wlOutput) {
+	o.xdg = wl.c.newId(o.xdgEvent)
+	_ = wl.c.send(wl.xdgOutputManager, zxdgOutputManagerMethodGetXdgOutput, func(w *wlWriter) {
+		w.uint(o.xdg)
+		w.uint(o.id)
+	})
+}
+
+// removeGlobal forgets the output which is the global name, e.g. an unplugged monitor.
+func (wl *wlDesktop) removeGlobal(name uint32) {
+	i := slices.IndexFunc(wl.outputs, func(o *wlOutput) bool { return o.global == name })
+	if i < 0 {
+		return
+	}
+	o := wl.outputs[i]
+	if o.xdg != 0 {
+		_ = wl.c.send(o.xdg, zxdgOutputMethodDestroy, nil)
+	}
+	if o.version >= 3 {
+		_ = wl.c.send(o.id, wlOutputMethodRelease, nil)
+	}
+	if o.buffer != nil {
+		// A capture of the output may be reading the buffer.
+		wl.retired = append(wl.retired, o.buffer)
+	}
+	// The slice is copied, since a capture may be iterating over it.
+	wl.outputs = slices.Delete(slices.Clone(wl.outputs), i, i+1)
+	wl.stale = true
+}
+
+func (wl *wlDesktop) close() {
+	wl.releaseRetired()
+	for _, o := range wl.outputs {
+		if o.buffer != nil {
+			o.buffer.destroy()
+		}
+	}
+	wl.c.close()
+}
+
+// checkScreencopy returns an error unless the compositor implements a protocol to capture the outputs.
+func (wl *wlDesktop) checkScreencopy() error {
+	if wl.shm == 0 {
+		return fmt.Errorf("%w: wl_shm", ErrExtensionMissing)
+	}
+	if wl.screencopy == 0 && (wl.copyManager == 0 || wl.sourceManager == 0) {
+		return fmt.Errorf("%w: zwlr_screencopy_manager_v1 or ext_image_copy_capture_manager_v1", ErrExtensionMissing)
+	}
+	return nil
+}
+
+func (o *wlOutput) event(opcode uint16, r *wlReader) error {
+	switch opcode {
+	case wlOutputEventGeometry:
+		o.position.X = int(r.int())
+		o.position.Y = int(r.int())
+		o.sizeMM.X = int(r.int())
+		o.sizeMM.Y = int(r.int())
+		r.int() // subpixel
+		manufacturer, model := r.string(), r.string()
+		o.product = strings.TrimSpace(manufacturer + " " + model)
+		o.transform = r.int()
+	case wlOutputEventMode:
+		flags := r.uint()
+		width, height, refresh := r.int(), r.int(), r.int()
+		if flags&wlOutputModeCurrent != 0 {
+			o.mode = image.Pt(int(width), int(height))
+			o.refresh = refresh
+		}
+	case wlOutputEventScale:
+		o.scale = r.int()
+	case wlOutputEventName:
+		o.name = r.string()
+	}
+	return r.err
+}
+
+func (o *wlOutput) xdgEvent(opcode uint16, r *wlReader) error {
+	switch opcode {
+	case zxdgOutputEventLogicalPosition:
+		x, y := r.int(), r.int()
+		o.logical = image.Rectangle{Min: image.Pt(int(x), int(y)), Max: image.Pt(int(x), int(y)).Add(o.logical.Size())}
+	case zxdgOutputEventLogicalSize:
+		width, height := r.int(), r.int()
+		o.logical.Max = o.logical.Min.Add(image.Pt(int(width), int(height)))
+	case zxdgOutputEventName:
+		if name := r.string(); o.name == "" {
+			o.name = name
+		}
+	}
+	return r.err
+}
+
+// layout places the outputs in the coordinate system of Capture. The compositor space is scaled by the
+// largest scale of the outputs, so that the densest one keeps all its pixels. Without xdg-output, the
+// logical region of an output is derived from its integer scale.
+func (wl *wlDesktop) layout() {
+	wl.stale = false
+	if len(wl.outputs) == 0 {
+		return
+	}
+	wl.scale = 1
+	for _, o := range wl.outputs {
+		size := o.pixels()
+		if o.xdg == 0 || o.logical.Empty() {
+			scale := max(int(o.scale), 1)
+			o.logical = image.Rectangle{Min: o.position, Max: o.position.Add(size.Div(scale))}
+		}
+		if o.logical.Dx() > 0 {
+			wl.scale = max(wl.scale, float64(size.X)/float64(o.logical.Dx()))
+		}
+	}
+	wl.origin = wl.outputs[0].logical.Min
+	for _, o := range wl.outputs {
+		o.bounds = image.Rectangle{Min: wl.point(o.logical.Min), Max: wl.point(o.logical.Max)}
+	}
+}
+
+// point converts a point of the compositor space to the coordinate system of Capture.
+func (wl *wlDesktop) point(p image.Point) image.Point {
+	return image.Pt(
+		int(math.Round(float64(p.X-wl.origin.X)*wl.scale)),
+		int(math.Round(float64(p.Y-wl.origin.Y)*wl.scale)))
+}
+
+// desktop returns the region covered by the outputs, which is the extent of a screenshot of the
+// whole desktop.
+func (wl *wlDesktop) desktop() image.Rectangle {
+	var r image.Rectangle
+	for _, o := range wl.outputs {
+		r = r.Union(o.bounds)
+	}
+	return r
+}
+
+func (wl *wlDesktop) displays() []Display {
+	displays := make([]Display, len(wl.outputs))
+	for i, o := range wl.outputs {
+		scale := float64(o.scale)
+		if o.logical.Dx() > 0 {
+			scale = float64(o.pixels().X) / float64(o.logical.Dx())
+		}
+		name := o.name
+		if name == "" {
+			name = o.product
+		}
+		displays[i] = Display{
+			Index:       i,
+			ID:          strconv.FormatUint(uint64(o.global), 10),
+			Name:        name,
+			Primary:     i == 0,
+			Bounds:      o.bounds,
+			WidthMM:     o.sizeMM.X,
+			HeightMM:    o.sizeMM.Y,
+			Rotation:    o.rotation(),
+			ReflectX:    o.transform >= 4, // the flipped transforms
+			RefreshRate: float64(o.refresh) / 1000,
+			ScaleFactor: scale,
+		}
+	}
+	return displays
+}
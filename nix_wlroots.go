@@ -0,0 +1,438 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"syscall"
+)
+
+// Opcodes and constants of wl_shm from wayland.xml, of wlr-screencopy-unstable-v1,
+// and of ext-image-capture-source-v1 and ext-image-copy-capture-v1.
+const (
+	wlShmMethodCreatePool       = 0
+	wlShmPoolMethodCreateBuffer = 0
+	wlShmPoolMethodDestroy      = 1
+	wlBufferMethodDestroy       = 0
+
+	wlShmFormatARGB8888 = 0
+	wlShmFormatXRGB8888 = 1
+	wlShmFormatABGR8888 = 0x34324241
+	wlShmFormatXBGR8888 = 0x34324258
+
+	zwlrScreencopyManagerMethodCaptureOutput = 0
+	zwlrScreencopyFrameMethodCopy            = 0
+	zwlrScreencopyFrameMethodDestroy         = 1
+	zwlrScreencopyFrameEventBuffer           = 0
+	zwlrScreencopyFrameEventFlags            = 1
+	zwlrScreencopyFrameEventReady            = 2
+	zwlrScreencopyFrameEventFailed           = 3
+	zwlrScreencopyFrameEventBufferDone       = 6
+	zwlrScreencopyFrameFlagYInvert           = 1
+
+	extSourceManagerMethodCreateSource = 0
+	extSourceMethodDestroy             = 0
+	extCopyManagerMethodCreateSession  = 0
+	extCopyManagerOptionPaintCursors   = 1
+	extSessionMethodCreateFrame        = 0
+	extSessionMethodDestroy            = 1
+	extSessionEventBufferSize          = 0
+	extSessionEventShmFormat           = 1
+	extSessionEventDone                = 4
+	extSessionEventStopped             = 5
+	extFrameMethodDestroy              = 0
+	extFrameMethodAttachBuffer         = 1
+	extFrameMethodDamageBuffer         = 2
+	extFrameMethodCapture              = 3
+	extFrameEventTransform             = 0
+	extFrameEventReady                 = 3
+	extFrameEventFailed                = 4
+)
+
+// captureInto captures the outputs which intersect rect, as laid out when the capture starts. Areas
+// outside of every output are left transparent. The frame of an output whose scale is lower than the largest one is enlarged to its bounds.
+func (wl *wlDesktop) captureInto(ctx context.Context, dst *image.RGBA, rect image.Rectangle, cursor bool) error {
+	// The events of the outputs were handled while waiting for the previous capture.
+	if wl.stale {
+		wl.layout()
+	}
+	wl.releaseRetired()
+	draw.Draw(dst, dst.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	for _, o := range wl.outputs {
+		intersect := o.bounds.Intersect(rect)
+		if intersect.Empty() {
+			continue
+		}
+		var img *image.RGBA
+		var err error
+		if wl.copyManager != 0 && wl.sourceManager != 0 {
+			img, err = wl.copyOutput(ctx, o, cursor)
+		} else {
+			img, err = wl.screencopyOutput(ctx, o, cursor)
+		}
+		if err != nil {
+			return err
+		}
+		if img.Bounds().Size() != o.bounds.Size() {
+			img = scaleFrame(img, o.bounds.Size())
+		}
+		draw.Draw(dst, intersect.Sub(rect.Min).Add(dst.Rect.Min), img, intersect.Min.Sub(o.bounds.Min), draw.Src)
+	}
+	return nil
+}
+
+// screencopyOutput captures an output through wlr-screencopy-unstable-v1.
+func (wl *wlDesktop) screencopyOutput(ctx context.Context, o *wlOutput, cursor bool) (*image.RGBA, error) {
+	var format, width, height, stride, flags uint32
+	var haveBuffer, bufferDone, ready, failed bool
+	frame := wl.c.newId(func(opcode uint16, r *wlReader) error {
+		switch opcode {
+		case zwlrScreencopyFrameEventBuffer:
+			f, w, h, s := r.uint(), r.uint(), r.uint(), r.uint()
+			if r.err == nil && !haveBuffer && wl.supportsFormat(f) {
+				format, width, height, stride = f, w, h, s
+				haveBuffer = true
+			}
+		case zwlrScreencopyFrameEventFlags:
+			flags = r.uint()
+		case zwlrScreencopyFrameEventReady:
+			ready = true
+		case zwlrScreencopyFrameEventFailed:
+			failed = true
+		case zwlrScreencopyFrameEventBufferDone:
+			bufferDone = true
+		}
+		return r.err
+	})
+	overlayCursor := int32(0)
+	if cursor {
+		overlayCursor = 1
+	}
+	err := wl.c.send(wl.screencopy, zwlrScreencopyManagerMethodCaptureOutput, func(w *wlWriter) {
+		w.uint(frame)
+		w.int(overlayCursor)
+		w.uint(o.id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer wl.c.send(frame, zwlrScreencopyFrameMethodDestroy, nil)
+
+	// Before version 3, the compositor offers a single buffer type and does not send buffer_done.
+	err = wl.c.wait(ctx, func() bool {
+		return failed || bufferDone || (wl.screencopyVersion < 3 && haveBuffer)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if failed {
+		return nil, fmt.Errorf("wayland: screencopy of output %q failed", o.name)
+	}
+	if !haveBuffer {
+		return nil, errors.New("wayland: the compositor offers no supported wl_shm format")
+	}
+
+	buffer, err := wl.outputBuffer(o, int(width), int(height), int(stride), format)
+	if err != nil {
+		return nil, err
+	}
+	err = wl.c.send(frame, zwlrScreencopyFrameMethodCopy, func(w *wlWriter) {
+		w.uint(buffer.id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := wl.c.wait(ctx, func() bool { return ready || failed }); err != nil {
+		return nil, err
+	}
+	if failed {
+		return nil, fmt.Errorf("wayland: screencopy of output %q failed", o.name)
+	}
+	return transformFrame(buffer.image(flags&zwlrScreencopyFrameFlagYInvert != 0), o.transform), nil
+}
+
+// copyOutput captures an output through ext-image-copy-capture-v1.
+func (wl *wlDesktop) copyOutput(ctx context.Context, o *wlOutput, cursor bool) (*image.RGBA, error) {
+	source := wl.c.newId(nil)
+	err := wl.c.send(wl.sourceManager, extSourceManagerMethodCreateSource, func(w *wlWriter) {
+		w.uint(source)
+		w.uint(o.id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer wl.c.send(source, extSourceMethodDestroy, nil)
+
+	var width, height, format uint32
+	var haveFormat, done, stopped bool
+	session := wl.c.newId(func(opcode uint16, r *wlReader) error {
+		switch opcode {
+		case extSessionEventBufferSize:
+			width, height = r.uint(), r.uint()
+		case extSessionEventShmFormat:
+			f := r.uint()
+			if r.err == nil && !haveFormat && wl.supportsFormat(f) {
+				format = f
+				haveFormat = true
+			}
+		case extSessionEventDone:
+			done = true
+		case extSessionEventStopped:
+			stopped = true
+		}
+		return r.err
+	})
+	var options uint32
+	if cursor {
+		options = extCopyManagerOptionPaintCursors
+	}
+	err = wl.c.send(wl.copyManager, extCopyManagerMethodCreateSession, func(w *wlWriter) {
+		w.uint(session)
+		w.uint(source)
+		w.uint(options)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer wl.c.send(session, extSessionMethodDestroy, nil)
+
+	if err := wl.c.wait(ctx, func() bool { return done || stopped }); err != nil {
+		return nil, err
+	}
+	if stopped {
+		return nil, fmt.Errorf("wayland: capture session of output %q stopped", o.name)
+	}
+	if !haveFormat {
+		return nil, errors.New("wayland: the compositor offers no supported wl_shm format")
+	}
+
+	buffer, err := wl.outputBuffer(o, int(width), int(height), int(width)*4, format)
+	if err != nil {
+		return nil, err
+	}
+	var ready, failed bool
+	var reason uint32
+	transform := o.transform
+	frame := wl.c.newId(func(opcode uint16, r *wlReader) error {
+		switch opcode {
+		case extFrameEventTransform:
+			transform = int32(r.uint())
+		case extFrameEventReady:
+			ready = true
+		case extFrameEventFailed:
+			reason = r.uint()
+			failed = true
+		}
+		return r.err
+	})
+	err = wl.c.send(session, extSessionMethodCreateFrame, func(w *wlWriter) {
+		w.uint(frame)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer wl.c.send(frame, extFrameMethodDestroy, nil)
+	_ = wl.c.send(frame, extFrameMethodAttachBuffer, func(w *wlWriter) {
+		w.uint(buffer.id)
+	})
+	_ = wl.c.send(frame, extFrameMethodDamageBuffer, func(w *wlWriter) {
+		w.int(0)
+		w.int(0)
+		w.int(int32(width))
+		w.int(int32(height))
+	})
+	err = wl.c.send(frame, extFrameMethodCapture, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := wl.c.wait(ctx, func() bool { return ready || failed }); err != nil {
+		return nil, err
+	}
+	if failed {
+		return nil, fmt.Errorf("wayland: capture of output %q failed with reason %d", o.name, reason)
+	}
+	return transformFrame(buffer.image(false), transform), nil
+}
+
+// scaleFrame resizes img to size by taking the nearest pixel, which keeps the edges of text and
+// windows sharp when an output is enlarged by an integer factor.
+func scaleFrame(img *image.RGBA, size image.Point) *image.RGBA {
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w == 0 || h == 0 {
+		return dst
+	}
+	for y := 0; y < size.Y; y++ {
+		sy := img.Rect.Min.Y + (2*y+1)*h/(2*size.Y)
+		for x := 0; x < size.X; x++ {
+			sx := img.Rect.Min.X + (2*x+1)*w/(2*size.X)
+			i, j := dst.PixOffset(x, y), img.PixOffset(sx, sy)
+			copy(dst.Pix[i:i+4], img.Pix[j:j+4])
+		}
+	}
+	return dst
+}
+
+// transformFrame returns the frame of an output as it is shown. The buffers of the capture protocols
+// are not transformed, while the compositor draws the desktop into them through the transform of the
+// output: a counter-clockwise rotation, preceded by a flip around the vertical axis for the flipped
+// transforms.
+func transformFrame(img *image.RGBA, transform int32) *image.RGBA {
+	if transform == 0 {
+		return img
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if transform%2 == 1 {
+		w, h = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Follow the pixel (x, y) of the desktop through the flip and the quarter turns.
+			px, py := x, y
+			if transform >= 4 {
+				px = w - 1 - px
+			}
+			cw, ch := w, h
+			for i := int32(0); i < transform%4; i++ {
+				px, py = py, cw-1-px
+				cw, ch = ch, cw
+			}
+			i, j := dst.PixOffset(x, y), img.PixOffset(img.Rect.Min.X+px, img.Rect.Min.Y+py)
+			copy(dst.Pix[i:i+4], img.Pix[j:j+4])
+		}
+	}
+	return dst
+}
+
+// supportsFormat reports whether image can convert a buffer of format, one of the formats offered by
+// the compositor for a frame.
+func (wl *wlDesktop) supportsFormat(format uint32) bool {
+	switch format {
+	case wlShmFormatARGB8888, wlShmFormatXRGB8888, wlShmFormatABGR8888, wlShmFormatXBGR8888:
+		return true
+	}
+	return false
+}
+
+// wlShmBuffer is a wl_buffer in shared memory, which the compositor writes a frame to.
+type wlShmBuffer struct {
+	c                     *wlConn
+	file                  *os.File
+	data                  []byte
+	pool, id              uint32
+	width, height, stride int
+	format                uint32
+}
+
+// outputBuffer returns the buffer the captures of the output are written to. It is kept between the
+// captures, and only recreated when the compositor asks for another size, stride or format, e.g. after a
+// mode change.
+func (wl *wlDesktop) outputBuffer(o *wlOutput, width, height, stride int, format uint32) (*wlShmBuffer, error) {
+	if b := o.buffer; b != nil && b.width == width && b.height == height && b.stride == stride && b.format == format {
+		return b, nil
+	}
+	if o.buffer != nil {
+		o.buffer.destroy()
+		o.buffer = nil
+	}
+	b, err := wl.newShmBuffer(width, height, stride, format)
+	if err != nil {
+		return nil, err
+	}
+	o.buffer = b
+	return b, nil
+}
+
+// releaseRetired destroys the buffers of the outputs removed since the previous capture.
+func (wl *wlDesktop) releaseRetired() {
+	for _, b := range wl.retired {
+		b.destroy()
+	}
+	wl.retired = nil
+}
+
+func (wl *wlDesktop) newShmBuffer(width, height, stride int, format uint32) (b *wlShmBuffer, e error) {
+	if width <= 0 || height <= 0 || stride < width*4 {
+		return nil, fmt.Errorf("wayland: invalid buffer of %dx%d pixels with stride %d", width, height, stride)
+	}
+	size := stride * height
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	file, err := os.CreateTemp(dir, "screenshot-shm-")
+	if err != nil {
+		return nil, err
+	}
+	// Only the descriptors are needed.
+	_ = os.Remove(file.Name())
+	defer func() {
+		if e != nil {
+			file.Close()
+		}
+	}()
+	if err := file.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("wayland: mmap failed: %w", err)
+	}
+
+	b = &wlShmBuffer{c: wl.c, file: file, data: data, width: width, height: height, stride: stride, format: format}
+	b.pool = wl.c.newId(nil)
+	_ = wl.c.send(wl.shm, wlShmMethodCreatePool, func(w *wlWriter) {
+		w.uint(b.pool)
+		w.fd(int(file.Fd()))
+		w.int(int32(size))
+	})
+	b.id = wl.c.newId(nil)
+	err = wl.c.send(b.pool, wlShmPoolMethodCreateBuffer, func(w *wlWriter) {
+		w.uint(b.id)
+		w.int(0)
+		w.int(int32(width))
+		w.int(int32(height))
+		w.int(int32(stride))
+		w.uint(format)
+	})
+	if err != nil {
+		_ = syscall.Munmap(data)
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *wlShmBuffer) destroy() {
+	_ = b.c.send(b.id, wlBufferMethodDestroy, nil)
+	_ = b.c.send(b.pool, wlShmPoolMethodDestroy, nil)
+	_ = syscall.Munmap(b.data)
+	b.file.Close()
+}
+
+// image converts the content of the buffer. The formats of wl_shm are little-endian, e.g. the bytes
+// of an XRGB8888 pixel are blue, green, red and unused.
+func (b *wlShmBuffer) image(yInvert bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, b.width, b.height))
+	var op pixelOp
+	if b.format == wlShmFormatARGB8888 || b.format == wlShmFormatXRGB8888 {
+		op |= pixelSwap
+	}
+	if b.format == wlShmFormatXRGB8888 || b.format == wlShmFormatXBGR8888 {
+		op |= pixelOpaque
+	}
+	if !yInvert {
+		convertPixels(img.Pix, img.Stride, b.data, b.stride, b.width, b.height, op)
+		return img
+	}
+	for y := 0; y < b.height; y++ {
+		srcY := b.height - 1 - y
+		convertRow(img.Pix[y*img.Stride:y*img.Stride+b.width*4], b.data[srcY*b.stride:srcY*b.stride+b.width*4], op)
+	}
+	return img
+}
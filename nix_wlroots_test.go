@@ -0,0 +1,772 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// fakeCompositor implements the server side of the Wayland protocols used by the wlroots backend,
+// with outputs filled by fakePixel.
+type fakeCompositor struct {
+	t          *testing.T
+	outputs    []fakeOutput
+	screencopy bool         // advertise zwlr_screencopy_manager_v1
+	imageCopy  bool         // advertise ext_image_copy_capture_manager_v1
+	xdgOutput  bool         // advertise zxdg_output_manager_v1
+	pools      atomic.Int32 // wl_shm_pool objects created
+	// changes are applied to the connection when it handles the next wl_display.sync, which makes
+	// them part of the next roundtrip of the client.
+	changes chan func(c *fakeClient)
+}
+
+// change queues a change of the outputs, see fakeCompositor.changes.
+func (f *fakeCompositor) change(fn func(c *fakeClient)) {
+	f.changes <- fn
+}
+
+type fakeOutput struct {
+	name     string
+	position image.Point // in the compositor space
+	size     image.Point // in pixels
+	scale    int
+	// transform is 0, or 1 for a rotation by 90 degrees counter-clockwise, which the buffers are drawn
+	// with like wlroots does.
+	transform int32
+}
+
+// fakePixel is the color of the pixel (x, y) of the index'th output.
+func fakePixel(index, x, y int) color.RGBA {
+	return color.RGBA{uint8(10 * x), uint8(10 * y), uint8(100 * (index + 1)), 255}
+}
+
+// listen serves the compositor on a socket found through $WAYLAND_DISPLAY, and makes the session
+// a Wayland one.
+func (f *fakeCompositor) listen() {
+	dir := f.t.TempDir()
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "wayland-test"), Net: "unix"})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.t.Cleanup(func() { ln.Close() })
+	f.t.Setenv("XDG_SESSION_TYPE", "wayland")
+	f.t.Setenv("XDG_RUNTIME_DIR", dir)
+	f.t.Setenv("WAYLAND_DISPLAY", "wayland-test")
+	go func() {
+		for {
+			conn, err := ln.AcceptUnix()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+}
+
+// fakeClient is the state of a connection to the fake compositor.
+type fakeClient struct {
+	f        *fakeCompositor
+	conn     *net.UnixConn
+	registry uint32
+	fds      []int
+	objects  map[uint32]string // interface of each object
+	outputs  map[uint32]int    // index of the output of wl_output, capture sources and sessions, and frames
+	pools    map[uint32]*os.File
+	buffers  map[uint32]fakeBuffer
+	attach   map[uint32]uint32 // buffer attached to an ext_image_copy_capture_frame_v1
+}
+
+type fakeBuffer struct {
+	pool                  *os.File
+	offset, width, height int
+	stride                int
+	format                uint32
+}
+
+func (f *fakeCompositor) serve(conn *net.UnixConn) {
+	defer conn.Close()
+	c := &fakeClient{
+		f:       f,
+		conn:    conn,
+		objects: map[uint32]string{wlDisplayId: "wl_display"},
+		outputs: map[uint32]int{},
+		pools:   map[uint32]*os.File{},
+		buffers: map[uint32]fakeBuffer{},
+		attach:  map[uint32]uint32{},
+	}
+	defer func() {
+		for _, pool := range c.pools {
+			pool.Close()
+		}
+		closeFds(c.fds)
+	}()
+	var pending []byte
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(wlMaxFds*4))
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if oobn > 0 {
+			c.fds = append(c.fds, parseRights(oob[:oobn])...)
+		}
+		if err != nil {
+			return
+		}
+		pending = append(pending, buf[:n]...)
+		for len(pending) >= 8 {
+			id := binary.NativeEndian.Uint32(pending)
+			p1 := binary.NativeEndian.Uint32(pending[4:])
+			size := int(p1 >> 16)
+			if len(pending) < size {
+				break
+			}
+			c.request(id, uint16(p1), &wlReader{buf: pending[8:size]})
+			pending = pending[size:]
+		}
+	}
+}
+
+func (c *fakeClient) event(id uint32, opcode uint16, build func(w *wlWriter)) {
+	w := wlWriter{buf: make([]byte, 8)}
+	if build != nil {
+		build(&w)
+	}
+	binary.NativeEndian.PutUint32(w.buf, id)
+	binary.NativeEndian.PutUint32(w.buf[4:], uint32(len(w.buf))<<16|uint32(opcode))
+	// The client may have hung up already, e.g. while its last objects are destroyed.
+	_, _ = c.conn.Write(w.buf)
+}
+
+// destroy releases the id of an object destroyed by the client.
+func (c *fakeClient) destroy(id uint32) {
+	delete(c.objects, id)
+	c.event(wlDisplayId, wlDisplayEventDeleteId, func(w *wlWriter) {
+		w.uint(id)
+	})
+}
+
+func (c *fakeClient) request(id uint32, opcode uint16, r *wlReader) {
+	f := c.f
+	switch iface := c.objects[id]; {
+	case iface == "wl_display" && opcode == wlDisplayMethodSync:
+		callback := r.uint()
+		for pending := true; pending; {
+			select {
+			case fn := <-f.changes:
+				fn(c)
+			default:
+				pending = false
+			}
+		}
+		c.event(callback, wlCallbackEventDone, func(w *wlWriter) {
+			w.uint(0)
+		})
+		c.event(wlDisplayId, wlDisplayEventDeleteId, func(w *wlWriter) {
+			w.uint(callback)
+		})
+	case iface == "wl_display" && opcode == wlDisplayMethodGetRegistry:
+		registry := r.uint()
+		c.registry = registry
+		c.objects[registry] = "wl_registry"
+		for i := range f.outputs {
+			c.announceOutput(i)
+		}
+		globals := []string{"wl_shm"}
+		if f.xdgOutput {
+			globals = append(globals, "zxdg_output_manager_v1")
+		}
+		if f.screencopy {
+			globals = append(globals, "zwlr_screencopy_manager_v1")
+		}
+		if f.imageCopy {
+			globals = append(globals, "ext_image_copy_capture_manager_v1", "ext_output_image_capture_source_manager_v1")
+		}
+		for i, g := range globals {
+			c.event(registry, wlRegistryEventGlobal, func(w *wlWriter) {
+				w.uint(uint32(i + 1))
+				w.string(g)
+				w.uint(4)
+			})
+		}
+	case iface == "wl_registry" && opcode == wlRegistryMethodBind:
+		name, iface, _, newId := r.uint(), r.string(), r.uint(), r.uint()
+		c.objects[newId] = iface
+		if iface == "wl_output" {
+			index := int(name) - fakeOutputGlobal
+			c.outputs[newId] = index
+			c.sendOutput(newId, f.outputs[index])
+		}
+	case iface == "zxdg_output_manager_v1" && opcode == zxdgOutputManagerMethodGetXdgOutput:
+		xdgOutput, output := r.uint(), r.uint()
+		o := f.outputs[c.outputs[output]]
+		scale := max(o.scale, 1)
+		size := o.size
+		if o.transform == 1 {
+			size.X, size.Y = size.Y, size.X
+		}
+		c.event(xdgOutput, zxdgOutputEventLogicalPosition, func(w *wlWriter) {
+			w.int(int32(o.position.X))
+			w.int(int32(o.position.Y))
+		})
+		c.event(xdgOutput, zxdgOutputEventLogicalSize, func(w *wlWriter) {
+			w.int(int32(size.X / scale))
+			w.int(int32(size.Y / scale))
+		})
+		// From version 3, wl_output.done completes the events of xdg-output.
+		c.event(output, wlOutputEventDone, nil)
+	case iface == "wl_shm" && opcode == wlShmMethodCreatePool:
+		pool := r.uint()
+		if len(c.fds) == 0 {
+			f.t.Error("create_pool without a file descriptor")
+			return
+		}
+		c.objects[pool] = "wl_shm_pool"
+		f.pools.Add(1)
+		c.pools[pool] = os.NewFile(uintptr(c.fds[0]), "pool")
+		c.fds = c.fds[1:]
+	case iface == "wl_shm_pool" && opcode == wlShmPoolMethodCreateBuffer:
+		buffer := r.uint()
+		b := fakeBuffer{pool: c.pools[id]}
+		b.offset, b.width, b.height, b.stride = int(r.int()), int(r.int()), int(r.int()), int(r.int())
+		b.format = r.uint()
+		c.objects[buffer] = "wl_buffer"
+		c.buffers[buffer] = b
+	case iface == "wl_shm_pool" && opcode == wlShmPoolMethodDestroy:
+		c.pools[id].Close()
+		delete(c.pools, id)
+		c.destroy(id)
+	case iface == "wl_buffer" && opcode == wlBufferMethodDestroy:
+		delete(c.buffers, id)
+		c.destroy(id)
+
+	case iface == "zwlr_screencopy_manager_v1" && opcode == zwlrScreencopyManagerMethodCaptureOutput:
+		frame, _, output := r.uint(), r.int(), r.uint()
+		c.objects[frame] = "zwlr_screencopy_frame_v1"
+		c.outputs[frame] = c.outputs[output]
+		size := f.outputs[c.outputs[output]].size
+		c.event(frame, zwlrScreencopyFrameEventBuffer, func(w *wlWriter) {
+			w.uint(wlShmFormatXRGB8888)
+			w.uint(uint32(size.X))
+			w.uint(uint32(size.Y))
+			w.uint(uint32(size.X * 4))
+		})
+		c.event(frame, zwlrScreencopyFrameEventBufferDone, nil)
+	case iface == "zwlr_screencopy_frame_v1" && opcode == zwlrScreencopyFrameMethodCopy:
+		// The frames of odd outputs are upside down.
+		yInvert := c.outputs[id]%2 == 1
+		c.draw(c.outputs[id], c.buffers[r.uint()], yInvert)
+		var flags uint32
+		if yInvert {
+			flags = zwlrScreencopyFrameFlagYInvert
+		}
+		c.event(id, zwlrScreencopyFrameEventFlags, func(w *wlWriter) {
+			w.uint(flags)
+		})
+		c.event(id, zwlrScreencopyFrameEventReady, func(w *wlWriter) {
+			w.uint(0)
+			w.uint(0)
+			w.uint(0)
+		})
+	case iface == "zwlr_screencopy_frame_v1" && opcode == zwlrScreencopyFrameMethodDestroy:
+		c.destroy(id)
+
+	case iface == "ext_output_image_capture_source_manager_v1" && opcode == extSourceManagerMethodCreateSource:
+		source, output := r.uint(), r.uint()
+		c.objects[source] = "ext_image_capture_source_v1"
+		c.outputs[source] = c.outputs[output]
+	case iface == "ext_image_capture_source_v1" && opcode == extSourceMethodDestroy:
+		c.destroy(id)
+	case iface == "ext_image_copy_capture_manager_v1" && opcode == extCopyManagerMethodCreateSession:
+		session, source := r.uint(), r.uint()
+		c.objects[session] = "ext_image_copy_capture_session_v1"
+		c.outputs[session] = c.outputs[source]
+		size := f.outputs[c.outputs[source]].size
+		c.event(session, extSessionEventBufferSize, func(w *wlWriter) {
+			w.uint(uint32(size.X))
+			w.uint(uint32(size.Y))
+		})
+		c.event(session, extSessionEventShmFormat, func(w *wlWriter) {
+			w.uint(wlShmFormatXBGR8888)
+		})
+		c.event(session, extSessionEventDone, nil)
+	case iface == "ext_image_copy_capture_session_v1" && opcode == extSessionMethodCreateFrame:
+		frame := r.uint()
+		c.objects[frame] = "ext_image_copy_capture_frame_v1"
+		c.outputs[frame] = c.outputs[id]
+	case iface == "ext_image_copy_capture_session_v1" && opcode == extSessionMethodDestroy:
+		c.destroy(id)
+	case iface == "ext_image_copy_capture_frame_v1" && opcode == extFrameMethodAttachBuffer:
+		c.attach[id] = r.uint()
+	case iface == "ext_image_copy_capture_frame_v1" && opcode == extFrameMethodCapture:
+		c.draw(c.outputs[id], c.buffers[c.attach[id]], false)
+		if transform := f.outputs[c.outputs[id]].transform; transform != 0 {
+			c.event(id, extFrameEventTransform, func(w *wlWriter) {
+				w.uint(uint32(transform))
+			})
+		}
+		c.event(id, extFrameEventReady, nil)
+	case iface == "ext_image_copy_capture_frame_v1" && opcode == extFrameMethodDestroy:
+		c.destroy(id)
+	}
+	if r.err != nil {
+		f.t.Errorf("request %d of %s: %v", opcode, c.objects[id], r.err)
+	}
+}
+
+// fakeOutputGlobal is the name of the global of the first output, which is followed by the others.
+const fakeOutputGlobal = 100
+
+// announceOutput sends the global of the index'th output.
+func (c *fakeClient) announceOutput(index int) {
+	c.event(c.registry, wlRegistryEventGlobal, func(w *wlWriter) {
+		w.uint(uint32(fakeOutputGlobal + index))
+		w.string("wl_output")
+		w.uint(4)
+	})
+}
+
+// sendOutput sends the state of an output to its wl_output id.
+func (c *fakeClient) sendOutput(id uint32, o fakeOutput) {
+	c.event(id, wlOutputEventGeometry, func(w *wlWriter) {
+		w.int(int32(o.position.X))
+		w.int(int32(o.position.Y))
+		w.int(300)
+		w.int(200)
+		w.int(0)
+		w.string("Fake")
+		w.string("Monitor")
+		w.int(o.transform)
+	})
+	c.event(id, wlOutputEventMode, func(w *wlWriter) {
+		w.uint(wlOutputModeCurrent)
+		w.int(int32(o.size.X))
+		w.int(int32(o.size.Y))
+		w.int(60000)
+	})
+	if o.scale > 1 {
+		c.event(id, wlOutputEventScale, func(w *wlWriter) {
+			w.int(int32(o.scale))
+		})
+	}
+	c.event(id, wlOutputEventName, func(w *wlWriter) {
+		w.string(o.name)
+	})
+	c.event(id, wlOutputEventDone, nil)
+}
+
+// outputId returns the wl_output id bound to the index'th output.
+func (c *fakeClient) outputId(index int) uint32 {
+	for id, i := range c.outputs {
+		if i == index && c.objects[id] == "wl_output" {
+			return id
+		}
+	}
+	c.f.t.Errorf("output %d is not bound", index)
+	return 0
+}
+
+// draw fills buffer with the content of the index'th output.
+func (c *fakeClient) draw(index int, b fakeBuffer, yInvert bool) {
+	rotated := c.f.outputs[index].transform == 1
+	data := make([]byte, b.stride*b.height)
+	for y := 0; y < b.height; y++ {
+		row := y
+		if yInvert {
+			row = b.height - 1 - y
+		}
+		for x := 0; x < b.width; x++ {
+			p := fakePixel(index, x, y)
+			if rotated {
+				// The top row of the desktop is the left column of the buffer, from the bottom up.
+				p = fakePixel(index, b.height-1-y, x)
+			}
+			i := row*b.stride + x*4
+			switch b.format {
+			case wlShmFormatXRGB8888:
+				copy(data[i:], []byte{p.B, p.G, p.R, 0})
+			case wlShmFormatXBGR8888:
+				copy(data[i:], []byte{p.R, p.G, p.B, 0})
+			default:
+				c.f.t.Errorf("unexpected format %#x", b.format)
+			}
+		}
+	}
+	if _, err := b.pool.WriteAt(data, int64(b.offset)); err != nil {
+		c.f.t.Error(err)
+	}
+}
+
+// newFakeCompositor returns a compositor with two outputs side by side, which are not aligned at the top.
+func newFakeCompositor(t *testing.T) *fakeCompositor {
+	return &fakeCompositor{
+		t:       t,
+		changes: make(chan func(c *fakeClient), 4),
+		outputs: []fakeOutput{
+			{name: "DP-1", position: image.Pt(100, 50), size: image.Pt(4, 2)},
+			{name: "HDMI-A-1", position: image.Pt(104, 49), size: image.Pt(2, 3)},
+		},
+	}
+}
+
+func TestWlrootsDisplays(t *testing.T) {
+	f := newFakeCompositor(t)
+	f.screencopy = true
+	f.listen()
+
+	if n := NumActiveDisplays(); n != 2 {
+		t.Fatalf("NumActiveDisplays() = %d, want 2", n)
+	}
+	if got, want := GetDisplayBounds(1), image.Rect(4, -1, 6, 2); got != want {
+		t.Errorf("GetDisplayBounds(1) = %v, want %v", got, want)
+	}
+	displays, err := Displays()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := displays[0]
+	if d.Name != "DP-1" || !d.Primary || d.Bounds != image.Rect(0, 0, 4, 2) || d.RefreshRate != 60 || d.WidthMM != 300 {
+		t.Errorf("Displays()[0] = %+v", d)
+	}
+	if displays[1].Name != "HDMI-A-1" || displays[1].Primary {
+		t.Errorf("Displays()[1] = %+v", displays[1])
+	}
+}
+
+func TestWlrootsCapture(t *testing.T) {
+	for _, protocol := range []string{"screencopy", "ext-image-copy-capture"} {
+		t.Run(protocol, func(t *testing.T) {
+			f := newFakeCompositor(t)
+			f.screencopy = protocol == "screencopy"
+			f.imageCopy = !f.screencopy
+			f.listen()
+
+			c, err := NewCapturer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			rect := image.Rect(2, -1, 6, 2)
+			// Capture twice over the same connection, which reuses the ids released by the compositor.
+			for i := 0; i < 2; i++ {
+				img, err := c.CaptureRect(rect)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for y := rect.Min.Y; y < rect.Max.Y; y++ {
+					for x := rect.Min.X; x < rect.Max.X; x++ {
+						var want color.RGBA
+						if x < 4 && y >= 0 {
+							want = fakePixel(0, x, y)
+						} else if x >= 4 {
+							want = fakePixel(1, x-4, y+1)
+						}
+						if got := img.RGBAAt(x-rect.Min.X, y-rect.Min.Y); got != want {
+							t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+						}
+					}
+				}
+			}
+			// The buffer of each output is kept for the second capture.
+			if n := f.pools.Load(); n != 2 {
+				t.Errorf("%d wl_shm_pool objects were created for 2 outputs", n)
+			}
+		})
+	}
+}
+
+func TestWaylandScaledOutputs(t *testing.T) {
+	for _, xdgOutput := range []bool{true, false} {
+		t.Run(fmt.Sprintf("xdgOutput=%v", xdgOutput), func(t *testing.T) {
+			f := newFakeCompositor(t)
+			f.outputs = []fakeOutput{
+				{name: "eDP-1", position: image.Pt(0, 0), size: image.Pt(8, 4), scale: 2},
+				{name: "DP-1", position: image.Pt(4, 0), size: image.Pt(2, 3), scale: 1},
+			}
+			f.screencopy = true
+			f.xdgOutput = xdgOutput
+			f.listen()
+
+			// The compositor space is scaled by 2, so that the first output keeps all its pixels.
+			displays, err := Displays()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := displays[0]; d.Bounds != image.Rect(0, 0, 8, 4) || d.ScaleFactor != 2 {
+				t.Errorf("Displays()[0] = %+v", d)
+			}
+			if d := displays[1]; d.Bounds != image.Rect(8, 0, 12, 6) || d.ScaleFactor != 1 {
+				t.Errorf("Displays()[1] = %+v", d)
+			}
+
+			// The second output is enlarged to its bounds.
+			rect := image.Rect(6, 0, 12, 6)
+			img, err := CaptureRect(rect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					var want color.RGBA
+					if x >= 8 {
+						want = fakePixel(1, (x-8)/2, y/2)
+					} else if y < 4 {
+						want = fakePixel(0, x, y)
+					}
+					if got := img.RGBAAt(x-rect.Min.X, y-rect.Min.Y); got != want {
+						t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWlrootsRotatedOutput(t *testing.T) {
+	for _, protocol := range []string{"screencopy", "ext-image-copy-capture"} {
+		t.Run(protocol, func(t *testing.T) {
+			f := newFakeCompositor(t)
+			f.outputs = []fakeOutput{{name: "DP-1", size: image.Pt(4, 2), transform: 1}}
+			f.screencopy = protocol == "screencopy"
+			f.imageCopy = !f.screencopy
+			f.xdgOutput = true
+			f.listen()
+
+			displays, err := Displays()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := displays[0]; d.Bounds != image.Rect(0, 0, 2, 4) || d.Rotation != Rotate270 {
+				t.Errorf("Displays()[0] = %+v", d)
+			}
+			img, err := CaptureDisplay(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds() != image.Rect(0, 0, 2, 4) {
+				t.Fatalf("CaptureDisplay(0) has bounds %v", img.Bounds())
+			}
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 2; x++ {
+					if got, want := img.RGBAAt(x, y), fakePixel(0, x, y); got != want {
+						t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWlrootsOutputChanges(t *testing.T) {
+	f := newFakeCompositor(t)
+	f.screencopy = true
+	f.listen()
+
+	c, err := NewCapturer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	names := func() []string {
+		t.Helper()
+		displays, err := c.Displays()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, d := range displays {
+			names = append(names, fmt.Sprintf("%s %v", d.Name, d.Bounds))
+		}
+		return names
+	}
+	check := func(what string, want ...string) {
+		t.Helper()
+		if got := names(); strings.Join(got, ", ") != strings.Join(want, ", ") {
+			t.Errorf("Displays() after %s = %q, want %q", what, got, want)
+		}
+	}
+	check("connecting", "DP-1 (0,0)-(4,2)", "HDMI-A-1 (4,-1)-(6,2)")
+
+	f.change(func(c *fakeClient) {
+		f.outputs = append(f.outputs, fakeOutput{name: "DP-2", position: image.Pt(106, 50), size: image.Pt(3, 2)})
+		c.announceOutput(2)
+	})
+	check("plugging DP-2", "DP-1 (0,0)-(4,2)", "HDMI-A-1 (4,-1)-(6,2)", "DP-2 (6,0)-(9,2)")
+
+	f.change(func(c *fakeClient) {
+		c.event(c.registry, wlRegistryEventGlobalRemove, func(w *wlWriter) {
+			w.uint(fakeOutputGlobal + 1)
+		})
+	})
+	check("unplugging HDMI-A-1", "DP-1 (0,0)-(4,2)", "DP-2 (6,0)-(9,2)")
+
+	f.change(func(c *fakeClient) {
+		f.outputs[0].size = image.Pt(6, 3)
+		c.sendOutput(c.outputId(0), f.outputs[0])
+	})
+	check("changing the mode of DP-1", "DP-1 (0,0)-(6,3)", "DP-2 (6,0)-(9,2)")
+
+	// The captures follow the new layout.
+	img, err := c.CaptureRect(image.Rect(0, 0, 9, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 9; x++ {
+			var want color.RGBA
+			if x < 6 {
+				want = fakePixel(0, x, y)
+			} else if y < 2 {
+				want = fakePixel(2, x-6, y)
+			}
+			if got := img.RGBAAt(x, y); got != want {
+				t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestWlrootsCaptureBGRA(t *testing.T) {
+	f := newFakeCompositor(t)
+	f.screencopy = true
+	f.listen()
+
+	c, err := NewCapturer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// The backend produces RGBA, which is swapped into BGRA, and returned as is in a Frame.
+	rect := image.Rect(1, 0, 3, 2)
+	img, err := c.CaptureBGRA(rect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := c.CaptureFrame(rect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Format != PixelFormatRGBA || frame.Width != 2 || frame.Height != 2 {
+		t.Errorf("CaptureFrame() = %v frame of %dx%d, want RGBA 2x2", frame.Format, frame.Width, frame.Height)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			want := fakePixel(0, x, y)
+			if got := img.RGBAAt(x-rect.Min.X, y-rect.Min.Y); got != want {
+				t.Errorf("BGRA pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+			if got := frame.Image().At(x-rect.Min.X, y-rect.Min.Y); got != want {
+				t.Errorf("frame pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestWlrootsCaptureYUV(t *testing.T) {
+	if _, err := NewCapturer(WithYUV(PixelFormatBGRA, YUVOptions{})); err == nil {
+		t.Error("NewCapturer(WithYUV(PixelFormatBGRA)) succeeded, want an error")
+	}
+
+	f := newFakeCompositor(t)
+	f.screencopy = true
+	f.listen()
+
+	opts := YUVOptions{Matrix: BT709}
+	c, err := NewCapturer(WithYUV(PixelFormatNV12, opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	rect := image.Rect(1, 0, 4, 2)
+	frame, err := c.CaptureFrame(rect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Format != PixelFormatNV12 || frame.Width != 3 || frame.Height != 2 || frame.YUV != opts {
+		t.Fatalf("CaptureFrame() = %v frame of %dx%d with %+v, want NV12 3x2 with %+v", frame.Format, frame.Width, frame.Height, frame.YUV, opts)
+	}
+	coef := opts.coefficients()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			p := fakePixel(0, x, y)
+			if got, want := frame.Pix[(y-rect.Min.Y)*frame.Stride+x-rect.Min.X], coef.luma(p.R, p.G, p.B); got != want {
+				t.Errorf("Y of (%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+	// The last column of an odd width makes a block of its own.
+	p, q := fakePixel(0, 3, 0), fakePixel(0, 3, 1)
+	cb, cr := coef.chroma(int32(p.R)+int32(q.R), int32(p.G)+int32(q.G), int32(p.B)+int32(q.B), 2)
+	if got := frame.Cb[2:4]; got[0] != cb || got[1] != cr {
+		t.Errorf("chroma of the last block = %v, want [%d %d]", got, cb, cr)
+	}
+}
+
+func TestBackendFallback(t *testing.T) {
+	f := newFakeCompositor(t)
+	f.listen()
+	t.Setenv("DISPLAY", "")
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+filepath.Join(t.TempDir(), "bus"))
+	t.Setenv("SCREENSHOT_BACKEND", "")
+
+	// The compositor lacks the screencopy protocols, and there is neither a portal nor an X server.
+	_, err := CurrentBackend()
+	if !errors.Is(err, ErrNoBackend) || !errors.Is(err, ErrExtensionMissing) {
+		t.Errorf("CurrentBackend() = %v, want ErrNoBackend and ErrExtensionMissing", err)
+	}
+	for _, b := range Backends() {
+		if !strings.Contains(err.Error(), b.Name()+":") && b != backendScreenCast {
+			t.Errorf("CurrentBackend() = %v, which does not tell why %s is unavailable", err, b.Name())
+		}
+	}
+
+	f = newFakeCompositor(t)
+	f.screencopy = true
+	f.listen()
+	if b, err := CurrentBackend(); err != nil || b.Name() != "wlr-screencopy" {
+		t.Errorf("CurrentBackend() = %v, %v, want wlr-screencopy", b, err)
+	}
+}
+
+func TestBackendSelection(t *testing.T) {
+	f := newFakeCompositor(t)
+	f.screencopy = true
+	f.listen()
+	t.Setenv("DISPLAY", "")
+
+	t.Setenv("SCREENSHOT_BACKEND", "x11-getimage")
+	if _, err := CurrentBackend(); !errors.Is(err, ErrNoDisplay) {
+		t.Errorf("CurrentBackend() = %v, want ErrNoDisplay", err)
+	}
+	if err := CaptureInto(image.NewRGBA(image.Rect(0, 0, 1, 1)), image.Rect(0, 0, 1, 1)); !errors.Is(err, ErrNoDisplay) {
+		t.Errorf("CaptureInto() = %v, want ErrNoDisplay", err)
+	}
+
+	// The option takes precedence over the environment variable.
+	c, err := NewCapturer(WithBackend("wlr-screencopy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if b, err := c.Backend(); err != nil || b.Name() != "wlr-screencopy" {
+		t.Errorf("Backend() = %v, %v, want wlr-screencopy", b, err)
+	}
+
+	c, err = NewCapturer(WithBackend("gdi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.CaptureRect(image.Rect(0, 0, 1, 1)); !errors.Is(err, ErrNoBackend) {
+		t.Errorf("CaptureRect() = %v, want ErrNoBackend", err)
+	}
+}
@@ -0,0 +1,361 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// xAuthMagicCookie is the only authorization protocol supported, like libxcb does by default.
+const xAuthMagicCookie = "MIT-MAGIC-COOKIE-1"
+
+// The address families of the entries of an Xauthority file, see Xauth.h.
+const (
+	xAuthFamilyInternet  = 0
+	xAuthFamilyInternet6 = 6
+	xAuthFamilyLocal     = 256
+	xAuthFamilyWild      = 65535
+)
+
+func init() {
+	// xgb logs to stderr when a read fails, which is how abortXOnDone interrupts the requests of a
+	// cancelled capture, and when it finds no authorization of its own, see newXConn. The failures
+	// reach the caller as errors anyway.
+	xgb.Logger = log.New(io.Discard, "", 0)
+}
+
+// xDisplayName is a parsed display string, [protocol/][host]:display[.screen].
+type xDisplayName struct {
+	network, address string
+	host             string // empty for a local connection
+	number           string
+	screen           int
+}
+
+// parseXDisplay parses display the way Xlib does, see XOpenDisplay(3).
+func parseXDisplay(display string) (xDisplayName, error) {
+	var d xDisplayName
+	bad := fmt.Errorf("%w: bad display string %q", ErrNoDisplay, display)
+	colon := strings.LastIndex(display, ":")
+	if colon < 0 {
+		return d, bad
+	}
+	var protocol, socket string
+	if strings.HasPrefix(display, "/") {
+		// A socket path, as set by launchd on macOS.
+		socket = display[:colon]
+	} else if slash := strings.LastIndex(display[:colon], "/"); slash >= 0 {
+		protocol, d.host = display[:slash], display[slash+1:colon]
+	} else {
+		d.host = display[:colon]
+	}
+	d.number = display[colon+1:]
+	if dot := strings.LastIndex(d.number, "."); dot >= 0 {
+		screen, err := strconv.Atoi(d.number[dot+1:])
+		if err != nil || screen < 0 {
+			return d, bad
+		}
+		d.number, d.screen = d.number[:dot], screen
+	}
+	number, err := strconv.Atoi(d.number)
+	if err != nil || number < 0 {
+		return d, bad
+	}
+	switch {
+	case socket != "":
+		d.network, d.address = "unix", socket+":"+d.number
+	case d.host != "" && d.host != "unix":
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		d.network, d.address = protocol, net.JoinHostPort(d.host, strconv.Itoa(6000+number))
+	default:
+		d.host = ""
+		d.network, d.address = "unix", "/tmp/.X11-unix/X"+d.number
+	}
+	return d, nil
+}
+
+// dialX connects to the X server selected by the options, or by $DISPLAY and $XAUTHORITY when they
+// select none, and returns the connection, whose DefaultScreen is the X screen to capture, along with the socket beneath it. Unlike xgb.NewConnDisplay,
+// the authorization is looked up and sent here, so that $DISPLAY is left alone and connections to
+// several servers can be opened concurrently. The connection is abandoned once ctx is done.
+func dialX(ctx context.Context, opts options) (*xgb.Conn, net.Conn, error) {
+	display := opts.xDisplay
+	if display == "" {
+		display = os.Getenv("DISPLAY")
+	}
+	d, err := parseXDisplay(display)
+	if err != nil {
+		return nil, nil, err
+	}
+	cookie := opts.xCookie
+	if cookie == nil {
+		cookie, err = readXAuthority(opts.xAuthority, d)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrNoDisplay, err)
+		}
+	}
+	var dialer net.Dialer
+	nc, err := dialer.DialContext(ctx, d.network, d.address)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, fmt.Errorf("%w: cannot connect to %s: %w", ErrNoDisplay, display, err)
+	}
+	stop := abortXOnDone(ctx, nc)
+	c, err := newXConn(nc, cookie)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		nc.Close()
+		return nil, nil, ctx.Err()
+	}
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("%w: %s: %w", ErrNoDisplay, display, err)
+	}
+	screen := d.screen
+	if opts.xScreenSet {
+		screen = opts.xScreen
+	}
+	if n := len(xproto.Setup(c).Roots); screen >= n {
+		c.Close()
+		return nil, nil, fmt.Errorf("%w: %s: no screen %d, the X server has %d screens", ErrNoDisplay, display, screen, n)
+	}
+	c.DisplayNumber, _ = strconv.Atoi(d.number)
+	c.DefaultScreen = screen
+	return c, nc, nil
+}
+
+// abortXOnDone makes the reads and writes of nc fail once ctx is done, which interrupts the requests
+// waiting for an X server which does not answer, silently since xgb does not log, see init. The
+// connection is unusable afterwards. The returned function stops watching ctx, and reports false if it
+// is too late.
+func abortXOnDone(ctx context.Context, nc net.Conn) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		_ = nc.SetDeadline(time.Unix(1, 0))
+	})
+}
+
+// xAuthorityMu guards $XAUTHORITY, which newXConn swaps while xgb looks it up.
+var xAuthorityMu sync.RWMutex
+
+// newXConn completes the connection setup over nc with the authorization cookie, or none if cookie is
+// nil, and returns the xgb connection. xgb sends a setup of its own, with the authorization it looks up
+// by itself in $XAUTHORITY, without taking the display number into account, and fails before sending
+// anything when the entry it finds is not a MIT-MAGIC-COOKIE-1. Its lookup is pointed at an empty file,
+// and its setup is answered with the reply already received instead of reaching the X server.
+func newXConn(nc net.Conn, cookie []byte) (*xgb.Conn, error) {
+	reply, err := setupX(nc, cookie)
+	if err != nil {
+		return nil, err
+	}
+	xAuthorityMu.Lock()
+	defer xAuthorityMu.Unlock()
+	prev, set := os.LookupEnv("XAUTHORITY")
+	if err := os.Setenv("XAUTHORITY", os.DevNull); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if set {
+			_ = os.Setenv("XAUTHORITY", prev)
+		} else {
+			_ = os.Unsetenv("XAUTHORITY")
+		}
+	}()
+	return xgb.NewConnNet(&xSetupConn{Conn: nc, reply: reply})
+}
+
+// setupX sends the connection setup request to the X server, and returns its reply if it accepts the
+// connection.
+func setupX(nc net.Conn, cookie []byte) ([]byte, error) {
+	var name []byte
+	if cookie != nil {
+		name = []byte(xAuthMagicCookie)
+	}
+	req := make([]byte, 12+xpad(len(name))+xpad(len(cookie)))
+	req[0] = 'l' // little endian
+	binary.LittleEndian.PutUint16(req[2:], 11)
+	binary.LittleEndian.PutUint16(req[6:], uint16(len(name)))
+	binary.LittleEndian.PutUint16(req[8:], uint16(len(cookie)))
+	copy(req[12:], name)
+	copy(req[12+xpad(len(name)):], cookie)
+	if _, err := nc.Write(req); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(nc, reply); err != nil {
+		return nil, err
+	}
+	reply = append(reply, make([]byte, 4*int(binary.LittleEndian.Uint16(reply[6:])))...)
+	if _, err := io.ReadFull(nc, reply[8:]); err != nil {
+		return nil, err
+	}
+	switch reply[0] {
+	case 1:
+		return reply, nil
+	case 0:
+		reason := reply[8:]
+		if n := int(reply[1]); n < len(reason) {
+			reason = reason[:n]
+		}
+		return nil, fmt.Errorf("the X server refused the connection: %s", reason)
+	default:
+		return nil, fmt.Errorf("the X server requires further authentication: %s", bytes.TrimRight(reply[8:], "\x00"))
+	}
+}
+
+// xSetupConn answers the connection setup request written by xgb with reply, without passing the
+// request on. The rest of the connection goes through.
+type xSetupConn struct {
+	net.Conn
+	reply []byte
+	setup bool // the setup request was written
+}
+
+func (c *xSetupConn) Write(b []byte) (int, error) {
+	// xgb writes the setup request in one call, before the goroutine sending the other requests starts.
+	if !c.setup {
+		c.setup = true
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *xSetupConn) Read(b []byte) (int, error) {
+	// The reply is read in full before the goroutine reading the responses starts.
+	if len(c.reply) > 0 {
+		n := copy(b, c.reply)
+		c.reply = c.reply[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// xpad rounds n up to a multiple of 4, the alignment of the X protocol.
+func xpad(n int) int {
+	return (n + 3) &^ 3
+}
+
+// readXAuthority returns the MIT-MAGIC-COOKIE-1 of the display d found in the Xauthority file at path,
+// or else at $XAUTHORITY or ~/.Xauthority. It returns nil if there is none, in which case the connection
+// is attempted without authorization, e.g. for an Xvfb started without -auth.
+func readXAuthority(path string, d xDisplayName) ([]byte, error) {
+	explicit := path != ""
+	if path == "" {
+		xAuthorityMu.RLock()
+		path = os.Getenv("XAUTHORITY")
+		xAuthorityMu.RUnlock()
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	match, err := xAuthAddressMatcher(d)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	for {
+		var family uint16
+		err := binary.Read(r, binary.BigEndian, &family)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("screenshot: %s: %w", path, err)
+		}
+		var fields [4][]byte // address, display number, name and data
+		for i := range fields {
+			fields[i], err = readXAuthField(r)
+			if err != nil {
+				return nil, fmt.Errorf("screenshot: %s: %w", path, err)
+			}
+		}
+		addr, number, name, data := fields[0], string(fields[1]), string(fields[2]), fields[3]
+		if name == xAuthMagicCookie && (number == "" || number == d.number) && match(family, addr) {
+			return data, nil
+		}
+	}
+}
+
+func readXAuthField(r io.Reader) ([]byte, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, noEOF(err)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, noEOF(err)
+	}
+	return b, nil
+}
+
+// noEOF turns the end of the file in the middle of an entry into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// xAuthAddressMatcher returns a function reporting whether an Xauthority entry of the given family
+// and address applies to the host of d. Local connections match the entries of this host, and TCP
+// connections those of the addresses of the remote host.
+func xAuthAddressMatcher(d xDisplayName) (func(family uint16, addr []byte) bool, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	local := d.host == "" || d.host == "localhost" || d.host == hostname
+	var ips []net.IP
+	if !local {
+		ips, _ = net.LookupIP(d.host)
+	}
+	return func(family uint16, addr []byte) bool {
+		switch family {
+		case xAuthFamilyWild:
+			return true
+		case xAuthFamilyLocal:
+			return local && string(addr) == hostname
+		case xAuthFamilyInternet, xAuthFamilyInternet6:
+			for _, ip := range ips {
+				if ip.Equal(net.IP(addr)) {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,327 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/jezek/xgb/xproto"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseXDisplay(t *testing.T) {
+	tests := []struct {
+		display string
+		want    xDisplayName
+	}{
+		{":1", xDisplayName{network: "unix", address: "/tmp/.X11-unix/X1", number: "1"}},
+		{"unix:99.2", xDisplayName{network: "unix", address: "/tmp/.X11-unix/X99", number: "99", screen: 2}},
+		{"localhost:10.0", xDisplayName{network: "tcp", address: "localhost:6010", host: "localhost", number: "10"}},
+		{"tcp/farm-3:2", xDisplayName{network: "tcp", address: "farm-3:6002", host: "farm-3", number: "2"}},
+		{"/tmp/launch-12/org.x:0", xDisplayName{network: "unix", address: "/tmp/launch-12/org.x:0", number: "0"}},
+	}
+	for _, tt := range tests {
+		got, err := parseXDisplay(tt.display)
+		if err != nil {
+			t.Errorf("parseXDisplay(%q) failed: %v", tt.display, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseXDisplay(%q) = %+v, want %+v", tt.display, got, tt.want)
+		}
+	}
+	for _, display := range []string{"", "foo", ":", ":x", ":1.x", ":-1"} {
+		if _, err := parseXDisplay(display); !errors.Is(err, ErrNoDisplay) {
+			t.Errorf("parseXDisplay(%q) = %v, want ErrNoDisplay", display, err)
+		}
+	}
+}
+
+// xAuthEntry encodes an entry of an Xauthority file.
+func xAuthEntry(family uint16, addr, number, name string, data []byte) []byte {
+	var b []byte
+	b = binary.BigEndian.AppendUint16(b, family)
+	for _, field := range [][]byte{[]byte(addr), []byte(number), []byte(name), data} {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(field)))
+		b = append(b, field...)
+	}
+	return b
+}
+
+func TestReadXAuthority(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie1, cookie99 := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{99}, 16)
+	var file []byte
+	file = append(file, xAuthEntry(xAuthFamilyLocal, "elsewhere", "1", xAuthMagicCookie, []byte("wrong host"))...)
+	file = append(file, xAuthEntry(xAuthFamilyLocal, hostname, "1", "XDM-AUTHORIZATION-1", []byte("wrong name"))...)
+	file = append(file, xAuthEntry(xAuthFamilyLocal, hostname, "1", xAuthMagicCookie, cookie1)...)
+	file = append(file, xAuthEntry(xAuthFamilyWild, "", "99", xAuthMagicCookie, cookie99)...)
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, file, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		display string
+		want    []byte
+	}{{":1", cookie1}, {"unix:99", cookie99}, {":2", nil}} {
+		d, err := parseXDisplay(tt.display)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readXAuthority(path, d)
+		if err != nil {
+			t.Fatalf("readXAuthority(%q) failed: %v", tt.display, err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("readXAuthority(%q) = %v, want %v", tt.display, got, tt.want)
+		}
+	}
+
+	// A truncated file is an error, and so is a missing file given explicitly.
+	if err := os.WriteFile(path, file[:len(file)-3], 0600); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := parseXDisplay(":2")
+	if _, err := readXAuthority(path, d); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("readXAuthority of a truncated file = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if _, err := readXAuthority(path+".missing", d); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readXAuthority of a missing file = %v, want os.ErrNotExist", err)
+	}
+}
+
+// fakeXServer accepts a connection on a socket, and refuses its setup after recording the
+// authorization it carries.
+func fakeXServer(t *testing.T) (display string, auth <-chan [2]string) {
+	dir := t.TempDir()
+	ln, err := net.Listen("unix", filepath.Join(dir, "X:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan [2]string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		head := make([]byte, 12)
+		if _, err := io.ReadFull(conn, head); err != nil {
+			t.Error(err)
+			return
+		}
+		nameLen, dataLen := binary.LittleEndian.Uint16(head[6:]), binary.LittleEndian.Uint16(head[8:])
+		body := make([]byte, xpad(int(nameLen))+xpad(int(dataLen)))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			t.Error(err)
+			return
+		}
+		ch <- [2]string{string(body[:nameLen]), string(body[xpad(int(nameLen)):][:dataLen])}
+		reason := "test"
+		reply := []byte{0, byte(len(reason)), 11, 0, 0, 0, 2, 0}
+		_, _ = conn.Write(append(reply, reason+"\x00\x00\x00\x00"...))
+	}()
+	return filepath.Join(dir, "X:0"), ch
+}
+
+func TestDialXAuthorization(t *testing.T) {
+	// Two servers with different cookies are reached at the same time, whatever the environment says.
+	t.Setenv("DISPLAY", ":12345")
+	t.Setenv("XAUTHORITY", filepath.Join(t.TempDir(), "missing"))
+	cookies := [][]byte{bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16)}
+	errs := make(chan error, len(cookies))
+	var auths []<-chan [2]string
+	for _, cookie := range cookies {
+		display, auth := fakeXServer(t)
+		auths = append(auths, auth)
+		go func(cookie []byte) {
+			_, _, err := dialX(context.Background(), options{xDisplay: display, xCookie: cookie})
+			errs <- err
+		}(cookie)
+	}
+	for i, cookie := range cookies {
+		got := <-auths[i]
+		if got != [2]string{xAuthMagicCookie, string(cookie)} {
+			t.Errorf("server %d got authorization %q, want the cookie %v", i, got, cookie)
+		}
+	}
+	for range cookies {
+		err := <-errs
+		if !errors.Is(err, ErrNoDisplay) || !strings.Contains(err.Error(), "test") {
+			t.Errorf("dialX() = %v, want ErrNoDisplay with the reason of the server", err)
+		}
+	}
+
+	// Without cookie nor Xauthority entry, no authorization is sent.
+	display, auth := fakeXServer(t)
+	if _, _, err := dialX(context.Background(), options{xDisplay: display}); err == nil {
+		t.Error("dialX() succeeded, want the refusal of the server")
+	}
+	if got := <-auth; got != [2]string{} {
+		t.Errorf("server got authorization %q, want none", got)
+	}
+
+	// Only the entry of the display is sent, even after entries which xgb would pick and reject.
+	cookie := bytes.Repeat([]byte{3}, 16)
+	var file []byte
+	file = append(file, xAuthEntry(xAuthFamilyWild, "", "", "XDM-AUTHORIZATION-1", []byte("not a cookie"))...)
+	file = append(file, xAuthEntry(xAuthFamilyWild, "", "12345", xAuthMagicCookie, bytes.Repeat([]byte{4}, 16))...)
+	file = append(file, xAuthEntry(xAuthFamilyWild, "", "0", xAuthMagicCookie, cookie)...)
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XAUTHORITY", path)
+	display, auth = fakeXServer(t)
+	if _, _, err := dialX(context.Background(), options{xDisplay: display}); err == nil || !strings.Contains(err.Error(), "test") {
+		t.Fatalf("dialX() = %v, want the refusal of the server", err)
+	}
+	if got := <-auth; got != [2]string{xAuthMagicCookie, string(cookie)} {
+		t.Errorf("server got authorization %q, want the cookie %v", got, cookie)
+	}
+	c, _, err := dialX(context.Background(), options{xDisplay: fakeXScreens(t, 1)})
+	if err != nil {
+		t.Fatalf("dialX() failed: %v", err)
+	}
+	c.Close()
+	if got := os.Getenv("XAUTHORITY"); got != path {
+		t.Errorf("$XAUTHORITY = %q after dialX, want %q", got, path)
+	}
+}
+
+// fakeXScreens accepts connections on a socket, and completes their setup with an X server of the given
+// number of screens.
+func fakeXScreens(t *testing.T, screens int) (display string) {
+	path := filepath.Join(t.TempDir(), "X:0")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	setup := xproto.SetupInfo{Status: 1, ProtocolMajorVersion: 11, RootsLen: byte(screens), Roots: make([]xproto.ScreenInfo, screens)}
+	reply := setup.Bytes()
+	binary.LittleEndian.PutUint16(reply[6:], uint16((len(reply)-8)/4))
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				head := make([]byte, 12)
+				if _, err := io.ReadFull(conn, head); err != nil {
+					return
+				}
+				nameLen, dataLen := binary.LittleEndian.Uint16(head[6:]), binary.LittleEndian.Uint16(head[8:])
+				if _, err := io.CopyN(io.Discard, conn, int64(xpad(int(nameLen))+xpad(int(dataLen)))); err != nil {
+					return
+				}
+				if _, err := conn.Write(reply); err != nil {
+					return
+				}
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return path
+}
+
+func TestDialXScreen(t *testing.T) {
+	t.Setenv("XAUTHORITY", filepath.Join(t.TempDir(), "missing"))
+	display := fakeXScreens(t, 2)
+	for _, tt := range []struct {
+		opts options
+		want int
+	}{
+		{options{xDisplay: display}, 0},
+		{options{xDisplay: display + ".1"}, 1},
+		{options{xDisplay: display + ".1", xScreen: 0, xScreenSet: true}, 0},
+		{options{xDisplay: display, xScreen: 1, xScreenSet: true}, 1},
+	} {
+		c, _, err := dialX(context.Background(), tt.opts)
+		if err != nil {
+			t.Errorf("dialX(%+v) failed: %v", tt.opts, err)
+			continue
+		}
+		if c.DefaultScreen != tt.want {
+			t.Errorf("dialX(%+v) selected the screen %d, want %d", tt.opts, c.DefaultScreen, tt.want)
+		}
+		c.Close()
+	}
+	if _, _, err := dialX(context.Background(), options{xDisplay: display, xScreen: 2, xScreenSet: true}); !errors.Is(err, ErrNoDisplay) {
+		t.Errorf("dialX() of the third screen = %v, want ErrNoDisplay", err)
+	}
+	if _, err := NewCapturer(WithXScreen(-1)); err == nil {
+		t.Error("NewCapturer(WithXScreen(-1)) succeeded")
+	}
+}
+
+func TestDialXContext(t *testing.T) {
+	// A server which accepts the connection, and never answers the setup.
+	path := filepath.Join(t.TempDir(), "X:0")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	t.Setenv("XAUTHORITY", filepath.Join(t.TempDir(), "missing"))
+	c, err := NewCapturer(WithXDisplay(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.DisplaysContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DisplaysContext() = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("DisplaysContext() returned after %v", elapsed)
+	}
+}
+
+func TestWithXWindowShmFallback(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		s := &session{backend: backendX11Shm, x: &xSession{useShm: true}}
+		err := s.withXWindow(context.Background(), func(xs *xSession) error {
+			// As shmBuffer does when the X server cannot attach the segment.
+			xs.useShm = !fallback
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := backendX11Shm
+		if fallback {
+			want = backendX11GetImage
+		}
+		if s.backend != want {
+			t.Errorf("fallback %v: backend = %s, want %s", fallback, s.backend.Name(), want.Name())
+		}
+	}
+}
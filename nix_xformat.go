@@ -0,0 +1,204 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jezek/xgb/xproto"
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+var errShortImage = errors.New("screenshot: the X server returned less image data than expected")
+
+// xPixelFormat is the layout of the ZPixmap images of a visual: the bits per pixel and the scanline
+// padding of the pixmap format of its depth, the image byte order of the server, and either the
+// channel masks of the visual or, for indexed visuals, the colors of the colormap.
+type xPixelFormat struct {
+	depth            int
+	bitsPerPixel     int
+	scanlinePad      int // in bits
+	msbFirst         bool
+	red, green, blue xChannel
+	indexed          bool
+	colormapEntries  int
+	palette          []color.RGBA // colors of the pixel values of an indexed visual
+}
+
+// xChannel extracts a color channel from a pixel value.
+type xChannel struct {
+	mask  uint32
+	shift int
+	max   uint32 // the largest value of the channel, once shifted
+}
+
+func newXChannel(mask uint32) xChannel {
+	if mask == 0 {
+		return xChannel{}
+	}
+	shift := bits.TrailingZeros32(mask)
+	return xChannel{mask: mask, shift: shift, max: mask >> shift}
+}
+
+// value scales the channel of pixel to 8 bits.
+func (c xChannel) value(pixel uint32) uint8 {
+	if c.max == 0 {
+		return 0
+	}
+	v := (pixel & c.mask) >> c.shift
+	return uint8((uint64(v)*255 + uint64(c.max)/2) / uint64(c.max))
+}
+
+// newXPixelFormat looks up the format of visual in the connection setup. The palette of an indexed
+// visual is left for the caller to fill.
+func newXPixelFormat(setup *xproto.SetupInfo, visual xproto.Visualid) (*xPixelFormat, error) {
+	var info *xproto.VisualInfo
+	depth := 0
+	for _, screen := range setup.Roots {
+		for _, d := range screen.AllowedDepths {
+			for i := range d.Visuals {
+				if d.Visuals[i].VisualId == visual {
+					info = &d.Visuals[i]
+					depth = int(d.Depth)
+				}
+			}
+		}
+	}
+	if info == nil {
+		return nil, fmt.Errorf("screenshot: visual %#x is not supported by the X server", visual)
+	}
+
+	f := &xPixelFormat{
+		depth:    depth,
+		msbFirst: setup.ImageByteOrder == xproto.ImageOrderMSBFirst,
+	}
+	for _, pf := range setup.PixmapFormats {
+		if int(pf.Depth) == depth {
+			f.bitsPerPixel = int(pf.BitsPerPixel)
+			f.scanlinePad = int(pf.ScanlinePad)
+		}
+	}
+	switch f.bitsPerPixel {
+	case 8, 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("%w: %d bits per pixel at depth %d", ErrUnsupported, f.bitsPerPixel, depth)
+	}
+	if f.scanlinePad == 0 || f.scanlinePad%8 != 0 {
+		return nil, fmt.Errorf("%w: scanline pad of %d bits", ErrUnsupported, f.scanlinePad)
+	}
+
+	switch info.Class {
+	case xproto.VisualClassTrueColor, xproto.VisualClassDirectColor:
+		// The colormap of a DirectColor visual is assumed to be the identity.
+		f.red = newXChannel(info.RedMask)
+		f.green = newXChannel(info.GreenMask)
+		f.blue = newXChannel(info.BlueMask)
+	default:
+		f.indexed = true
+		f.colormapEntries = min(int(info.ColormapEntries), 1<<min(depth, 16))
+	}
+	return f, nil
+}
+
+// stride returns the number of bytes of a scanline of width pixels.
+func (f *xPixelFormat) stride(width int) int {
+	pad := f.scanlinePad / 8
+	return (width*f.bitsPerPixel/8 + pad - 1) / pad * pad
+}
+
+// isBGRX reports whether the pixels are 32-bit words with 8 bits per channel, stored as B, G, R, X
+// bytes, which is the layout of nearly every X server and has a fast path.
+func (f *xPixelFormat) isBGRX() bool {
+	return f.bitsPerPixel == 32 && !f.msbFirst && !f.indexed &&
+		f.red.mask == 0xff0000 && f.green.mask == 0xff00 && f.blue.mask == 0xff
+}
+
+// pixel reads the pixel value at the start of b.
+func (f *xPixelFormat) pixel(b []byte) uint32 {
+	var v uint32
+	n := f.bitsPerPixel / 8
+	if f.msbFirst {
+		for i := 0; i < n; i++ {
+			v = v<<8 | uint32(b[i])
+		}
+	} else {
+		for i := n - 1; i >= 0; i-- {
+			v = v<<8 | uint32(b[i])
+		}
+	}
+	return v
+}
+
+// convert draws the ZPixmap image data, width by height pixels, into dst with its top-left corner at p.
+// The pixels of dst are written in the BGRA byte order if bgra is set.
+func (f *xPixelFormat) convert(dst *image.RGBA, bgra bool, p image.Point, data []byte, width, height int) error {
+	stride := f.stride(width)
+	if len(data) < stride*(height-1)+width*f.bitsPerPixel/8 {
+		return errShortImage
+	}
+	if f.isBGRX() {
+		op := pixelSwap | pixelOpaque
+		if bgra {
+			op = pixelOpaque
+		}
+		convertPixels(dst.Pix[dst.PixOffset(p.X, p.Y):], dst.Stride, data, stride, width, height, op)
+		return nil
+	}
+	bpp := f.bitsPerPixel / 8
+	for y := 0; y < height; y++ {
+		src := data[y*stride:]
+		i := dst.PixOffset(p.X, p.Y+y)
+		row := dst.Pix[i : i+4*width]
+		for x := 0; x < width; x++ {
+			v := f.pixel(src[bpp*x:])
+			var c color.RGBA
+			if f.indexed {
+				if int(v) < len(f.palette) {
+					c = f.palette[v]
+				} else {
+					c = color.RGBA{A: 255}
+				}
+			} else {
+				c = color.RGBA{f.red.value(v), f.green.value(v), f.blue.value(v), 255}
+			}
+			if bgra {
+				c.R, c.B = c.B, c.R
+			}
+			d := row[4*x : 4*x+4]
+			d[0], d[1], d[2], d[3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return nil
+}
+
+// pixelFormat returns the format of the images of drawables of visual. The colors of an indexed
+// visual are read from colormap, on each call since a client may change them at any time.
+func (s *xSession) pixelFormat(visual xproto.Visualid, colormap xproto.Colormap) (*xPixelFormat, error) {
+	f, err := newXPixelFormat(xproto.Setup(s.conn), visual)
+	if err != nil {
+		return nil, err
+	}
+	if !f.indexed {
+		return f, nil
+	}
+	pixels := make([]uint32, f.colormapEntries)
+	for i := range pixels {
+		pixels[i] = uint32(i)
+	}
+	reply, err := xproto.QueryColors(s.conn, colormap, pixels).Reply()
+	if err != nil {
+		return nil, err
+	}
+	f.palette = make([]color.RGBA, len(reply.Colors))
+	for i, c := range reply.Colors {
+		f.palette[i] = color.RGBA{uint8(c.Red >> 8), uint8(c.Green >> 8), uint8(c.Blue >> 8), 255}
+	}
+	return f, nil
+}
+
+// rootFormat returns the format of the images of the root window.
+func (s *xSession) rootFormat() (*xPixelFormat, error) {
+	return s.pixelFormat(s.screen.RootVisual, s.screen.DefaultColormap)
+}
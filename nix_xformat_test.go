@@ -0,0 +1,165 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"errors"
+	"github.com/jezek/xgb/xproto"
+	"image"
+	"image/color"
+	"testing"
+)
+
+const fakeVisual = 0x21
+
+// fakeSetup returns a connection setup with a single visual, fakeVisual.
+func fakeSetup(byteOrder byte, depth, bpp, pad byte, class byte, red, green, blue uint32) *xproto.SetupInfo {
+	return &xproto.SetupInfo{
+		ImageByteOrder: byteOrder,
+		PixmapFormats: []xproto.Format{
+			{Depth: 1, BitsPerPixel: 1, ScanlinePad: 32},
+			{Depth: depth, BitsPerPixel: bpp, ScanlinePad: pad},
+		},
+		Roots: []xproto.ScreenInfo{{
+			AllowedDepths: []xproto.DepthInfo{{
+				Depth: depth,
+				Visuals: []xproto.VisualInfo{{
+					VisualId:        fakeVisual,
+					Class:           class,
+					ColormapEntries: 256,
+					RedMask:         red,
+					GreenMask:       green,
+					BlueMask:        blue,
+				}},
+			}},
+		}},
+	}
+}
+
+func TestXPixelFormat(t *testing.T) {
+	lsb, msb := byte(xproto.ImageOrderLSBFirst), byte(xproto.ImageOrderMSBFirst)
+	trueColor := byte(xproto.VisualClassTrueColor)
+	red, green, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}
+	cases := []struct {
+		name  string
+		setup *xproto.SetupInfo
+		width int
+		data  []byte // two rows
+		want  []color.RGBA
+	}{
+		{
+			name:  "depth 24, BGRX",
+			setup: fakeSetup(lsb, 24, 32, 32, trueColor, 0xff0000, 0xff00, 0xff),
+			width: 1,
+			data:  []byte{0x30, 0x20, 0x10, 0, 0x03, 0x02, 0x01, 0},
+			want:  []color.RGBA{{0x10, 0x20, 0x30, 255}, {0x01, 0x02, 0x03, 255}},
+		},
+		{
+			name:  "depth 24, big-endian",
+			setup: fakeSetup(msb, 24, 32, 32, trueColor, 0xff0000, 0xff00, 0xff),
+			width: 1,
+			data:  []byte{0, 0x10, 0x20, 0x30, 0, 0x01, 0x02, 0x03},
+			want:  []color.RGBA{{0x10, 0x20, 0x30, 255}, {0x01, 0x02, 0x03, 255}},
+		},
+		{
+			name:  "depth 24, packed",
+			setup: fakeSetup(lsb, 24, 24, 32, trueColor, 0xff0000, 0xff00, 0xff),
+			width: 2,
+			data:  []byte{0x30, 0x20, 0x10, 0xff, 0, 0, 0, 0, 0, 0xff, 0, 0, 0, 0xff, 0, 0},
+			want:  []color.RGBA{{0x10, 0x20, 0x30, 255}, blue, green, red},
+		},
+		{
+			name:  "depth 16",
+			setup: fakeSetup(lsb, 16, 16, 32, trueColor, 0xf800, 0x07e0, 0x001f),
+			width: 3,
+			data:  []byte{0x00, 0xf8, 0xe0, 0x07, 0x1f, 0x00, 0, 0, 0xff, 0xff, 0, 0, 0x10, 0x84, 0, 0},
+			want: []color.RGBA{
+				red, green, blue,
+				{255, 255, 255, 255}, {0, 0, 0, 255}, {132, 130, 132, 255},
+			},
+		},
+		{
+			name:  "depth 16, big-endian",
+			setup: fakeSetup(msb, 16, 16, 16, trueColor, 0xf800, 0x07e0, 0x001f),
+			width: 1,
+			data:  []byte{0xf8, 0x00, 0x00, 0x1f},
+			want:  []color.RGBA{red, blue},
+		},
+		{
+			name:  "depth 15",
+			setup: fakeSetup(lsb, 15, 16, 32, trueColor, 0x7c00, 0x03e0, 0x001f),
+			width: 2,
+			data:  []byte{0x00, 0x7c, 0x10, 0x42, 0xe0, 0x03, 0x1f, 0x00},
+			want:  []color.RGBA{red, {132, 132, 132, 255}, green, blue},
+		},
+		{
+			name:  "depth 30",
+			setup: fakeSetup(lsb, 30, 32, 32, trueColor, 0x3ff00000, 0x000ffc00, 0x000003ff),
+			width: 1,
+			// x2r10g10b10 pixels 0x3ff80000 and 0x000003ff.
+			data: []byte{0x00, 0x00, 0xf8, 0x3f, 0xff, 0x03, 0x00, 0x00},
+			want: []color.RGBA{{255, 128, 0, 255}, blue},
+		},
+		{
+			name:  "depth 8, pseudo color",
+			setup: fakeSetup(lsb, 8, 8, 32, xproto.VisualClassPseudoColor, 0, 0, 0),
+			width: 2,
+			data:  []byte{1, 2, 0, 0, 0, 7, 0, 0},
+			want:  []color.RGBA{green, blue, red, {0, 0, 0, 255}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := newXPixelFormat(c.setup, fakeVisual)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.indexed {
+				f.palette = []color.RGBA{red, green, blue}
+			}
+			// Convert into the middle of a larger image, to check the offsets.
+			dst := image.NewRGBA(image.Rect(-1, -1, c.width+2, 3))
+			if err := f.convert(dst, false, image.Pt(0, 0), c.data, c.width, 2); err != nil {
+				t.Fatal(err)
+			}
+			bgra := NewBGRA(dst.Rect)
+			if err := f.convert(bgra.rgbaView(), true, image.Pt(0, 0), c.data, c.width, 2); err != nil {
+				t.Fatal(err)
+			}
+			for i, want := range c.want {
+				x, y := i%c.width, i/c.width
+				if got := dst.RGBAAt(x, y); got != want {
+					t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+				}
+				if got := bgra.RGBAAt(x, y); got != want {
+					t.Errorf("BGRA pixel (%d, %d) = %v, want %v", x, y, got, want)
+				}
+			}
+			if got := dst.RGBAAt(-1, -1); got != (color.RGBA{}) {
+				t.Errorf("pixel (-1, -1) = %v, want it left alone", got)
+			}
+		})
+	}
+}
+
+func TestXPixelFormatErrors(t *testing.T) {
+	setup := fakeSetup(xproto.ImageOrderLSBFirst, 4, 4, 32, xproto.VisualClassStaticGray, 0, 0, 0)
+	if _, err := newXPixelFormat(setup, fakeVisual); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("newXPixelFormat() = %v, want ErrUnsupported", err)
+	}
+	setup = fakeSetup(xproto.ImageOrderLSBFirst, 16, 16, 32, xproto.VisualClassTrueColor, 0xf800, 0x07e0, 0x001f)
+	if _, err := newXPixelFormat(setup, fakeVisual+1); err == nil {
+		t.Errorf("newXPixelFormat() succeeded for an unknown visual")
+	}
+	f, err := newXPixelFormat(setup, fakeVisual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last row of 3 pixels is 6 bytes, but the one before is padded to 8.
+	if err := f.convert(image.NewRGBA(image.Rect(0, 0, 3, 2)), false, image.Point{}, make([]byte, 13), 3, 2); err != errShortImage {
+		t.Errorf("convert() = %v, want errShortImage", err)
+	}
+	if err := f.convert(image.NewRGBA(image.Rect(0, 0, 3, 2)), false, image.Point{}, make([]byte, 14), 3, 2); err != nil {
+		t.Errorf("convert() = %v", err)
+	}
+}
@@ -3,6 +3,7 @@
 package screenshot
 
 import (
+	"context"
 	"fmt"
 	"github.com/gen2brain/shm"
 	"github.com/jezek/xgb"
@@ -10,125 +11,341 @@ import (
 	"github.com/jezek/xgb/xinerama"
 	"github.com/jezek/xgb/xproto"
 	"image"
-	"image/color"
+	"net"
+	"sync"
 )
 
-func captureXinerama(x, y, width, height int) (img *image.RGBA, e error) {
+// xSession is a connection to the X server with the extensions used for capturing initialized.
+type xSession struct {
+	conn         *xgb.Conn
+	netConn      net.Conn // beneath conn, expired to abort the requests
+	screen       *xproto.ScreenInfo
+	screenNum    int // index of screen among the roots of the X server
+	useShm       bool
+	shm          *shmSegment
+	hasRandR     bool
+	hasMonitors  bool // RandR 1.5
+	hasXinerama  bool
+	hasComposite bool
+	hasXFixes    bool
+	atoms        map[string]xproto.Atom
+
+	// watchesScreens is set when RandR notifies the changes of the layout of the screens to the
+	// connection. The events are read by screenAreas if pollEvents is set, or else by the goroutine
+	// waiting for the events of the connection, which calls invalidateScreens.
+	watchesScreens bool
+	pollEvents     bool
+	screensMu      sync.Mutex
+	areas          []xScreenArea // cached by screenAreas
+}
+
+// shmSegment is a SysV shared memory segment attached to both this process and the X server.
+type shmSegment struct {
+	id   int
+	seg  mshm.Seg
+	data []byte
+}
+
+func openXSession(ctx context.Context, opts options) (xs *xSession, e error) {
+	c, nc, err := dialX(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
 		err := recover()
 		if err != nil {
-			img = nil
 			e = fmt.Errorf("%v", err)
 		}
+		if e != nil {
+			c.Close()
+			xs = nil
+		}
+	}()
+	stop := abortXOnDone(ctx, nc)
+	defer func() {
+		if !stop() {
+			e = ctx.Err()
+		}
 	}()
-	c, err := xgb.NewConn()
+
+	// Xinerama is only the fallback of RandR 1.5 for the geometry of the monitors.
+	hasXinerama := xinerama.Init(c) == nil
+
+	useShm := true
+	err = mshm.Init(c)
+	if err != nil {
+		useShm = false
+	}
+
+	hasRandR, hasMonitors := initRandR(c)
+	return &xSession{
+		conn:           c,
+		netConn:        nc,
+		screen:         xproto.Setup(c).DefaultScreen(c),
+		screenNum:      c.DefaultScreen,
+		useShm:         useShm,
+		hasRandR:       hasRandR,
+		hasMonitors:    hasMonitors,
+		hasXinerama:    hasXinerama,
+		watchesScreens: hasRandR && selectScreenChanges(c) == nil,
+	}, nil
+}
+
+// root returns the X screen num of the server.
+func (s *xSession) root(num int) *xproto.ScreenInfo {
+	if num == s.screenNum {
+		return s.screen
+	}
+	return &xproto.Setup(s.conn).Roots[num]
+}
+
+// queryScreens returns the monitors of the X screen of the session, the first of which is the origin of
+// the coordinate system of Capture.
+func (s *xSession) queryScreens() ([]xMonitor, error) {
+	areas, err := s.screenAreas()
 	if err != nil {
 		return nil, err
 	}
-	defer c.Close()
+	return areas[0].monitors, nil
+}
 
-	err = xinerama.Init(c)
+// queryXinerama returns the Xinerama screens, which only describe the first X screen.
+func (s *xSession) queryXinerama() ([]xMonitor, error) {
+	reply, err := xinerama.QueryScreens(s.conn).Reply()
 	if err != nil {
 		return nil, err
 	}
+	if reply.Number == 0 {
+		return nil, ErrNoDisplay
+	}
+	monitors := make([]xMonitor, reply.Number)
+	for i, screen := range reply.ScreenInfo[:reply.Number] {
+		monitors[i].bounds = image.Rect(int(screen.XOrg), int(screen.YOrg),
+			int(screen.XOrg)+int(screen.Width), int(screen.YOrg)+int(screen.Height))
+	}
+	return monitors, nil
+}
+
+// shmBuffer returns a shared memory segment of at least size bytes.
+// The segment is reused across calls and only reallocated when it has to grow.
+func (s *xSession) shmBuffer(size int) (*shmSegment, error) {
+	if s.shm != nil && len(s.shm.data) >= size {
+		return s.shm, nil
+	}
+	s.releaseShm()
 
-	reply, err := xinerama.QueryScreens(c).Reply()
+	shmId, err := shm.Get(shm.IPC_PRIVATE, size, shm.IPC_CREAT|0777)
 	if err != nil {
 		return nil, err
 	}
+	// Once both sides have attached, the segment is marked for removal so that the kernel
+	// reclaims it even if this process dies without calling Close.
+	defer func() {
+		_ = shm.Rm(shmId)
+	}()
 
-	primary := reply.ScreenInfo[0]
-	x0 := int(primary.XOrg)
-	y0 := int(primary.YOrg)
+	seg, err := mshm.NewSegId(s.conn)
+	if err != nil {
+		return nil, err
+	}
 
-	useShm := true
-	err = mshm.Init(c)
+	data, err := shm.At(shmId, 0, 0)
 	if err != nil {
-		useShm = false
+		return nil, err
+	}
+
+	err = mshm.AttachChecked(s.conn, seg, uint32(shmId), false).Check()
+	if err != nil {
+		// The X server may be unable to attach our segment, e.g. when it runs on a remote
+		// host. Fall back to plain GetImage for the rest of the session.
+		s.useShm = false
+		_ = shm.Dt(data)
+		return nil, err
+	}
+
+	s.shm = &shmSegment{id: shmId, seg: seg, data: data}
+	return s.shm, nil
+}
+
+func (s *xSession) releaseShm() {
+	if s.shm == nil {
+		return
+	}
+	mshm.Detach(s.conn, s.shm.seg)
+	_ = shm.Dt(s.shm.data)
+	s.shm = nil
+}
+
+func (s *xSession) close() {
+	s.releaseShm()
+	s.conn.Close()
+}
+
+// captureInto reads rect of the desktop into dst, in the BGRA byte order if bgra is set. Areas outside
+// of the X screens are painted opaque black.
+func (s *xSession) captureInto(dst *image.RGBA, bgra bool, rect image.Rectangle) (e error) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			e = fmt.Errorf("%v", err)
+		}
+		if e != nil {
+			// The layout may have changed before RandR told so.
+			s.invalidateScreens()
+		}
+	}()
+
+	areas, err := s.screenAreas()
+	if err != nil {
+		return err
+	}
+	for i, a := range areas {
+		// The read from the screen of the session paints the whole of rect, and the others their part.
+		part := rect
+		if i > 0 {
+			part = rect.Intersect(a.bounds())
+			if part.Empty() {
+				continue
+			}
+		}
+		format, err := s.pixelFormat(a.screen.RootVisual, a.screen.DefaultColormap)
+		if err != nil {
+			return err
+		}
+		sub := dst.SubImage(part.Sub(rect.Min).Add(dst.Rect.Min)).(*image.RGBA)
+		err = s.readDrawable(sub, bgra, xproto.Drawable(a.screen.Root), format, a.rootBounds(), part.Sub(a.offset))
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	screen := xproto.Setup(c).DefaultScreen(c)
-	wholeScreenBounds := image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels))
-	targetBounds := image.Rect(x+x0, y+y0, x+x0+width, y+y0+height)
-	intersect := wholeScreenBounds.Intersect(targetBounds)
+// captureFrame reads rect of the desktop. When the root window of the session has the BGRX layout and covers rect, the
+// frame is the image returned by the X server, whose Pix aliases the shared memory segment with MIT-SHM.
+// Otherwise, rect is converted into a new BGRA buffer.
+func (s *xSession) captureFrame(rect image.Rectangle) (frame *Frame, e error) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			e = fmt.Errorf("%v", err)
+		}
+		if e != nil {
+			s.invalidateScreens()
+		}
+	}()
 
-	rect := image.Rect(0, 0, width, height)
-	img, err = createImage(rect)
+	origin, err := s.origin()
 	if err != nil {
 		return nil, err
 	}
+	format, err := s.rootFormat()
+	if err != nil {
+		return nil, err
+	}
+	src := rect.Add(origin)
+	if !format.isBGRX() || src.Empty() || !src.In(s.rootBounds()) {
+		img, err := createBGRA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		if err != nil {
+			return nil, err
+		}
+		err = s.captureInto(img.rgbaView(), true, rect)
+		if err != nil {
+			return nil, err
+		}
+		return &Frame{Pix: img.Pix, Stride: img.Stride, Width: rect.Dx(), Height: rect.Dy(), Format: PixelFormatBGRA}, nil
+	}
+
+	data, err := s.getImage(xproto.Drawable(s.screen.Root), format, src)
+	if err != nil {
+		return nil, err
+	}
+	// The fourth byte is padding, which the X server leaves undefined.
+	stride := format.stride(rect.Dx())
+	convertPixels(data, stride, data, stride, rect.Dx(), rect.Dy(), pixelOpaque)
+	return &Frame{Pix: data, Stride: stride, Width: rect.Dx(), Height: rect.Dy(), Format: PixelFormatBGRA}, nil
+}
+
+// origin returns the position of the first monitor of the X screen in its root window, which is the
+// origin of the coordinate system of Capture.
+func (s *xSession) origin() (image.Point, error) {
+	monitors, err := s.queryScreens()
+	if err != nil {
+		return image.Point{}, err
+	}
+	return monitors[0].bounds.Min, nil
+}
+
+// rootBounds returns the extent of the root window.
+func (s *xSession) rootBounds() image.Rectangle {
+	return image.Rect(0, 0, int(s.screen.WidthInPixels), int(s.screen.HeightInPixels))
+}
+
+// readDrawable reads the region src of the drawable, whose images are in format, into dst, in the BGRA
+// byte order if bgra is set. bounds is the extent of the drawable; the part of src outside of it is
+// painted opaque black.
+func (s *xSession) readDrawable(dst *image.RGBA, bgra bool, drawable xproto.Drawable, format *xPixelFormat, bounds, src image.Rectangle) error {
+	intersect := bounds.Intersect(src)
 
 	// Paint with opaque black
-	index := 0
+	width := src.Dx()
+	height := src.Dy()
+	index := dst.PixOffset(dst.Rect.Min.X, dst.Rect.Min.Y)
 	for iy := 0; iy < height; iy++ {
 		j := index
 		for ix := 0; ix < width; ix++ {
-			img.Pix[j+3] = 255
+			dst.Pix[j], dst.Pix[j+1], dst.Pix[j+2], dst.Pix[j+3] = 0, 0, 0, 255
 			j += 4
 		}
-		index += img.Stride
+		index += dst.Stride
 	}
 
-	if !intersect.Empty() {
-		var data []byte
-
-		if useShm {
-			shmSize := intersect.Dx() * intersect.Dy() * 4
-			shmId, err := shm.Get(shm.IPC_PRIVATE, shmSize, shm.IPC_CREAT|0777)
-			if err != nil {
-				return nil, err
-			}
-
-			seg, err := mshm.NewSegId(c)
-			if err != nil {
-				return nil, err
-			}
-
-			data, err = shm.At(shmId, 0, 0)
-			if err != nil {
-				return nil, err
-			}
-
-			mshm.Attach(c, seg, uint32(shmId), false)
-
-			defer mshm.Detach(c, seg)
-			defer func() {
-				_ = shm.Rm(shmId)
-			}()
-			defer func() {
-				_ = shm.Dt(data)
-			}()
-
-			_, err = mshm.GetImage(c, xproto.Drawable(screen.Root),
-				int16(intersect.Min.X), int16(intersect.Min.Y),
-				uint16(intersect.Dx()), uint16(intersect.Dy()), 0xffffffff,
-				byte(xproto.ImageFormatZPixmap), seg, 0).Reply()
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			xImg, err := xproto.GetImage(c, xproto.ImageFormatZPixmap, xproto.Drawable(screen.Root),
-				int16(intersect.Min.X), int16(intersect.Min.Y),
-				uint16(intersect.Dx()), uint16(intersect.Dy()), 0xffffffff).Reply()
-			if err != nil {
-				return nil, err
-			}
+	if intersect.Empty() {
+		return nil
+	}
+	data, err := s.getImage(drawable, format, intersect)
+	if err != nil {
+		return err
+	}
+	p := intersect.Min.Add(dst.Rect.Min.Sub(src.Min))
+	return format.convert(dst, bgra, p, data, intersect.Dx(), intersect.Dy())
+}
 
-			data = xImg.Data
+// getImage reads the rect of the drawable in the ZPixmap format, through MIT-SHM unless the session
+// fell back to plain GetImage. The returned slice may alias the shared memory segment, and is then
+// only valid until the next call.
+func (s *xSession) getImage(drawable xproto.Drawable, format *xPixelFormat, rect image.Rectangle) ([]byte, error) {
+	if s.useShm {
+		data, err := s.getImageShm(drawable, rect, format)
+		// A segment the X server cannot attach turns MIT-SHM off, see shmBuffer.
+		if err == nil || s.useShm {
+			return data, err
 		}
+	}
+	xImg, err := xproto.GetImage(s.conn, xproto.ImageFormatZPixmap, drawable,
+		int16(rect.Min.X), int16(rect.Min.Y),
+		uint16(rect.Dx()), uint16(rect.Dy()), 0xffffffff).Reply()
+	if err != nil {
+		return nil, err
+	}
+	return xImg.Data, nil
+}
 
-		// BitBlt by hand
-		offset := 0
-		for iy := intersect.Min.Y; iy < intersect.Max.Y; iy++ {
-			for ix := intersect.Min.X; ix < intersect.Max.X; ix++ {
-				r := data[offset+2]
-				g := data[offset+1]
-				b := data[offset]
-				img.SetRGBA(ix-(x+x0), iy-(y+y0), color.RGBA{r, g, b, 255})
-				offset += 4
-			}
-		}
+// getImageShm reads the rect of the drawable through the MIT-SHM extension.
+// The returned slice aliases the shared memory segment and is only valid until the next call.
+func (s *xSession) getImageShm(drawable xproto.Drawable, rect image.Rectangle, format *xPixelFormat) ([]byte, error) {
+	size := format.stride(rect.Dx()) * rect.Dy()
+	seg, err := s.shmBuffer(size)
+	if err != nil {
+		return nil, err
 	}
 
-	return img, e
+	_, err = mshm.GetImage(s.conn, drawable,
+		int16(rect.Min.X), int16(rect.Min.Y),
+		uint16(rect.Dx()), uint16(rect.Dy()), 0xffffffff,
+		byte(xproto.ImageFormatZPixmap), seg.seg, 0).Reply()
+	if err != nil {
+		return nil, err
+	}
+	return seg.data[:size], nil
 }
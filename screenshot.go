@@ -3,17 +3,51 @@
 package screenshot
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"image"
+	"image/draw"
+	"os"
+	"sync"
 )
 
 // ErrUnsupported is returned when the platform or architecture used to compile the program
 // does not support screenshot, e.g. if you're compiling without CGO on Darwin
 var ErrUnsupported = errors.New("screenshot does not support your platform")
 
+// ErrClosed is returned when a method is called on a Capturer that has already been closed.
+var ErrClosed = errors.New("screenshot: capturer is closed")
+
+// ErrNoDisplay is returned when no display server can be reached, e.g. when $DISPLAY is unset
+// or the X server is down, or when the display server reports no active display.
+var ErrNoDisplay = errors.New("screenshot: no display available")
+
+// ErrDisplayIndexOutOfRange is returned when a displayIndex does not designate an active display.
+var ErrDisplayIndexOutOfRange = errors.New("screenshot: display index out of range")
+
+// ErrExtensionMissing is returned when the display server lacks a protocol extension or service
+// required for the operation, e.g. MIT-SHM on X11 or the Screenshot portal on Wayland.
+var ErrExtensionMissing = errors.New("screenshot: required extension is missing")
+
+// ErrCanceled is returned when the user dismissed the request of a desktop portal, e.g. the
+// confirmation dialog of the Screenshot portal on Wayland.
+var ErrCanceled = errors.New("screenshot: request was canceled by the user")
+
+// ErrPortalFailed is returned when a desktop portal ended a request without a result, for a reason
+// other than the user canceling it.
+var ErrPortalFailed = errors.New("screenshot: desktop portal request failed")
+
+// ErrNoBackend is returned when no backend can capture in the current environment, along with the reason
+// for each backend, or when the backend selected by name does not exist on the platform.
+var ErrNoBackend = errors.New("screenshot: no capture backend available")
+
 // CaptureDisplay captures whole region of displayIndex'th display, starts at 0 for primary display.
 func CaptureDisplay(displayIndex int) (*image.RGBA, error) {
-	rect := GetDisplayBounds(displayIndex)
+	rect, err := DisplayBounds(displayIndex)
+	if err != nil {
+		return nil, err
+	}
 	return CaptureRect(rect)
 }
 
@@ -22,6 +56,722 @@ func CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
 	return Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
 }
 
+// CaptureContext captures specified region of desktop like CaptureRect, and gives up with the error of
+// ctx once it is done, e.g. when an X server or a desktop portal stops answering. The backends which
+// cannot be interrupted, GDI and Core Graphics, only check ctx before capturing.
+func CaptureContext(ctx context.Context, rect image.Rectangle) (*image.RGBA, error) {
+	img, err := createImage(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	if err != nil {
+		return nil, err
+	}
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	err = s.captureInto(ctx, img, rect)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Display describes an active display. Fields which the platform cannot report are left zero.
+type Display struct {
+	// Index is the displayIndex accepted by GetDisplayBounds and CaptureDisplay.
+	Index int
+	// ID identifies the display as long as it stays connected, e.g. the RandR output on X11,
+	// the CGDirectDisplayID on macOS and the GDI device name on Windows.
+	ID string
+	// Name is the human-readable name of the output, e.g. "HDMI-1".
+	Name string
+	// Primary reports whether the display is the primary one.
+	Primary bool
+	// Bounds is the region of the display in the coordinate system used by Capture.
+	Bounds image.Rectangle
+	// WidthMM and HeightMM are the physical size of the display in millimeters.
+	WidthMM, HeightMM int
+	// Rotation is the rotation of the display.
+	Rotation Rotation
+	// ReflectX and ReflectY report whether the display mirrors the image horizontally or vertically,
+	// before rotating it.
+	ReflectX, ReflectY bool
+	// RefreshRate is the refresh rate of the current mode in Hz.
+	RefreshRate float64
+	// ScaleFactor is the ratio of physical pixels to logical pixels, e.g. 2 for a Retina display.
+	ScaleFactor float64
+	// Panning is the region of the desktop across which the display pans, following the pointer, in
+	// the coordinate system of Bounds. It is empty unless the display pans, which only X11 reports.
+	Panning image.Rectangle
+	// Screen is the number of the X screen showing the display, see WithXScreen. It is 0 elsewhere.
+	Screen int
+}
+
+// Rotation is the clockwise rotation of a display in degrees.
+type Rotation int
+
+const (
+	Rotate0   Rotation = 0
+	Rotate90  Rotation = 90
+	Rotate180 Rotation = 180
+	Rotate270 Rotation = 270
+)
+
+// DisplayCount returns the number of active displays.
+// Unlike NumActiveDisplays, it reports why the displays cannot be enumerated.
+func DisplayCount() (int, error) {
+	return DisplayCountContext(context.Background())
+}
+
+// DisplayCountContext is like DisplayCount, but gives up with the error of ctx once it is done.
+func DisplayCountContext(ctx context.Context) (int, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return 0, err
+	}
+	defer s.close()
+	return s.displayCount(ctx)
+}
+
+// DisplayBounds returns the bounds of displayIndex'th display. The main display is displayIndex = 0.
+// Unlike GetDisplayBounds, it returns ErrDisplayIndexOutOfRange for an invalid displayIndex.
+func DisplayBounds(displayIndex int) (image.Rectangle, error) {
+	return DisplayBoundsContext(context.Background(), displayIndex)
+}
+
+// DisplayBoundsContext is like DisplayBounds, but gives up with the error of ctx once it is done.
+func DisplayBoundsContext(ctx context.Context, displayIndex int) (image.Rectangle, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	defer s.close()
+	return s.displayBounds(ctx, displayIndex)
+}
+
+// Displays returns the active displays, ordered by their index. On a multi-screen X server, the displays
+// of the other X screens follow those of the selected one, see WithXScreen.
+func Displays() ([]Display, error) {
+	return DisplaysContext(context.Background())
+}
+
+// DisplaysContext is like Displays, but gives up with the error of ctx once it is done.
+func DisplaysContext(ctx context.Context) ([]Display, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	return s.displays(ctx)
+}
+
+// Cursor is the mouse cursor, for callers who draw it by themselves.
+type Cursor struct {
+	// Image is the cursor image, with premultiplied alpha like any image.RGBA.
+	Image *image.RGBA
+	// Position is the location of the pointer, in the coordinate system used by Capture.
+	Position image.Point
+	// Hotspot is the point of Image located at Position.
+	Hotspot image.Point
+}
+
+// Bounds returns the region covered by the cursor image, in the coordinate system used by Capture.
+func (c *Cursor) Bounds() image.Rectangle {
+	return c.Image.Bounds().Sub(c.Image.Bounds().Min).Add(c.Position.Sub(c.Hotspot))
+}
+
+// drawOnto blends the cursor onto dst, which holds the capture of the desktop region rect.
+func (c *Cursor) drawOnto(dst draw.Image, rect image.Rectangle) {
+	r := c.Bounds().Sub(rect.Min).Add(dst.Bounds().Min)
+	draw.Draw(dst, r, c.Image, c.Image.Bounds().Min, draw.Over)
+}
+
+// CaptureCursor returns the image and the position of the mouse cursor.
+// ErrUnsupported is returned where the cursor image is not available, which is currently everywhere but X11.
+func CaptureCursor() (*Cursor, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	return s.captureCursor()
+}
+
+// PortalOptions configures a screenshot taken through the XDG desktop portal.
+type PortalOptions struct {
+	// Interactive lets the user pick the area to capture, and other options, in the UI of the compositor.
+	Interactive bool
+	// Modal makes the dialog of the portal, if any, modal to ParentWindow.
+	Modal bool
+	// ParentWindow identifies the window of the caller, as "x11:<XID in hex>" or "wayland:<exported
+	// xdg_foreign handle>". It may be empty.
+	ParentWindow string
+}
+
+// CapturePortal takes a screenshot through the Screenshot interface of the XDG desktop portal, and
+// returns it at its native size. It waits for the user as long as ctx allows when opts.Interactive is set.
+// ErrUnsupported is returned where the portal is not available, which is everywhere but Linux, OpenBSD and NetBSD.
+func CapturePortal(ctx context.Context, opts PortalOptions) (*image.RGBA, error) {
+	return capturePortal(ctx, opts)
+}
+
+// Backend is a mechanism to capture the desktop, e.g. MIT-SHM on X11 or the Screenshot portal on Wayland.
+// The backends are provided by the package, see Backends.
+//
+// Unless one is selected by name, through WithBackend or the SCREENSHOT_BACKEND environment variable,
+// the backends suited to the environment are probed in turn, and the first one which works is used.
+type Backend interface {
+	// Name identifies the backend, e.g. "x11-shm".
+	Name() string
+
+	// probe returns why the backend cannot capture through the session, if it cannot.
+	probe(ctx context.Context, s *session) error
+	captureInto(ctx context.Context, s *session, dst *image.RGBA, rect image.Rectangle) error
+}
+
+// bgraBackend is implemented by the backends which read BGRA pixels natively.
+type bgraBackend interface {
+	captureBGRAInto(ctx context.Context, s *session, dst *BGRA, rect image.Rectangle) error
+}
+
+// frameBackend is implemented by the backends which can hand out their own buffers as a Frame.
+type frameBackend interface {
+	captureFrame(ctx context.Context, s *session, rect image.Rectangle) (*Frame, error)
+}
+
+// Backends returns the backends of the platform, which are:
+//   - "x11-shm" and "x11-getimage" on Linux and the BSDs, capturing an X11 display with or without MIT-SHM,
+//   - "wlr-screencopy", "portal-screencast" and "portal" on Linux, OpenBSD and NetBSD, capturing a Wayland
+//     session through the screencopy protocols of wlroots, the ScreenCast portal and the Screenshot portal,
+//   - "gdi" on Windows and "coregraphics" on macOS.
+func Backends() []Backend {
+	return append([]Backend(nil), gBackends...)
+}
+
+// CurrentBackend returns the backend which the package-level functions capture through.
+func CurrentBackend() (Backend, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	return s.selectBackend(context.Background())
+}
+
+// chooseBackend returns the backend selected by name, or else the first backend of the chain of the
+// session whose probe succeeds.
+func chooseBackend(ctx context.Context, s *session) (Backend, error) {
+	name := s.opts.backend
+	if name == "" {
+		name = os.Getenv("SCREENSHOT_BACKEND")
+	}
+	if name != "" {
+		for _, b := range gBackends {
+			if b.Name() != name {
+				continue
+			}
+			if err := b.probe(ctx, s); err != nil {
+				return nil, fmt.Errorf("screenshot: backend %s: %w", name, err)
+			}
+			return b, nil
+		}
+		return nil, fmt.Errorf("%w: unknown backend %q", ErrNoBackend, name)
+	}
+
+	var errs []error
+	for _, b := range s.backendChain() {
+		err := b.probe(ctx, s)
+		if err == nil {
+			return b, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name(), err))
+	}
+	return nil, fmt.Errorf("%w: %w", ErrNoBackend, errors.Join(errs...))
+}
+
+// CaptureInto captures specified region of desktop into dst, without allocating a new image.
+// The size of dst.Bounds() must be equal to the size of rect. dst may be a sub-image.
+func CaptureInto(dst *image.RGBA, rect image.Rectangle) error {
+	if err := checkDestination(dst, rect); err != nil {
+		return err
+	}
+	s, err := newSession(options{})
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	return s.captureInto(context.Background(), dst, rect)
+}
+
+// CaptureBGRA captures specified region of desktop as a BGRA image.
+func CaptureBGRA(rect image.Rectangle) (*BGRA, error) {
+	img, err := createBGRA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	if err != nil {
+		return nil, err
+	}
+	err = CaptureBGRAInto(img, rect)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// CaptureBGRAInto captures specified region of desktop into dst, without allocating a new image.
+// The size of dst.Bounds() must be equal to the size of rect. dst may be a sub-image.
+func CaptureBGRAInto(dst *BGRA, rect image.Rectangle) error {
+	if err := checkBGRADestination(dst, rect); err != nil {
+		return err
+	}
+	s, err := newSession(options{})
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	return s.captureBGRAInto(context.Background(), dst, rect)
+}
+
+// captureBGRAInto captures rect into dst through the backend of the session. The backends which produce
+// RGBA pixels capture into the memory of dst, whose red and blue channels are swapped afterwards.
+func (s *session) captureBGRAInto(ctx context.Context, dst *BGRA, rect image.Rectangle) error {
+	b, err := s.selectBackend(ctx)
+	if err != nil {
+		return err
+	}
+	if nb, ok := b.(bgraBackend); ok {
+		return nb.captureBGRAInto(ctx, s, dst, rect)
+	}
+	img := dst.rgbaView()
+	err = b.captureInto(ctx, s, img, rect)
+	if err != nil {
+		return err
+	}
+	swapRedBlue(img)
+	return nil
+}
+
+// captureFrame captures rect in the pixel format native to the backend of the session.
+func (s *session) captureFrame(ctx context.Context, rect image.Rectangle) (*Frame, error) {
+	b, err := s.selectBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if fb, ok := b.(frameBackend); ok {
+		return fb.captureFrame(ctx, s, rect)
+	}
+	bounds := image.Rect(0, 0, rect.Dx(), rect.Dy())
+	if _, ok := b.(bgraBackend); ok {
+		img, err := createBGRA(bounds)
+		if err != nil {
+			return nil, err
+		}
+		err = s.captureBGRAInto(ctx, img, rect)
+		if err != nil {
+			return nil, err
+		}
+		return &Frame{Pix: img.Pix, Stride: img.Stride, Width: rect.Dx(), Height: rect.Dy(), Format: PixelFormatBGRA}, nil
+	}
+	img, err := createImage(bounds)
+	if err != nil {
+		return nil, err
+	}
+	err = b.captureInto(ctx, s, img, rect)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Pix: img.Pix, Stride: img.Stride, Width: rect.Dx(), Height: rect.Dy(), Format: PixelFormatRGBA}, nil
+}
+
+// Capturer captures the desktop repeatedly. Unlike the package-level functions,
+// it keeps platform resources (e.g. the X11 connection and its shared memory
+// segment) alive between calls, which makes it suitable for capturing in a loop.
+//
+// A Capturer is safe for concurrent use. Close must be called to release its resources.
+type Capturer struct {
+	mu       sync.Mutex
+	s        *session
+	opts     options
+	tracker  changeTracker // created by the first call to WaitForChange
+	yuvFrame *Frame        // reused by CaptureFrame WithYUV
+}
+
+// Option configures a Capturer.
+type Option func(*options)
+
+type options struct {
+	cursor       bool
+	screenCast   bool
+	restoreToken string
+	backend      string
+	yuv          bool
+	yuvFormat    PixelFormat
+	yuvOptions   YUVOptions
+	xDisplay     string
+	xAuthority   string
+	xCookie      []byte
+	xScreen      int
+	xScreenSet   bool
+}
+
+// WithCursor makes the Capturer draw the mouse cursor onto the captured images.
+// The option is ignored where the cursor image is not available, which is currently everywhere but X11
+// and Wayland compositors implementing the screencopy protocols, which draw the cursor themselves.
+func WithCursor() Option {
+	return func(o *options) {
+		o.cursor = true
+	}
+}
+
+// WithScreenCast makes the Capturer capture Wayland sessions through a ScreenCast session of the
+// XDG desktop portal, which streams the monitors through PipeWire instead of writing a file for each
+// screenshot. The session is started by the first capture, which waits for the user to approve it,
+// and is kept until Close. restoreToken is the RestoreToken of a previous session, which lets the
+// portal skip the approval; it may be empty. The option is ignored elsewhere.
+func WithScreenCast(restoreToken string) Option {
+	return func(o *options) {
+		o.screenCast = true
+		o.restoreToken = restoreToken
+	}
+}
+
+// WithBackend makes the Capturer capture through the backend of the given name, see Backends, instead of
+// the first one which works. It takes precedence over the SCREENSHOT_BACKEND environment variable.
+func WithBackend(name string) Option {
+	return func(o *options) {
+		o.backend = name
+	}
+}
+
+// WithYUV makes CaptureFrame return frames in format, PixelFormatI420 or PixelFormatNV12, converted
+// from the pixels read by the backend as configured by opts. The conversion replaces the one into RGBA,
+// which spares a pass over the image in video pipelines.
+func WithYUV(format PixelFormat, opts YUVOptions) Option {
+	return func(o *options) {
+		o.yuv = true
+		o.yuvFormat = format
+		o.yuvOptions = opts
+	}
+}
+
+// WithXDisplay makes the Capturer capture the X server of the given display string,
+// [protocol/][host]:display[.screen] like $DISPLAY, instead of the one of $DISPLAY. Only the X11
+// backends are then considered. The option is ignored where there is no X11 support.
+func WithXDisplay(display string) Option {
+	return func(o *options) {
+		o.xDisplay = display
+	}
+}
+
+// WithXAuthority makes the Capturer read the cookie authorizing the connection to the X server
+// from the Xauthority file at path, instead of $XAUTHORITY or ~/.Xauthority.
+func WithXAuthority(path string) Option {
+	return func(o *options) {
+		o.xAuthority = path
+	}
+}
+
+// WithXAuthCookie makes the Capturer authorize the connection to the X server with the given
+// MIT-MAGIC-COOKIE-1, e.g. the one given to Xvfb, instead of looking it up in an Xauthority file.
+func WithXAuthCookie(cookie []byte) Option {
+	return func(o *options) {
+		o.xCookie = append([]byte{}, cookie...)
+	}
+}
+
+// WithXScreen makes the X screen of the given number, e.g. 1 for the second root window of a multi-screen
+// X server, the one holding the origin of the coordinate system of Capture and the first displays,
+// instead of the one of the display string. The other X screens are placed on its right, aligned with its
+// top, in the order of their numbers. Only the X11 backends are considered. The option is ignored where
+// there is no X11 support.
+func WithXScreen(screen int) Option {
+	return func(o *options) {
+		o.xScreen = screen
+		o.xScreenSet = true
+	}
+}
+
+// NewCapturer creates a Capturer.
+func NewCapturer(opts ...Option) (*Capturer, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.yuv && !o.yuvFormat.isYUV() {
+		return nil, fmt.Errorf("screenshot: %v is not a YUV format", o.yuvFormat)
+	}
+	if o.xCookie != nil && len(o.xCookie) != 16 {
+		return nil, fmt.Errorf("screenshot: an MIT-MAGIC-COOKIE-1 is 16 bytes long, not %d", len(o.xCookie))
+	}
+	if o.xScreen < 0 {
+		return nil, fmt.Errorf("screenshot: bad X screen %d", o.xScreen)
+	}
+	s, err := newSession(o)
+	if err != nil {
+		return nil, err
+	}
+	return &Capturer{s: s, opts: o}, nil
+}
+
+// Capture returns screen capture of specified desktop region.
+// See the package-level Capture for the coordinate system.
+func (c *Capturer) Capture(x, y, width, height int) (*image.RGBA, error) {
+	img, err := createImage(image.Rect(0, 0, width, height))
+	if err != nil {
+		return nil, err
+	}
+	err = c.CaptureInto(img, image.Rect(x, y, x+width, y+height))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// CaptureInto captures specified region of desktop into dst, which can be reused frame after frame.
+// The size of dst.Bounds() must be equal to the size of rect.
+func (c *Capturer) CaptureInto(dst *image.RGBA, rect image.Rectangle) error {
+	return c.captureInto(context.Background(), dst, rect)
+}
+
+// CaptureContext captures specified region of desktop, and gives up with the error of ctx once it is
+// done. See the package-level CaptureContext.
+func (c *Capturer) CaptureContext(ctx context.Context, rect image.Rectangle) (*image.RGBA, error) {
+	img, err := createImage(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	if err != nil {
+		return nil, err
+	}
+	err = c.captureInto(ctx, img, rect)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func (c *Capturer) captureInto(ctx context.Context, dst *image.RGBA, rect image.Rectangle) error {
+	if err := checkDestination(dst, rect); err != nil {
+		return err
+	}
+	return c.withSession(func(s *session) error {
+		err := s.captureInto(ctx, dst, rect)
+		if err != nil {
+			return err
+		}
+		return c.drawCursor(s, dst, rect)
+	})
+}
+
+// CaptureBGRA captures specified region of desktop as a BGRA image.
+func (c *Capturer) CaptureBGRA(rect image.Rectangle) (*BGRA, error) {
+	img, err := createBGRA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	if err != nil {
+		return nil, err
+	}
+	err = c.CaptureBGRAInto(img, rect)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// CaptureBGRAInto captures specified region of desktop into dst, which can be reused frame after frame.
+// The size of dst.Bounds() must be equal to the size of rect.
+func (c *Capturer) CaptureBGRAInto(dst *BGRA, rect image.Rectangle) error {
+	if err := checkBGRADestination(dst, rect); err != nil {
+		return err
+	}
+	return c.withSession(func(s *session) error {
+		err := s.captureBGRAInto(context.Background(), dst, rect)
+		if err != nil {
+			return err
+		}
+		return c.drawCursor(s, dst, rect)
+	})
+}
+
+// CaptureFrame captures specified region of desktop in the pixel format native to the backend, e.g.
+// BGRA on X11 and Windows, avoiding any conversion where possible, or in the format selected WithYUV.
+// The pixels may live in buffers of the Capturer, e.g. the shared memory segment of MIT-SHM, and are
+// then only valid until the next call on the Capturer. Copy them to keep them longer.
+func (c *Capturer) CaptureFrame(rect image.Rectangle) (frame *Frame, err error) {
+	err = c.withSession(func(s *session) error {
+		frame, err = s.captureFrame(context.Background(), rect)
+		if err != nil {
+			return err
+		}
+		err = c.drawCursor(s, frame.Image().(draw.Image), rect)
+		if err != nil || !c.opts.yuv {
+			return err
+		}
+		frame = c.convertYUV(frame)
+		return nil
+	})
+	return frame, err
+}
+
+// convertYUV converts frame into the YUV format of the Capturer, in a buffer reused from call to call.
+func (c *Capturer) convertYUV(frame *Frame) *Frame {
+	yuv := c.yuvFrame
+	if yuv == nil || yuv.Width != frame.Width || yuv.Height != frame.Height {
+		yuv = newYUVFrame(c.opts.yuvFormat, frame.Width, frame.Height, c.opts.yuvOptions)
+		c.yuvFrame = yuv
+	}
+	convertYUV(yuv, frame)
+	return yuv
+}
+
+// drawCursor blends the cursor onto dst, a capture of rect, if the Capturer was created WithCursor.
+func (c *Capturer) drawCursor(s *session, dst draw.Image, rect image.Rectangle) error {
+	if !c.opts.cursor {
+		return nil
+	}
+	cursor, err := s.captureCursor()
+	if errors.Is(err, ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cursor.drawOnto(dst, rect)
+	return nil
+}
+
+// CaptureRect captures specified region of desktop.
+func (c *Capturer) CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
+	return c.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+}
+
+// CaptureDisplay captures whole region of displayIndex'th display, starts at 0 for primary display.
+func (c *Capturer) CaptureDisplay(displayIndex int) (*image.RGBA, error) {
+	rect, err := c.DisplayBounds(displayIndex)
+	if err != nil {
+		return nil, err
+	}
+	return c.CaptureRect(rect)
+}
+
+// NumActiveDisplays returns the number of active displays.
+func (c *Capturer) NumActiveDisplays() int {
+	n, err := c.DisplayCount()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// GetDisplayBounds returns the bounds of displayIndex'th display.
+// The main display is displayIndex = 0.
+func (c *Capturer) GetDisplayBounds(displayIndex int) image.Rectangle {
+	rect, err := c.DisplayBounds(displayIndex)
+	if err != nil {
+		return image.Rectangle{}
+	}
+	return rect
+}
+
+// DisplayCount returns the number of active displays.
+func (c *Capturer) DisplayCount() (int, error) {
+	return c.DisplayCountContext(context.Background())
+}
+
+// DisplayCountContext is like DisplayCount, but gives up with the error of ctx once it is done.
+func (c *Capturer) DisplayCountContext(ctx context.Context) (n int, err error) {
+	err = c.withSession(func(s *session) error {
+		n, err = s.displayCount(ctx)
+		return err
+	})
+	return n, err
+}
+
+// DisplayBounds returns the bounds of displayIndex'th display.
+// The main display is displayIndex = 0.
+func (c *Capturer) DisplayBounds(displayIndex int) (image.Rectangle, error) {
+	return c.DisplayBoundsContext(context.Background(), displayIndex)
+}
+
+// DisplayBoundsContext is like DisplayBounds, but gives up with the error of ctx once it is done.
+func (c *Capturer) DisplayBoundsContext(ctx context.Context, displayIndex int) (rect image.Rectangle, err error) {
+	err = c.withSession(func(s *session) error {
+		rect, err = s.displayBounds(ctx, displayIndex)
+		return err
+	})
+	return rect, err
+}
+
+// Displays returns the active displays, ordered by their index. On a multi-screen X server, the displays
+// of the other X screens follow those of the selected one, see WithXScreen.
+func (c *Capturer) Displays() ([]Display, error) {
+	return c.DisplaysContext(context.Background())
+}
+
+// DisplaysContext is like Displays, but gives up with the error of ctx once it is done.
+func (c *Capturer) DisplaysContext(ctx context.Context) (displays []Display, err error) {
+	err = c.withSession(func(s *session) error {
+		displays, err = s.displays(ctx)
+		return err
+	})
+	return displays, err
+}
+
+// CaptureCursor returns the image and the position of the mouse cursor.
+func (c *Capturer) CaptureCursor() (cursor *Cursor, err error) {
+	err = c.withSession(func(s *session) error {
+		cursor, err = s.captureCursor()
+		return err
+	})
+	return cursor, err
+}
+
+// Backend returns the backend which the Capturer captures through. The backend is selected by the first
+// call which needs one, and kept until Close. The one exception is "x11-shm", which turns into
+// "x11-getimage" once the X server fails to attach a shared memory segment.
+func (c *Capturer) Backend() (b Backend, err error) {
+	err = c.withSession(func(s *session) error {
+		b, err = s.selectBackend(context.Background())
+		return err
+	})
+	return b, err
+}
+
+// RestoreToken returns the token to pass to WithScreenCast to restore the ScreenCast session of the
+// Capturer without asking the user again. It is empty until the session is started, or when the portal
+// does not allow restoring it.
+func (c *Capturer) RestoreToken() string {
+	var token string
+	_ = c.withSession(func(s *session) error {
+		token = s.restoreToken()
+		return nil
+	})
+	return token
+}
+
+// withSession calls f with the session of the Capturer while holding its lock.
+func (c *Capturer) withSession(f func(s *session) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.s == nil {
+		return ErrClosed
+	}
+	return f(c.s)
+}
+
+// Close releases the resources held by the Capturer.
+// Calling Close more than once is a no-op.
+func (c *Capturer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.s == nil {
+		return nil
+	}
+	if c.tracker != nil {
+		c.tracker.close()
+		c.tracker = nil
+	}
+	err := c.s.close()
+	c.s = nil
+	return err
+}
+
 func createImage(rect image.Rectangle) (img *image.RGBA, e error) {
 	img = nil
 	e = errors.New("Cannot create image.RGBA")
@@ -37,3 +787,37 @@ func createImage(rect image.Rectangle) (img *image.RGBA, e error) {
 
 	return img, e
 }
+
+// checkDisplayIndex returns ErrDisplayIndexOutOfRange unless 0 <= displayIndex < count.
+func checkDisplayIndex(displayIndex, count int) error {
+	if displayIndex < 0 || displayIndex >= count {
+		return fmt.Errorf("%w: %d not in [0, %d)", ErrDisplayIndexOutOfRange, displayIndex, count)
+	}
+	return nil
+}
+
+// checkBGRADestination is checkDestination for a BGRA image, which has the same memory layout.
+func checkBGRADestination(dst *BGRA, rect image.Rectangle) error {
+	if dst == nil {
+		return errors.New("screenshot: destination image is nil")
+	}
+	return checkDestination(dst.rgbaView(), rect)
+}
+
+// checkDestination returns an error if the capture of rect does not fit into dst.
+func checkDestination(dst *image.RGBA, rect image.Rectangle) error {
+	if dst == nil {
+		return errors.New("screenshot: destination image is nil")
+	}
+	b := dst.Bounds()
+	if b.Dx() != rect.Dx() || b.Dy() != rect.Dy() {
+		return fmt.Errorf("screenshot: destination size %dx%d does not match %dx%d", b.Dx(), b.Dy(), rect.Dx(), rect.Dy())
+	}
+	if b.Empty() {
+		return nil
+	}
+	if dst.Stride < b.Dx()*4 || len(dst.Pix) < dst.PixOffset(b.Max.X-1, b.Max.Y-1)+4 {
+		return fmt.Errorf("screenshot: destination stride %d or buffer length %d is too small", dst.Stride, len(dst.Pix))
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package screenshot
 
 import (
+	"errors"
+	"image"
+	"image/color"
 	"testing"
 )
 
@@ -12,6 +15,24 @@ func TestCaptureRect(t *testing.T) {
 	}
 }
 
+func TestDisplays(t *testing.T) {
+	displays, err := Displays()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(displays) != NumActiveDisplays() {
+		t.Errorf("len(Displays()) = %d, NumActiveDisplays() = %d", len(displays), NumActiveDisplays())
+	}
+	for i, d := range displays {
+		if d.Index != i {
+			t.Errorf("Displays()[%d].Index = %d", i, d.Index)
+		}
+		if bounds := GetDisplayBounds(i); d.Bounds != bounds {
+			t.Errorf("Displays()[%d].Bounds = %v, GetDisplayBounds(%d) = %v", i, d.Bounds, i, bounds)
+		}
+	}
+}
+
 func BenchmarkCaptureRect(t *testing.B) {
 	bounds := GetDisplayBounds(0)
 	t.ResetTimer()
@@ -22,3 +43,98 @@ func BenchmarkCaptureRect(t *testing.B) {
 		}
 	}
 }
+
+func BenchmarkCapturerCaptureRect(t *testing.B) {
+	c, err := NewCapturer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	bounds := c.GetDisplayBounds(0)
+	t.ResetTimer()
+	for i := 0; i < t.N; i++ {
+		_, err := c.CaptureRect(bounds)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func BenchmarkCapturerCaptureInto(t *testing.B) {
+	c, err := NewCapturer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	bounds := c.GetDisplayBounds(0)
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	t.ReportAllocs()
+	t.ResetTimer()
+	for i := 0; i < t.N; i++ {
+		err := c.CaptureInto(img, bounds)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestCheckDestination(t *testing.T) {
+	rect := image.Rect(10, 10, 30, 20)
+	whole := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	cases := []struct {
+		name string
+		dst  *image.RGBA
+		ok   bool
+	}{
+		{"same size", image.NewRGBA(image.Rect(0, 0, 20, 10)), true},
+		{"sub-image", whole.SubImage(image.Rect(5, 5, 25, 15)).(*image.RGBA), true},
+		{"nil", nil, false},
+		{"size mismatch", image.NewRGBA(image.Rect(0, 0, 10, 20)), false},
+		{"short stride", &image.RGBA{Pix: make([]byte, 20*10*4), Stride: 40, Rect: image.Rect(0, 0, 20, 10)}, false},
+		{"short buffer", &image.RGBA{Pix: make([]byte, 10), Stride: 80, Rect: image.Rect(0, 0, 20, 10)}, false},
+	}
+	for _, c := range cases {
+		err := checkDestination(c.dst, rect)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: checkDestination() = %v", c.name, err)
+		}
+	}
+}
+
+func TestCheckDisplayIndex(t *testing.T) {
+	for _, i := range []int{-1, 2, 3} {
+		if err := checkDisplayIndex(i, 2); !errors.Is(err, ErrDisplayIndexOutOfRange) {
+			t.Errorf("checkDisplayIndex(%d, 2) = %v", i, err)
+		}
+	}
+	for _, i := range []int{0, 1} {
+		if err := checkDisplayIndex(i, 2); err != nil {
+			t.Errorf("checkDisplayIndex(%d, 2) = %v", i, err)
+		}
+	}
+}
+
+func TestCursorDrawOnto(t *testing.T) {
+	cursorImage := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	cursorImage.SetRGBA(1, 1, color.RGBA{255, 0, 0, 255})
+	cursorImage.SetRGBA(2, 2, color.RGBA{0, 0, 128, 128})
+	cursor := &Cursor{Image: cursorImage, Position: image.Pt(11, 21), Hotspot: image.Pt(1, 1)}
+	if got, want := cursor.Bounds(), image.Rect(10, 20, 14, 24); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range dst.Pix {
+		dst.Pix[i] = 255
+	}
+	cursor.drawOnto(dst, image.Rect(8, 18, 16, 26))
+	if got, want := dst.RGBAAt(3, 3), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("hotspot pixel = %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(4, 4), (color.RGBA{127, 127, 255, 255}); got != want {
+		t.Errorf("blended pixel = %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(2, 2), (color.RGBA{255, 255, 255, 255}); got != want {
+		t.Errorf("transparent pixel = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,361 @@
+package screenshot
+
+import (
+	"context"
+	"image"
+	"sync"
+	"time"
+)
+
+// StreamOptions configures a FrameStream.
+type StreamOptions struct {
+	// FrameRate is the target number of frames per second. It defaults to 30.
+	FrameRate float64
+	// Buffer is the number of frames which can wait in the channel for the receiver. It defaults to 1.
+	Buffer int
+	// Block makes the stream wait for the receiver when the channel is full. By default, the frame
+	// is dropped instead, so that a slow receiver always gets recent frames.
+	Block bool
+	// OnlyChanges makes the stream deliver a frame only when the region changed, and re-read only the
+	// changed parts of it. Periods without change are skipped without being counted as dropped.
+	// Moving the cursor alone is not a change. It is currently supported on X11 only, through the
+	// DAMAGE extension.
+	OnlyChanges bool
+}
+
+// StreamFrame is a frame delivered by a FrameStream.
+type StreamFrame struct {
+	Image *image.RGBA
+	// Timestamp is the time elapsed between the start of the stream and the capture of the frame,
+	// measured with the monotonic clock.
+	Timestamp time.Duration
+	// Sequence is the index of the frame period the frame was captured in. It increases by more than 1
+	// when frames were dropped in between.
+	Sequence uint64
+	// Dropped is the number of frames dropped since the previous delivered frame, either because the
+	// capture was slower than the frame rate or because the receiver was not keeping up.
+	Dropped uint64
+	// Dirty lists the regions of Image which changed since the previous delivered frame. It covers the
+	// whole image unless StreamOptions.OnlyChanges is set.
+	Dirty []image.Rectangle
+
+	stream *FrameStream
+}
+
+// Release hands the image back to the stream, which reuses it for a later frame instead of allocating
+// a new one. Calling Release is optional; the frame must not be used afterwards.
+func (f *StreamFrame) Release() {
+	if f.stream == nil || f.Image == nil {
+		return
+	}
+	select {
+	case f.stream.pool <- f.Image:
+	default:
+	}
+	f.Image = nil
+}
+
+// StreamStats summarizes the frames of a FrameStream.
+type StreamStats struct {
+	// Delivered is the number of frames sent to the channel.
+	Delivered uint64
+	// Dropped is the number of frame periods for which no frame was delivered.
+	Dropped uint64
+}
+
+// FrameStream captures a region of the desktop continuously at a fixed frame rate.
+type FrameStream struct {
+	frames chan *StreamFrame
+	pool   chan *image.RGBA
+	rect   image.Rectangle
+	opts   StreamOptions
+
+	mu    sync.Mutex
+	err   error
+	stats StreamStats
+}
+
+// Stream starts capturing rect continuously, until ctx is done or a capture fails.
+// It uses a Capturer of its own, which is closed when the stream ends. On Wayland, the Capturer
+// uses a ScreenCast session, see WithScreenCast.
+func Stream(ctx context.Context, rect image.Rectangle, opts StreamOptions) (*FrameStream, error) {
+	c, err := NewCapturer(WithScreenCast(""))
+	if err != nil {
+		return nil, err
+	}
+	source, release, err := c.frameSource(rect, opts)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	s := newFrameStream(rect, opts)
+	go func() {
+		defer c.Close()
+		defer release()
+		s.run(ctx, source)
+	}()
+	return s, nil
+}
+
+// Stream starts capturing rect continuously, until ctx is done or a capture fails.
+// The Capturer can still be used while the stream is running.
+func (c *Capturer) Stream(ctx context.Context, rect image.Rectangle, opts StreamOptions) (*FrameStream, error) {
+	source, release, err := c.frameSource(rect, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := newFrameStream(rect, opts)
+	go func() {
+		defer release()
+		s.run(ctx, source)
+	}()
+	return s, nil
+}
+
+// frameSource captures the next frame into dst. It returns the regions of dst which changed since the
+// previous call, and changed = false if there is no need to deliver a frame.
+type frameSource func(ctx context.Context, dst *image.RGBA) (dirty []image.Rectangle, changed bool, err error)
+
+// frameSource returns the source of the frames of a stream, along with a function releasing its resources.
+func (c *Capturer) frameSource(rect image.Rectangle, opts StreamOptions) (frameSource, func(), error) {
+	if !opts.OnlyChanges {
+		// Fail early if the Capturer is already closed.
+		if err := c.withSession(func(s *session) error { return nil }); err != nil {
+			return nil, nil, err
+		}
+		return func(ctx context.Context, dst *image.RGBA) ([]image.Rectangle, bool, error) {
+			err := c.captureInto(ctx, dst, rect)
+			return []image.Rectangle{dst.Bounds()}, err == nil, err
+		}, func() {}, nil
+	}
+
+	// Start tracking before the first capture, so that no change goes unnoticed.
+	var tracker changeTracker
+	err := c.withSession(func(s *session) error {
+		var err error
+		tracker, err = s.trackChanges()
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := createImage(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	if err != nil {
+		tracker.close()
+		return nil, nil, err
+	}
+
+	first := true
+	return func(ctx context.Context, dst *image.RGBA) ([]image.Rectangle, bool, error) {
+		dirty := []image.Rectangle{current.Bounds()}
+		if !first {
+			dirty = tracker.take(rect)
+			if len(dirty) == 0 {
+				return nil, false, nil
+			}
+			for i := range dirty {
+				dirty[i] = dirty[i].Sub(rect.Min)
+			}
+		}
+		err := c.withSession(func(s *session) error {
+			// Only the changed parts are read, into the copy of the region kept between frames.
+			// The cursor is left out of it and drawn on each frame instead.
+			for _, d := range dirty {
+				sub := current.SubImage(d).(*image.RGBA)
+				if err := s.captureInto(ctx, sub, d.Add(rect.Min)); err != nil {
+					return err
+				}
+			}
+			copy(dst.Pix, current.Pix)
+			return c.drawCursor(s, dst, rect)
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		first = false
+		return dirty, true, nil
+	}, tracker.close, nil
+}
+
+// changeTracker records the regions of the desktop which changed.
+type changeTracker interface {
+	// take returns the parts of rect which changed since the previous call, and forgets every change.
+	take(rect image.Rectangle) []image.Rectangle
+	// wait blocks until a part of rect changes, and returns it like take.
+	// It fails with ErrClosed once the tracker is closed.
+	wait(ctx context.Context, rect image.Rectangle) ([]image.Rectangle, error)
+	close()
+}
+
+// WaitForChange blocks until a part of rect changes, and returns the changed parts.
+// It is currently supported on X11 only, through the DAMAGE extension.
+func WaitForChange(ctx context.Context, rect image.Rectangle) ([]image.Rectangle, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	tracker, err := s.trackChanges()
+	if err != nil {
+		return nil, err
+	}
+	defer tracker.close()
+	return tracker.wait(ctx, rect)
+}
+
+// WaitForChange blocks until a part of rect changes, and returns the changed parts. Changes happening
+// between two calls are not missed, but changes outside of rect are discarded.
+// The Capturer can still be used while waiting.
+func (c *Capturer) WaitForChange(ctx context.Context, rect image.Rectangle) ([]image.Rectangle, error) {
+	var tracker changeTracker
+	err := c.withSession(func(s *session) error {
+		if c.tracker == nil {
+			var err error
+			c.tracker, err = s.trackChanges()
+			if err != nil {
+				return err
+			}
+		}
+		tracker = c.tracker
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tracker.wait(ctx, rect)
+}
+
+func newFrameStream(rect image.Rectangle, opts StreamOptions) *FrameStream {
+	if opts.FrameRate <= 0 {
+		opts.FrameRate = 30
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 1
+	}
+	return &FrameStream{
+		frames: make(chan *StreamFrame, opts.Buffer),
+		pool:   make(chan *image.RGBA, opts.Buffer+1),
+		rect:   rect,
+		opts:   opts,
+	}
+}
+
+// Frames returns the channel delivering the frames. It is closed when the stream ends.
+func (s *FrameStream) Frames() <-chan *StreamFrame {
+	return s.frames
+}
+
+// Err returns the error which ended the stream, which is the error of the context if it is done.
+// It returns nil while the stream is running.
+func (s *FrameStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Stats returns the number of frames delivered and dropped so far.
+func (s *FrameStream) Stats() StreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *FrameStream) image() (*image.RGBA, error) {
+	select {
+	case img := <-s.pool:
+		return img, nil
+	default:
+		return createImage(image.Rect(0, 0, s.rect.Dx(), s.rect.Dy()))
+	}
+}
+
+func (s *FrameStream) finish(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	close(s.frames)
+}
+
+func (s *FrameStream) run(ctx context.Context, source frameSource) {
+	interval := time.Duration(float64(time.Second) / s.opts.FrameRate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var next uint64 // the first frame period which has not been accounted for yet
+	var dropped uint64
+	tick := func() uint64 {
+		slot := uint64(time.Since(start) / interval)
+		if slot < next {
+			slot = next
+		}
+		// The ticker skips the periods during which the capture or the receiver was blocking us.
+		dropped += slot - next
+		s.mu.Lock()
+		s.stats.Dropped += slot - next
+		s.mu.Unlock()
+		next = slot + 1
+		return slot
+	}
+
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-ctx.Done():
+				s.finish(ctx.Err())
+				return
+			case <-ticker.C:
+			}
+		}
+		slot := tick()
+
+		if !s.opts.Block && len(s.frames) == cap(s.frames) {
+			dropped++
+			s.mu.Lock()
+			s.stats.Dropped++
+			s.mu.Unlock()
+			continue
+		}
+
+		img, err := s.image()
+		if err != nil {
+			s.finish(err)
+			return
+		}
+		timestamp := time.Since(start)
+		dirty, changed, err := source(ctx, img)
+		if err != nil {
+			s.finish(err)
+			return
+		}
+		if !changed {
+			// img may have been allocated while frames were out, which the receiver may have released
+			// since, so the pool can be full.
+			select {
+			case s.pool <- img:
+			default:
+			}
+			continue
+		}
+
+		frame := &StreamFrame{
+			Image:     img,
+			Timestamp: timestamp,
+			Sequence:  slot,
+			Dropped:   dropped,
+			Dirty:     dirty,
+			stream:    s,
+		}
+		// This goroutine is the only sender, so the send only blocks in Block mode, waiting for the receiver.
+		select {
+		case s.frames <- frame:
+		case <-ctx.Done():
+			s.finish(ctx.Err())
+			return
+		}
+		dropped = 0
+		s.mu.Lock()
+		s.stats.Delivered++
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,135 @@
+package screenshot
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+	"time"
+)
+
+func TestFrameStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newFrameStream(image.Rect(0, 0, 4, 4), StreamOptions{FrameRate: 200})
+	captured := 0
+	go s.run(ctx, func(ctx context.Context, dst *image.RGBA) ([]image.Rectangle, bool, error) {
+		captured++
+		dst.Pix[0] = byte(captured)
+		return []image.Rectangle{dst.Bounds()}, true, nil
+	})
+
+	var last *StreamFrame
+	for i := 0; i < 5; i++ {
+		frame := <-s.Frames()
+		if last != nil {
+			if frame.Sequence <= last.Sequence || frame.Timestamp <= last.Timestamp {
+				t.Errorf("frame %d (seq %d, %v) is not after frame (seq %d, %v)", i, frame.Sequence, frame.Timestamp, last.Sequence, last.Timestamp)
+			}
+			if frame.Sequence-last.Sequence-1 != frame.Dropped {
+				t.Errorf("frame %d: Dropped = %d, sequence went from %d to %d", i, frame.Dropped, last.Sequence, frame.Sequence)
+			}
+			last.Release()
+		}
+		last = frame
+		// Receive slowly, so that frames get dropped.
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	for range s.Frames() {
+	}
+	if !errors.Is(s.Err(), context.Canceled) {
+		t.Errorf("Err() = %v", s.Err())
+	}
+	if stats := s.Stats(); stats.Dropped == 0 || stats.Delivered < 5 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestFrameStreamCaptureError(t *testing.T) {
+	want := errors.New("capture failed")
+	s := newFrameStream(image.Rect(0, 0, 4, 4), StreamOptions{Block: true})
+	go s.run(context.Background(), func(ctx context.Context, dst *image.RGBA) ([]image.Rectangle, bool, error) {
+		return nil, false, want
+	})
+	for range s.Frames() {
+		t.Error("unexpected frame")
+	}
+	if s.Err() != want {
+		t.Errorf("Err() = %v, want %v", s.Err(), want)
+	}
+}
+
+func TestFrameStreamOnlyChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newFrameStream(image.Rect(0, 0, 4, 4), StreamOptions{FrameRate: 200, Block: true})
+	dirty := image.Rect(1, 1, 2, 2)
+	captured := 0
+	go s.run(ctx, func(ctx context.Context, dst *image.RGBA) ([]image.Rectangle, bool, error) {
+		captured++
+		// Only every third period has a change.
+		if captured%3 != 0 {
+			return nil, false, nil
+		}
+		return []image.Rectangle{dirty}, true, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		frame := <-s.Frames()
+		if len(frame.Dirty) != 1 || frame.Dirty[0] != dirty {
+			t.Errorf("frame %d: Dirty = %v, want [%v]", i, frame.Dirty, dirty)
+		}
+		frame.Release()
+	}
+	cancel()
+	for range s.Frames() {
+	}
+	if captured < 9 {
+		t.Errorf("the source was called %d times for 3 changes", captured)
+	}
+}
+
+func TestFrameStreamReleaseWhileUnchanged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newFrameStream(image.Rect(0, 0, 4, 4), StreamOptions{FrameRate: 200, Buffer: 2, Block: true})
+	calling, proceed := make(chan struct{}), make(chan struct{})
+	captured := 0
+	go s.run(ctx, func(ctx context.Context, dst *image.RGBA) ([]image.Rectangle, bool, error) {
+		captured++
+		if captured <= 3 {
+			return []image.Rectangle{dst.Bounds()}, true, nil
+		}
+		if captured == 4 {
+			// The image of this call was allocated, since the receiver holds the others.
+			close(calling)
+			<-proceed
+		}
+		return nil, false, nil
+	})
+
+	var frames []*StreamFrame
+	for i := 0; i < 3; i++ {
+		frames = append(frames, <-s.Frames())
+	}
+	<-calling
+	for _, frame := range frames {
+		frame.Release()
+	}
+	close(proceed)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range s.Frames() {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not end after its context was canceled")
+	}
+}
@@ -3,6 +3,7 @@
 package screenshot
 
 import (
+	"context"
 	"image"
 )
 
@@ -23,3 +24,61 @@ func NumActiveDisplays() int {
 func GetDisplayBounds(displayIndex int) image.Rectangle {
 	return image.Rectangle{}
 }
+
+type session struct {
+	opts options
+}
+
+func newSession(opts options) (*session, error) {
+	return nil, ErrUnsupported
+}
+
+var gBackends []Backend
+
+func (s *session) backendChain() []Backend {
+	return nil
+}
+
+func (s *session) selectBackend(ctx context.Context) (Backend, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) captureInto(ctx context.Context, dst *image.RGBA, rect image.Rectangle) error {
+	return ErrUnsupported
+}
+
+func (s *session) displayCount(ctx context.Context) (int, error) {
+	return 0, ErrUnsupported
+}
+
+func (s *session) displayBounds(ctx context.Context, displayIndex int) (image.Rectangle, error) {
+	return image.Rectangle{}, ErrUnsupported
+}
+
+func (s *session) displays(ctx context.Context) ([]Display, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) captureCursor() (*Cursor, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) trackChanges() (changeTracker, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) watchDisplays(ctx context.Context) (<-chan DisplayEvent, error) {
+	return nil, ErrUnsupported
+}
+
+func capturePortal(ctx context.Context, opts PortalOptions) (*image.RGBA, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) restoreToken() string {
+	return ""
+}
+
+func (s *session) close() error {
+	return nil
+}
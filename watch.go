@@ -0,0 +1,98 @@
+package screenshot
+
+import (
+	"context"
+	"fmt"
+)
+
+// DisplayEventKind tells how a display changed.
+type DisplayEventKind int
+
+const (
+	// DisplayAdded reports a display which was enabled, e.g. a monitor plugged in.
+	DisplayAdded DisplayEventKind = iota
+	// DisplayRemoved reports a display which went away.
+	DisplayRemoved
+	// DisplayChanged reports a display whose bounds, index or another detail changed.
+	DisplayChanged
+)
+
+func (k DisplayEventKind) String() string {
+	switch k {
+	case DisplayAdded:
+		return "added"
+	case DisplayRemoved:
+		return "removed"
+	case DisplayChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("DisplayEventKind(%d)", int(k))
+}
+
+// DisplayEvent is a change of the displays reported by WatchDisplays.
+type DisplayEvent struct {
+	Kind DisplayEventKind
+	// Old is the display before the change. It is zero for DisplayAdded.
+	Old Display
+	// New is the display after the change. It is zero for DisplayRemoved.
+	New Display
+}
+
+// WatchDisplays reports the changes of the displays, e.g. a monitor being plugged in or its resolution
+// changing, on the returned channel, which is closed once ctx is done, or once the displays cannot be
+// enumerated any more, e.g. because the display server went away, which the caller tells apart by ctx
+// not being done. It is currently supported on X11 only, through the RandR extension.
+func WatchDisplays(ctx context.Context) (<-chan DisplayEvent, error) {
+	s, err := newSession(options{})
+	if err != nil {
+		return nil, err
+	}
+	// The watch uses a connection of its own, which outlives the session.
+	defer s.close()
+	return s.watchDisplays(ctx)
+}
+
+// WatchDisplays is like the package-level WatchDisplays, for the display server of the Capturer.
+// The watch goes on after Close, until ctx is done.
+func (c *Capturer) WatchDisplays(ctx context.Context) (events <-chan DisplayEvent, err error) {
+	err = c.withSession(func(s *session) error {
+		events, err = s.watchDisplays(ctx)
+		return err
+	})
+	return events, err
+}
+
+// displayKey identifies a display across enumerations.
+type displayKey struct {
+	screen int
+	id     string
+}
+
+// diffDisplays returns the events turning the displays old into new: the removed displays first, then
+// the changed and added ones in the order of new.
+func diffDisplays(old, new []Display) []DisplayEvent {
+	before := make(map[displayKey]Display, len(old))
+	for _, d := range old {
+		before[displayKey{d.Screen, d.ID}] = d
+	}
+	after := make(map[displayKey]bool, len(new))
+	for _, d := range new {
+		after[displayKey{d.Screen, d.ID}] = true
+	}
+	var events []DisplayEvent
+	for _, d := range old {
+		if !after[displayKey{d.Screen, d.ID}] {
+			events = append(events, DisplayEvent{Kind: DisplayRemoved, Old: d})
+		}
+	}
+	for _, d := range new {
+		prev, ok := before[displayKey{d.Screen, d.ID}]
+		switch {
+		case !ok:
+			events = append(events, DisplayEvent{Kind: DisplayAdded, New: d})
+		case prev != d:
+			events = append(events, DisplayEvent{Kind: DisplayChanged, Old: prev, New: d})
+		}
+	}
+	return events
+}
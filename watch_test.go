@@ -0,0 +1,31 @@
+package screenshot
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestDiffDisplays(t *testing.T) {
+	left := Display{Index: 0, ID: "63", Name: "DP-1", Primary: true, Bounds: image.Rect(0, 0, 1920, 1080)}
+	right := Display{Index: 1, ID: "64", Name: "HDMI-1", Bounds: image.Rect(1920, 0, 3840, 1080)}
+	other := Display{Index: 0, ID: "64", Screen: 1, Bounds: image.Rect(0, 0, 1024, 768)}
+
+	// The resolution of the left display changes, the right one is unplugged, and another one is plugged
+	// into the second X screen, whose output has the same ID.
+	resized := left
+	resized.Bounds = image.Rect(0, 0, 2560, 1440)
+	got := diffDisplays([]Display{left, right}, []Display{resized, other})
+	want := []DisplayEvent{
+		{Kind: DisplayRemoved, Old: right},
+		{Kind: DisplayChanged, Old: left, New: resized},
+		{Kind: DisplayAdded, New: other},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffDisplays() = %+v, want %+v", got, want)
+	}
+
+	if got := diffDisplays([]Display{left, right}, []Display{left, right}); len(got) != 0 {
+		t.Errorf("diffDisplays() of the same displays = %+v, want none", got)
+	}
+}
@@ -3,9 +3,11 @@
 package screenshot
 
 import (
+	"context"
 	"errors"
 	"github.com/lxn/win"
 	"image"
+	"strings"
 	"syscall"
 	"unsafe"
 )
@@ -16,6 +18,8 @@ var (
 	funcEnumDisplayMonitors, _ = syscall.GetProcAddress(syscall.Handle(libUser32), "EnumDisplayMonitors")
 	funcGetMonitorInfo, _      = syscall.GetProcAddress(syscall.Handle(libUser32), "GetMonitorInfoW")
 	funcEnumDisplaySettings, _ = syscall.GetProcAddress(syscall.Handle(libUser32), "EnumDisplaySettingsW")
+	libShcore, _               = syscall.LoadLibrary("shcore.dll")
+	funcGetDpiForMonitor, _    = syscall.GetProcAddress(syscall.Handle(libShcore), "GetDpiForMonitor")
 )
 
 func Capture(x, y, width, height int) (*image.RGBA, error) {
@@ -24,23 +28,34 @@ func Capture(x, y, width, height int) (*image.RGBA, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = captureInto(img, false, image.Rect(x, y, x+width, y+height))
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// captureInto reads rect of the desktop into img, in the BGRA byte order if bgra is set.
+func captureInto(img *image.RGBA, bgra bool, rect image.Rectangle) error {
+	x, y := rect.Min.X, rect.Min.Y
+	width, height := rect.Dx(), rect.Dy()
 
 	hwnd := getDesktopWindow()
 	hdc := win.GetDC(hwnd)
 	if hdc == 0 {
-		return nil, errors.New("GetDC failed")
+		return errors.New("GetDC failed")
 	}
 	defer win.ReleaseDC(hwnd, hdc)
 
 	memory_device := win.CreateCompatibleDC(hdc)
 	if memory_device == 0 {
-		return nil, errors.New("CreateCompatibleDC failed")
+		return errors.New("CreateCompatibleDC failed")
 	}
 	defer win.DeleteDC(memory_device)
 
 	bitmap := win.CreateCompatibleBitmap(hdc, int32(width), int32(height))
 	if bitmap == 0 {
-		return nil, errors.New("CreateCompatibleBitmap failed")
+		return errors.New("CreateCompatibleBitmap failed")
 	}
 	defer win.DeleteObject(win.HGDIOBJ(bitmap))
 
@@ -64,35 +79,27 @@ func Capture(x, y, width, height int) (*image.RGBA, error) {
 
 	old := win.SelectObject(memory_device, win.HGDIOBJ(bitmap))
 	if old == 0 {
-		return nil, errors.New("SelectObject failed")
+		return errors.New("SelectObject failed")
 	}
 	defer win.SelectObject(memory_device, old)
 
 	if !win.BitBlt(memory_device, 0, 0, int32(width), int32(height), hdc, int32(x), int32(y), win.SRCCOPY) {
-		return nil, errors.New("BitBlt failed")
+		return errors.New("BitBlt failed")
 	}
 
 	if win.GetDIBits(hdc, bitmap, 0, uint32(height), (*uint8)(memptr), (*win.BITMAPINFO)(unsafe.Pointer(&header)), win.DIB_RGB_COLORS) == 0 {
-		return nil, errors.New("GetDIBits failed")
+		return errors.New("GetDIBits failed")
 	}
 
-	i := 0
-	src := uintptr(memptr)
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			v0 := *(*uint8)(unsafe.Pointer(src))
-			v1 := *(*uint8)(unsafe.Pointer(src + 1))
-			v2 := *(*uint8)(unsafe.Pointer(src + 2))
-
-			// BGRA => RGBA, and set A to 255
-			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = v2, v1, v0, 255
-
-			i += 4
-			src += 4
-		}
+	// BGRA => RGBA, and set A to 255
+	op := pixelSwap | pixelOpaque
+	if bgra {
+		op = pixelOpaque
 	}
+	src := unsafe.Slice((*byte)(memptr), bitmapDataSize)
+	convertPixels(img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y):], img.Stride, src, width*4, width, height, op)
 
-	return img, nil
+	return nil
 }
 
 func getDesktopWindow() win.HWND {
@@ -148,15 +155,20 @@ type _MONITORINFOEX struct {
 
 const _ENUM_CURRENT_SETTINGS = 0xFFFFFFFF
 
+const _MDT_EFFECTIVE_DPI = 0
+
 type _DEVMODE struct {
-	_            [68]byte
-	DmSize       uint16
-	_            [6]byte
-	DmPosition   win.POINT
-	_            [86]byte
-	DmPelsWidth  uint32
-	DmPelsHeight uint32
-	_            [40]byte
+	_                    [68]byte
+	DmSize               uint16
+	_                    [6]byte
+	DmPosition           win.POINT
+	DmDisplayOrientation uint32
+	_                    [84]byte
+	DmPelsWidth          uint32
+	DmPelsHeight         uint32
+	_                    [4]byte
+	DmDisplayFrequency   uint32
+	_                    [32]byte
 }
 
 // getMonitorRealSize makes a call to GetMonitorInfo
@@ -173,25 +185,200 @@ type _DEVMODE struct {
 // returned by EnumDisplayMonitors which may be affected
 // by DPI.
 func getMonitorRealSize(hMonitor win.HMONITOR) *win.RECT {
+	info, devMode := getMonitorSettings(hMonitor)
+	if info == nil || devMode == nil {
+		return nil
+	}
+
+	return &win.RECT{
+		Left:   devMode.DmPosition.X,
+		Right:  devMode.DmPosition.X + int32(devMode.DmPelsWidth),
+		Top:    devMode.DmPosition.Y,
+		Bottom: devMode.DmPosition.Y + int32(devMode.DmPelsHeight),
+	}
+}
+
+// getMonitorSettings returns the monitor info and the current display settings of hMonitor.
+// Either of them is nil if the corresponding call fails.
+func getMonitorSettings(hMonitor win.HMONITOR) (*_MONITORINFOEX, *_DEVMODE) {
 	info := _MONITORINFOEX{}
 	info.CbSize = uint32(unsafe.Sizeof(info))
 
 	ret, _, _ := syscall.Syscall(funcGetMonitorInfo, 2, uintptr(hMonitor), uintptr(unsafe.Pointer(&info)), 0)
 	if ret == 0 {
-		return nil
+		return nil, nil
 	}
 
 	devMode := _DEVMODE{}
 	devMode.DmSize = uint16(unsafe.Sizeof(devMode))
 
 	if ret, _, _ := syscall.Syscall(funcEnumDisplaySettings, 3, uintptr(unsafe.Pointer(&info.DeviceName[0])), _ENUM_CURRENT_SETTINGS, uintptr(unsafe.Pointer(&devMode))); ret == 0 {
-		return nil
+		return &info, nil
 	}
 
-	return &win.RECT{
-		Left:   devMode.DmPosition.X,
-		Right:  devMode.DmPosition.X + int32(devMode.DmPelsWidth),
-		Top:    devMode.DmPosition.Y,
-		Bottom: devMode.DmPosition.Y + int32(devMode.DmPelsHeight),
+	return &info, &devMode
+}
+
+// getMonitorScale returns the DPI scale factor of hMonitor, or 1 if it cannot be determined,
+// e.g. on Windows 7 where GetDpiForMonitor is unavailable.
+func getMonitorScale(hMonitor win.HMONITOR) float64 {
+	if funcGetDpiForMonitor == 0 {
+		return 1
+	}
+	var dpiX, dpiY uint32
+	ret, _, _ := syscall.Syscall6(funcGetDpiForMonitor, 4, uintptr(hMonitor), _MDT_EFFECTIVE_DPI,
+		uintptr(unsafe.Pointer(&dpiX)), uintptr(unsafe.Pointer(&dpiY)), 0, 0)
+	if ret != 0 || dpiX == 0 {
+		return 1
+	}
+	return float64(dpiX) / 96
+}
+
+func collectMonitorCallback(hMonitor win.HMONITOR, hdcMonitor win.HDC, lprcMonitor *win.RECT, dwData uintptr) uintptr {
+	var ctx *collectMonitorsContext
+	ctx = (*collectMonitorsContext)(unsafe.Pointer(dwData))
+	ctx.Monitors = append(ctx.Monitors, hMonitor)
+	return uintptr(1)
+}
+
+type collectMonitorsContext struct {
+	Monitors []win.HMONITOR
+}
+
+// displays describes the monitors in the order of EnumDisplayMonitors, which is the order
+// used by GetDisplayBounds.
+func displays() ([]Display, error) {
+	monitors := enumMonitors()
+	ret := make([]Display, 0, len(monitors))
+	for i, hMonitor := range monitors {
+		d := Display{
+			Index:       i,
+			Bounds:      GetDisplayBounds(i),
+			ScaleFactor: getMonitorScale(hMonitor),
+		}
+		info, devMode := getMonitorSettings(hMonitor)
+		if info != nil {
+			d.ID = syscall.UTF16ToString(info.DeviceName[:])
+			d.Name = strings.TrimPrefix(d.ID, `\\.\`)
+			d.Primary = info.DwFlags&win.MONITORINFOF_PRIMARY != 0
+		}
+		if devMode != nil {
+			d.Rotation = Rotation(devMode.DmDisplayOrientation * 90)
+			// 0 and 1 stand for the hardware default refresh rate.
+			if devMode.DmDisplayFrequency > 1 {
+				d.RefreshRate = float64(devMode.DmDisplayFrequency)
+			}
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// session holds the resources shared by the calls made through a Capturer.
+// GDI handles are cheap to obtain, so there is nothing to keep between calls.
+type session struct {
+	opts options
+}
+
+func newSession(opts options) (*session, error) {
+	return &session{opts: opts}, nil
+}
+
+// gdiBackend captures the desktop through GDI.
+type gdiBackend struct{}
+
+var gBackends = []Backend{&gdiBackend{}}
+
+func (b *gdiBackend) Name() string {
+	return "gdi"
+}
+
+func (b *gdiBackend) probe(ctx context.Context, s *session) error {
+	return nil
+}
+
+// captureInto cannot interrupt the capture, so ctx is only checked before it starts.
+func (b *gdiBackend) captureInto(ctx context.Context, s *session, dst *image.RGBA, rect image.Rectangle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return captureInto(dst, false, rect)
+}
+
+func (b *gdiBackend) captureBGRAInto(ctx context.Context, s *session, dst *BGRA, rect image.Rectangle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return captureInto(dst.rgbaView(), true, rect)
+}
+
+// backendChain returns the backends probed in turn when none is selected by name.
+func (s *session) backendChain() []Backend {
+	return gBackends
+}
+
+func (s *session) selectBackend(ctx context.Context) (Backend, error) {
+	return chooseBackend(ctx, s)
+}
+
+func (s *session) captureInto(ctx context.Context, dst *image.RGBA, rect image.Rectangle) error {
+	b, err := s.selectBackend(ctx)
+	if err != nil {
+		return err
+	}
+	return b.captureInto(ctx, s, dst, rect)
+}
+
+// displayCount and the other enumerations cannot be interrupted, so ctx is only checked before them.
+func (s *session) displayCount(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	n := NumActiveDisplays()
+	if n == 0 {
+		return 0, ErrNoDisplay
+	}
+	return n, nil
+}
+
+func (s *session) displayBounds(ctx context.Context, displayIndex int) (image.Rectangle, error) {
+	n, err := s.displayCount(ctx)
+	if err != nil {
+		return image.Rectangle{}, err
 	}
+	if err := checkDisplayIndex(displayIndex, n); err != nil {
+		return image.Rectangle{}, err
+	}
+	return GetDisplayBounds(displayIndex), nil
+}
+
+func (s *session) displays(ctx context.Context) ([]Display, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return displays()
+}
+
+func (s *session) captureCursor() (*Cursor, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) trackChanges() (changeTracker, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) watchDisplays(ctx context.Context) (<-chan DisplayEvent, error) {
+	return nil, ErrUnsupported
+}
+
+func capturePortal(ctx context.Context, opts PortalOptions) (*image.RGBA, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *session) restoreToken() string {
+	return ""
+}
+
+func (s *session) close() error {
+	return nil
 }
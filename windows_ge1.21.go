@@ -35,3 +35,13 @@ func GetDisplayBounds(displayIndex int) image.Rectangle {
 		int(ctx.Rect.Left), int(ctx.Rect.Top),
 		int(ctx.Rect.Right), int(ctx.Rect.Bottom))
 }
+
+func enumMonitors() []win.HMONITOR {
+	ctx := new(collectMonitorsContext)
+	pinner := new(runtime.Pinner)
+	pinner.Pin(ctx)
+	defer pinner.Unpin()
+	ptr := unsafe.Pointer(ctx)
+	enumDisplayMonitors(win.HDC(0), nil, syscall.NewCallback(collectMonitorCallback), uintptr(ptr))
+	return ctx.Monitors
+}
@@ -28,3 +28,10 @@ func GetDisplayBounds(displayIndex int) image.Rectangle {
 		int(ctx.Rect.Left), int(ctx.Rect.Top),
 		int(ctx.Rect.Right), int(ctx.Rect.Bottom))
 }
+
+func enumMonitors() []win.HMONITOR {
+	var ctx collectMonitorsContext
+	ptr := unsafe.Pointer(&ctx)
+	enumDisplayMonitors(win.HDC(0), nil, syscall.NewCallback(collectMonitorCallback), uintptr(ptr))
+	return ctx.Monitors
+}
@@ -0,0 +1,169 @@
+package screenshot
+
+import (
+	"image"
+	"math"
+)
+
+// ColorMatrix is the set of coefficients converting RGB into YUV.
+type ColorMatrix int
+
+const (
+	// BT601 is the matrix of standard definition video, which decoders assume in the absence of
+	// other information.
+	BT601 ColorMatrix = iota
+	// BT709 is the matrix of high definition video.
+	BT709
+)
+
+// YUVOptions configures the conversion of captures into YUV. The zero value is BT.601 in the
+// limited range, which is what most video encoders expect by default.
+type YUVOptions struct {
+	Matrix ColorMatrix
+	// FullRange makes the samples use the whole 0-255 range, like JPEG. Otherwise they use the
+	// limited range of video, 16-235 for Y and 16-240 for Cb and Cr.
+	FullRange bool
+}
+
+// yuvCoefficients are the coefficients of a YUVOptions in 16.16 fixed point, with the offsets of the
+// samples and the rounding folded into yo and co.
+type yuvCoefficients struct {
+	yr, yg, yb, yo int32
+	ur, ug, ub     int32
+	vr, vg, vb     int32
+	co             int32
+}
+
+func (o YUVOptions) coefficients() yuvCoefficients {
+	kr, kb := 0.299, 0.114
+	if o.Matrix == BT709 {
+		kr, kb = 0.2126, 0.0722
+	}
+	kg := 1 - kr - kb
+	ys, cs, yoff := 1.0, 1.0, 0.0
+	if !o.FullRange {
+		ys, cs, yoff = 219.0/255, 224.0/255, 16
+	}
+	fix := func(f float64) int32 {
+		return int32(math.Round(f * 65536))
+	}
+	return yuvCoefficients{
+		yr: fix(kr * ys), yg: fix(kg * ys), yb: fix(kb * ys), yo: fix(yoff + 0.5),
+		ur: fix(-kr / (2 * (1 - kb)) * cs), ug: fix(-kg / (2 * (1 - kb)) * cs), ub: fix(0.5 * cs),
+		vr: fix(0.5 * cs), vg: fix(-kg / (2 * (1 - kr)) * cs), vb: fix(-kb / (2 * (1 - kr)) * cs),
+		co: fix(128.5),
+	}
+}
+
+func (c *yuvCoefficients) luma(r, g, b uint8) uint8 {
+	return clampSample((c.yr*int32(r) + c.yg*int32(g) + c.yb*int32(b) + c.yo) >> 16)
+}
+
+// chroma returns the chroma samples of the average color of n pixels, whose channels add up to r, g and b.
+func (c *yuvCoefficients) chroma(r, g, b, n int32) (cb, cr uint8) {
+	if n == 4 {
+		r, g, b = (r+2)>>2, (g+2)>>2, (b+2)>>2
+	} else {
+		r, g, b = (r+n/2)/n, (g+n/2)/n, (b+n/2)/n
+	}
+	cb = clampSample((c.ur*r + c.ug*g + c.ub*b + c.co) >> 16)
+	cr = clampSample((c.vr*r + c.vg*g + c.vb*b + c.co) >> 16)
+	return cb, cr
+}
+
+func clampSample(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// isYUV reports whether f is one of the YUV formats.
+func (f PixelFormat) isYUV() bool {
+	return f == PixelFormatI420 || f == PixelFormatNV12
+}
+
+// newYUVFrame allocates a frame in format, PixelFormatI420 or PixelFormatNV12, with the planes laid out
+// one after the other in a single buffer.
+func newYUVFrame(format PixelFormat, width, height int, opts YUVOptions) *Frame {
+	cw, ch := (width+1)/2, (height+1)/2
+	ySize, cSize := width*height, cw*ch
+	buf := make([]byte, ySize+2*cSize)
+	f := &Frame{Pix: buf[:ySize], Stride: width, Width: width, Height: height, Format: format, YUV: opts}
+	if format == PixelFormatNV12 {
+		f.Cb, f.CStride = buf[ySize:], 2*cw
+	} else {
+		f.Cb, f.Cr, f.CStride = buf[ySize:ySize+cSize], buf[ySize+cSize:], cw
+	}
+	return f
+}
+
+// convertYUV converts src, a frame of 4-byte pixels, into dst, a YUV frame of the same size. The
+// chroma samples are those of the average color of each 2x2 block. Large frames are converted by as
+// many goroutines as there are CPUs.
+func convertYUV(dst, src *Frame) {
+	c := dst.YUV.coefficients()
+	rOff, bOff := 0, 2
+	if src.Format == PixelFormatBGRA {
+		rOff, bOff = 2, 0
+	}
+	width, height := src.Width, src.Height
+	parallelRows(width, height, 2, func(y0, y1 int) {
+		for y := y0; y < y1; y += 2 {
+			rows := min(2, height-y)
+			for i := 0; i < rows; i++ {
+				s := src.Pix[(y+i)*src.Stride:][:4*width]
+				d := dst.Pix[(y+i)*dst.Stride:][:width]
+				for x := range d {
+					p := s[4*x : 4*x+4]
+					d[x] = c.luma(p[rOff], p[1], p[bOff])
+				}
+			}
+			cy := y / 2
+			for x := 0; x < width; x += 2 {
+				var r, g, b, n int32
+				for i := 0; i < rows; i++ {
+					s := src.Pix[(y+i)*src.Stride:]
+					for j := x; j < min(x+2, width); j++ {
+						p := s[4*j : 4*j+4]
+						r, g, b, n = r+int32(p[rOff]), g+int32(p[1]), b+int32(p[bOff]), n+1
+					}
+				}
+				cb, cr := c.chroma(r, g, b, n)
+				if dst.Format == PixelFormatNV12 {
+					dst.Cb[cy*dst.CStride+x], dst.Cb[cy*dst.CStride+x+1] = cb, cr
+				} else {
+					dst.Cb[cy*dst.CStride+x/2], dst.Cr[cy*dst.CStride+x/2] = cb, cr
+				}
+			}
+		}
+	})
+}
+
+// yCbCr returns the YUV frame f as an image.YCbCr, sharing the planes of an I420 frame and copying
+// those of an NV12 frame, which image.YCbCr cannot represent.
+func (f *Frame) yCbCr() *image.YCbCr {
+	img := &image.YCbCr{
+		Y:              f.Pix,
+		Cb:             f.Cb,
+		Cr:             f.Cr,
+		YStride:        f.Stride,
+		CStride:        f.CStride,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Rect:           image.Rect(0, 0, f.Width, f.Height),
+	}
+	if f.Format == PixelFormatNV12 {
+		cw, ch := (f.Width+1)/2, (f.Height+1)/2
+		img.Cb, img.Cr, img.CStride = make([]byte, cw*ch), make([]byte, cw*ch), cw
+		for y := 0; y < ch; y++ {
+			row := f.Cb[y*f.CStride:][:2*cw]
+			for x := 0; x < cw; x++ {
+				img.Cb[y*cw+x], img.Cr[y*cw+x] = row[2*x], row[2*x+1]
+			}
+		}
+	}
+	return img
+}
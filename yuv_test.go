@@ -0,0 +1,126 @@
+package screenshot
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestConvertYUVColors(t *testing.T) {
+	tests := []struct {
+		opts      YUVOptions
+		c         color.RGBA
+		y, cb, cr uint8
+	}{
+		{YUVOptions{}, color.RGBA{0, 0, 0, 255}, 16, 128, 128},
+		{YUVOptions{}, color.RGBA{255, 255, 255, 255}, 235, 128, 128},
+		{YUVOptions{}, color.RGBA{255, 0, 0, 255}, 81, 90, 240},
+		{YUVOptions{FullRange: true}, color.RGBA{0, 0, 0, 255}, 0, 128, 128},
+		{YUVOptions{FullRange: true}, color.RGBA{255, 255, 255, 255}, 255, 128, 128},
+		{YUVOptions{FullRange: true}, color.RGBA{255, 0, 0, 255}, 76, 85, 255},
+		{YUVOptions{Matrix: BT709}, color.RGBA{255, 0, 0, 255}, 63, 102, 240},
+		{YUVOptions{Matrix: BT709, FullRange: true}, color.RGBA{255, 0, 0, 255}, 54, 99, 255},
+	}
+	for _, tt := range tests {
+		for _, format := range []PixelFormat{PixelFormatRGBA, PixelFormatBGRA} {
+			src := uniformFrame(format, 4, 2, tt.c)
+			dst := newYUVFrame(PixelFormatI420, 4, 2, tt.opts)
+			convertYUV(dst, src)
+			if dst.Pix[0] != tt.y || dst.Cb[0] != tt.cb || dst.Cr[0] != tt.cr {
+				t.Errorf("%+v of %v in %v = (%d, %d, %d), want (%d, %d, %d)", tt.opts, tt.c, format, dst.Pix[0], dst.Cb[0], dst.Cr[0], tt.y, tt.cb, tt.cr)
+			}
+		}
+	}
+}
+
+// uniformFrame returns a frame of width by height pixels of color c.
+func uniformFrame(format PixelFormat, width, height int, c color.RGBA) *Frame {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	if format == PixelFormatBGRA {
+		swapRedBlue(img)
+	}
+	return &Frame{Pix: img.Pix, Stride: img.Stride, Width: width, Height: height, Format: format}
+}
+
+func TestConvertYUVLayout(t *testing.T) {
+	for _, size := range []struct{ width, height int }{{1, 1}, {3, 3}, {4, 2}, {5, 4}, {1024, 600}} {
+		t.Run(fmt.Sprintf("%dx%d", size.width, size.height), func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, size.width, size.height))
+			for i := range src.Pix {
+				src.Pix[i] = byte(i * 7)
+			}
+			frame := &Frame{Pix: src.Pix, Stride: src.Stride, Width: size.width, Height: size.height}
+			opts := YUVOptions{FullRange: true}
+			i420 := newYUVFrame(PixelFormatI420, size.width, size.height, opts)
+			convertYUV(i420, frame)
+			nv12 := newYUVFrame(PixelFormatNV12, size.width, size.height, opts)
+			convertYUV(nv12, frame)
+
+			c := opts.coefficients()
+			for y := 0; y < size.height; y++ {
+				for x := 0; x < size.width; x++ {
+					p := src.RGBAAt(x, y)
+					if got, want := i420.Pix[y*i420.Stride+x], c.luma(p.R, p.G, p.B); got != want {
+						t.Fatalf("Y of (%d, %d) = %d, want %d", x, y, got, want)
+					}
+				}
+			}
+			// The chroma of each 2x2 block, cut short on the edges of odd sizes.
+			cw, ch := (size.width+1)/2, (size.height+1)/2
+			for cy := 0; cy < ch; cy++ {
+				for cx := 0; cx < cw; cx++ {
+					var r, g, b, n int32
+					for y := 2 * cy; y < min(2*cy+2, size.height); y++ {
+						for x := 2 * cx; x < min(2*cx+2, size.width); x++ {
+							p := src.RGBAAt(x, y)
+							r, g, b, n = r+int32(p.R), g+int32(p.G), b+int32(p.B), n+1
+						}
+					}
+					cb, cr := c.chroma(r, g, b, n)
+					i := cy*i420.CStride + cx
+					if i420.Cb[i] != cb || i420.Cr[i] != cr {
+						t.Fatalf("I420 chroma of block (%d, %d) = (%d, %d), want (%d, %d)", cx, cy, i420.Cb[i], i420.Cr[i], cb, cr)
+					}
+					j := cy*nv12.CStride + 2*cx
+					if nv12.Cb[j] != cb || nv12.Cb[j+1] != cr {
+						t.Fatalf("NV12 chroma of block (%d, %d) = (%d, %d), want (%d, %d)", cx, cy, nv12.Cb[j], nv12.Cb[j+1], cb, cr)
+					}
+				}
+			}
+			if !bytes.Equal(nv12.Pix, i420.Pix) {
+				t.Fatal("NV12 and I420 luma differ")
+			}
+
+			// Both formats make the same image.YCbCr, sharing the planes of I420.
+			a, b := i420.Image().(*image.YCbCr), nv12.Image().(*image.YCbCr)
+			if &a.Cb[0] != &i420.Cb[0] {
+				t.Error("image of I420 does not share its planes")
+			}
+			if !bytes.Equal(a.Y, b.Y) || !bytes.Equal(a.Cb, b.Cb) || !bytes.Equal(a.Cr, b.Cr) || a.CStride != b.CStride {
+				t.Error("images of NV12 and I420 differ")
+			}
+			if a.Bounds() != src.Bounds() {
+				t.Errorf("bounds = %v, want %v", a.Bounds(), src.Bounds())
+			}
+		})
+	}
+}
+
+func BenchmarkConvertYUV(b *testing.B) {
+	const width, height = 1920, 1080
+	src := &Frame{Pix: make([]byte, width*height*4), Stride: width * 4, Width: width, Height: height, Format: PixelFormatBGRA}
+	for _, format := range []PixelFormat{PixelFormatI420, PixelFormatNV12} {
+		b.Run(format.String(), func(b *testing.B) {
+			dst := newYUVFrame(format, width, height, YUVOptions{})
+			b.SetBytes(int64(len(src.Pix)))
+			for i := 0; i < b.N; i++ {
+				convertYUV(dst, src)
+			}
+		})
+	}
+}